@@ -0,0 +1,55 @@
+package ebpf
+
+import (
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// DefaultMaxAddrLength is the default maximum length in bytes of the formatted addresses,
+// far above the length of any IP address
+const DefaultMaxAddrLength = 256
+
+// defaultAddrTruncator truncates the addresses of MarshalCSV and TruncateAddr
+var defaultAddrTruncator = NewAddrTruncator(DefaultMaxAddrLength)
+
+// AddrTruncator truncates the formatted addresses longer than its maximum length, which can only come
+// from malformed probe data, and counts them. It is safe for concurrent use.
+type AddrTruncator struct {
+	maxLength int
+	truncated int64
+}
+
+// NewAddrTruncator returns an AddrTruncator cutting the addresses to maxLength bytes,
+// zero or less disables the limit
+func NewAddrTruncator(maxLength int) *AddrTruncator {
+	return &AddrTruncator{maxLength: maxLength}
+}
+
+// Truncate returns the address cut to the maximum length without splitting a UTF-8 character
+func (t *AddrTruncator) Truncate(addr string) string {
+	if t.maxLength <= 0 || len(addr) <= t.maxLength {
+		return addr
+	}
+	atomic.AddInt64(&t.truncated, 1)
+	end := t.maxLength
+	for end > 0 && !utf8.RuneStart(addr[end]) {
+		end--
+	}
+	return addr[:end]
+}
+
+// Truncated returns the number of addresses truncated so far
+func (t *AddrTruncator) Truncated() int64 {
+	return atomic.LoadInt64(&t.truncated)
+}
+
+// TruncateAddr truncates the address to DefaultMaxAddrLength like MarshalCSV does
+func TruncateAddr(addr string) string {
+	return defaultAddrTruncator.Truncate(addr)
+}
+
+// TruncatedAddrs returns the number of addresses truncated by MarshalCSV and TruncateAddr
+// because they exceeded DefaultMaxAddrLength
+func TruncatedAddrs() int64 {
+	return defaultAddrTruncator.Truncated()
+}
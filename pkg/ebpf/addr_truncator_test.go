@@ -0,0 +1,22 @@
+package ebpf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddrTruncatorKeepsCharactersWhole(t *testing.T) {
+	truncator := NewAddrTruncator(4)
+	// é is two bytes long
+	assert.Equal(t, "abé", truncator.Truncate("abéz"))
+	assert.Equal(t, "abc", truncator.Truncate("abcéz"))
+	assert.Equal(t, "abcd", truncator.Truncate("abcd"))
+	assert.Equal(t, int64(2), truncator.Truncated())
+}
+
+func TestAddrTruncatorWithoutLimit(t *testing.T) {
+	truncator := NewAddrTruncator(0)
+	assert.Equal(t, "abcdef", truncator.Truncate("abcdef"))
+	assert.Equal(t, int64(0), truncator.Truncated())
+}
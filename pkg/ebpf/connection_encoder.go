@@ -0,0 +1,54 @@
+package ebpf
+
+import (
+	"github.com/mailru/easyjson/jwriter"
+)
+
+// ConnectionEncoder encodes connections one at a time as they are collected, so that only the
+// document being encoded is held in memory instead of a whole Connections
+type ConnectionEncoder interface {
+	// Add appends the connection to the document being encoded
+	Add(conn ConnectionStats) error
+	// Finish returns the document holding the connections added since the last call to Finish,
+	// the next connection added starts a new document
+	Finish() ([]byte, error)
+}
+
+// jsonConnectionEncoder encodes the connections like the JSON encoding of Connections
+type jsonConnectionEncoder struct {
+	w     jwriter.Writer
+	count int
+}
+
+// NewJSONConnectionEncoder returns a ConnectionEncoder building the JSON encoding of Connections,
+// a document without connections holds an empty list
+func NewJSONConnectionEncoder() ConnectionEncoder {
+	return &jsonConnectionEncoder{}
+}
+
+// Add appends the connection to the document
+func (e *jsonConnectionEncoder) Add(conn ConnectionStats) error {
+	if e.count == 0 {
+		e.w.RawString(`{"connections":[`)
+	} else {
+		e.w.RawByte(',')
+	}
+	conn.MarshalEasyJSON(&e.w)
+	e.count++
+	return e.w.Error
+}
+
+// Finish returns the document and starts a new one
+func (e *jsonConnectionEncoder) Finish() ([]byte, error) {
+	if e.count == 0 {
+		e.w.RawString(`{"connections":[`)
+	}
+	e.w.RawString("]}")
+	data, err := e.w.Buffer.BuildBytes(), e.w.Error
+	e.w = jwriter.Writer{}
+	e.count = 0
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
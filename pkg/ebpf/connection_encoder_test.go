@@ -0,0 +1,38 @@
+package ebpf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONConnectionEncoder(t *testing.T) {
+	conns := []ConnectionStats{
+		{Pid: 1, Source: "10.0.0.1", Dest: "10.0.0.2", SPort: 4242, DPort: 443, Direction: OUTGOING},
+		{Pid: 2, Source: "10.0.0.1", Dest: "10.0.0.3", SPort: 4243, DPort: 53, Type: UDP},
+		{Pid: 3, Source: "10.0.0.1", Dest: "10.0.0.4", SPort: 4244, DPort: 80},
+	}
+	enc := NewJSONConnectionEncoder()
+
+	for _, c := range conns[:2] {
+		require.NoError(t, enc.Add(c))
+	}
+	data, err := enc.Finish()
+	require.NoError(t, err)
+	expected, err := Connections{Conns: conns[:2]}.MarshalJSON()
+	require.NoError(t, err)
+	assert.Equal(t, string(expected), string(data))
+
+	// the next connections start a new document
+	require.NoError(t, enc.Add(conns[2]))
+	data, err = enc.Finish()
+	require.NoError(t, err)
+	var decoded Connections
+	require.NoError(t, decoded.UnmarshalJSON(data))
+	assert.Equal(t, []ConnectionStats{conns[2]}, decoded.Conns)
+
+	data, err = enc.Finish()
+	require.NoError(t, err)
+	assert.Equal(t, `{"connections":[]}`, string(data))
+}
@@ -0,0 +1,112 @@
+package ebpf
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+)
+
+var csvHeader = []string{
+	"pid", "laddr", "lport", "raddr", "rport", "family", "type", "direction", "bytes_sent", "bytes_recv", "retransmits",
+}
+
+// MarshalCSV returns the connections as CSV with a header row and one row per connection,
+// the counters are the monotonic ones. Missing addresses are left empty, and the addresses
+// longer than DefaultMaxAddrLength are truncated.
+func MarshalCSV(conns *Connections) ([]byte, error) {
+	return marshalCSV(conns, defaultAddrTruncator)
+}
+
+// NewCSVEncoder returns an Encoder marshaling the connections like MarshalCSV,
+// the addresses being truncated by the truncator instead
+func NewCSVEncoder(truncator *AddrTruncator) Encoder {
+	return EncoderFunc(func(conns *Connections) ([]byte, error) {
+		return marshalCSV(conns, truncator)
+	})
+}
+
+func marshalCSV(conns *Connections, truncator *AddrTruncator) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	if err := w.Write(csvHeader); err != nil {
+		return nil, err
+	}
+
+	row := make([]string, len(csvHeader))
+	for _, c := range connectionsOf(conns) {
+		row[0] = strconv.FormatUint(uint64(c.Pid), 10)
+		row[1] = truncator.Truncate(formatAddr(c.Source))
+		row[2] = strconv.FormatUint(uint64(c.SPort), 10)
+		row[3] = truncator.Truncate(formatAddr(c.Dest))
+		row[4] = strconv.FormatUint(uint64(c.DPort), 10)
+		row[5] = csvFamily(c.Family)
+		row[6] = csvType(c.Type)
+		row[7] = csvDirection(c.Direction)
+		row[8] = strconv.FormatUint(c.MonotonicSentBytes, 10)
+		row[9] = strconv.FormatUint(c.MonotonicRecvBytes, 10)
+		row[10] = strconv.FormatUint(uint64(c.MonotonicRetransmits), 10)
+
+		if err := w.Write(row); err != nil {
+			return nil, err
+		}
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// unspecified is the label of the values that are neither of the known ones, it matches the
+// unspecified direction of the process agent payloads so that they are not mistaken for a known value
+const unspecified = "unspecified"
+
+// csvFamily returns the label of the family, like formatFamily in pkg/process/checks
+func csvFamily(f ConnectionFamily) string {
+	switch f {
+	case AFINET, AFINET6:
+		return f.String()
+	default:
+		return unspecified
+	}
+}
+
+// csvType returns the label of the type, like formatType in pkg/process/checks
+func csvType(t ConnectionType) string {
+	switch t {
+	case TCP, UDP:
+		return t.String()
+	default:
+		return unspecified
+	}
+}
+
+// csvDirection returns the label of the direction, like formatDirection in pkg/process/checks
+func csvDirection(d ConnectionDirection) string {
+	switch d {
+	case INCOMING, OUTGOING, LOCAL:
+		return d.String()
+	default:
+		return unspecified
+	}
+}
+
+// formatAddr returns the string representation of an address, which is either an Address
+// or a string once the connections have been unmarshaled
+func formatAddr(addr interface{}) string {
+	switch a := addr.(type) {
+	case nil:
+		return ""
+	case util.Address:
+		return a.String()
+	case string:
+		return a
+	default:
+		return fmt.Sprintf("%v", a)
+	}
+}
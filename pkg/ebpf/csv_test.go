@@ -0,0 +1,106 @@
+package ebpf
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+)
+
+func TestMarshalCSV(t *testing.T) {
+	conns := &Connections{
+		Conns: []ConnectionStats{
+			{
+				Pid:                  42,
+				Source:               util.AddressFromString("10.0.0.1"),
+				SPort:                4242,
+				Dest:                 util.AddressFromString("2001:db8::1"),
+				DPort:                443,
+				Family:               AFINET6,
+				Type:                 TCP,
+				Direction:            OUTGOING,
+				MonotonicSentBytes:   100,
+				LastSentBytes:        10,
+				MonotonicRecvBytes:   200,
+				LastRecvBytes:        20,
+				MonotonicRetransmits: 3,
+				LastRetransmits:      1,
+			},
+			{
+				// unmarshaled connections hold the addresses as strings
+				Pid:       1,
+				Source:    "10.0.0.2",
+				SPort:     53,
+				DPort:     5353,
+				Family:    AFINET,
+				Type:      UDP,
+				Direction: INCOMING,
+			},
+			{
+				Pid:       2,
+				Source:    "weird,address",
+				Dest:      "\"quoted\"",
+				Direction: LOCAL,
+			},
+			{
+				// the direction is not known yet
+				Pid:    3,
+				Family: AFINET,
+				Type:   TCP,
+			},
+			{
+				Pid:       4,
+				Family:    ConnectionFamily(7),
+				Type:      ConnectionType(7),
+				Direction: ConnectionDirection(7),
+			},
+		},
+	}
+
+	out, err := MarshalCSV(conns)
+	require.NoError(t, err)
+
+	expected := "pid,laddr,lport,raddr,rport,family,type,direction,bytes_sent,bytes_recv,retransmits\n" +
+		"42,10.0.0.1,4242,2001:db8::1,443,v6,TCP,outgoing,100,200,3\n" +
+		"1,10.0.0.2,53,,5353,v4,UDP,incoming,0,0,0\n" +
+		"2,\"weird,address\",0,\"\"\"quoted\"\"\",0,v4,TCP,local,0,0,0\n" +
+		"3,,0,,0,v4,TCP,unspecified,0,0,0\n" +
+		"4,,0,,0,unspecified,unspecified,unspecified,0,0,0\n"
+	assert.Equal(t, expected, string(out))
+}
+
+func TestMarshalCSVEmpty(t *testing.T) {
+	out, err := MarshalCSV(&Connections{})
+	require.NoError(t, err)
+	assert.Equal(t, "pid,laddr,lport,raddr,rport,family,type,direction,bytes_sent,bytes_recv,retransmits\n", string(out))
+}
+
+func TestMarshalCSVTruncatesLongAddresses(t *testing.T) {
+	long := strings.Repeat("a", DefaultMaxAddrLength+10)
+	conns := &Connections{
+		Conns: []ConnectionStats{
+			{Pid: 1, Source: long, Dest: "10.0.0.1"},
+		},
+	}
+
+	truncated := TruncatedAddrs()
+	out, err := MarshalCSV(conns)
+	require.NoError(t, err)
+	assert.Contains(t, string(out), "\n1,"+long[:DefaultMaxAddrLength]+",0,10.0.0.1,")
+	assert.NotContains(t, string(out), long)
+	assert.True(t, TruncatedAddrs() > truncated)
+
+	truncator := NewAddrTruncator(10)
+	out, err = NewCSVEncoder(truncator).Encode(conns)
+	require.NoError(t, err)
+	assert.Contains(t, string(out), "\n1,"+long[:10]+",0,10.0.0.1,")
+	assert.Equal(t, int64(1), truncator.Truncated())
+
+	// the limit can be disabled
+	out, err = NewCSVEncoder(NewAddrTruncator(0)).Encode(conns)
+	require.NoError(t, err)
+	assert.Contains(t, string(out), long)
+}
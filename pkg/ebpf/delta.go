@@ -0,0 +1,136 @@
+package ebpf
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// connectionsDelta holds the changes between two snapshots of connections
+type connectionsDelta struct {
+	// Added holds the new connections, and the ones that changed otherwise than by their counters growing
+	Added []ConnectionStats `json:"added,omitempty"`
+	// Removed holds the keys of the connections that are gone
+	Removed []string `json:"removed,omitempty"`
+	// Changed holds the counter changes of the other connections
+	Changed []connectionDelta `json:"changed,omitempty"`
+}
+
+// connectionDelta holds the counter changes of a connection, the monotonic counters and
+// the update epoch are encoded as increments while the last counters are encoded as is
+type connectionDelta struct {
+	Key                  string `json:"key"`
+	MonotonicSentBytes   uint64 `json:"m_sent_b,omitempty"`
+	LastSentBytes        uint64 `json:"sent_b,omitempty"`
+	MonotonicRecvBytes   uint64 `json:"m_recv_b,omitempty"`
+	LastRecvBytes        uint64 `json:"recv_b,omitempty"`
+	LastUpdateEpoch      uint64 `json:"epoch,omitempty"`
+	MonotonicRetransmits uint32 `json:"m_retr,omitempty"`
+	LastRetransmits      uint32 `json:"retr,omitempty"`
+}
+
+// DeltaKey returns the key identifying the connection in the deltas,
+// it is built from the pid, the addresses and ports, the family and the type of the connection
+// and does not depend on whether the addresses are held as util.Address or as strings
+func (c ConnectionStats) DeltaKey() string {
+	return fmt.Sprintf(keyFmt, c.Pid, c.Source, c.SPort, c.Dest, c.DPort, c.Family, c.Type)
+}
+
+// MarshalDelta encodes the changes from prev to curr: the added and removed connections,
+// and only the counter changes of the connections present in both snapshots
+func MarshalDelta(prev, curr *Connections) ([]byte, error) {
+	previous := make(map[string]ConnectionStats, len(connectionsOf(prev)))
+	for _, c := range connectionsOf(prev) {
+		previous[c.DeltaKey()] = c
+	}
+
+	delta := connectionsDelta{}
+	seen := make(map[string]struct{}, len(connectionsOf(curr)))
+	for _, c := range connectionsOf(curr) {
+		key := c.DeltaKey()
+		seen[key] = struct{}{}
+		p, ok := previous[key]
+		if !ok || !countersGrew(p, c) || !sameAttributes(p, c) {
+			delta.Added = append(delta.Added, c)
+			continue
+		}
+		if p.Equal(c) {
+			continue
+		}
+		delta.Changed = append(delta.Changed, connectionDelta{
+			Key:                  key,
+			MonotonicSentBytes:   c.MonotonicSentBytes - p.MonotonicSentBytes,
+			LastSentBytes:        c.LastSentBytes,
+			MonotonicRecvBytes:   c.MonotonicRecvBytes - p.MonotonicRecvBytes,
+			LastRecvBytes:        c.LastRecvBytes,
+			LastUpdateEpoch:      c.LastUpdateEpoch - p.LastUpdateEpoch,
+			MonotonicRetransmits: c.MonotonicRetransmits - p.MonotonicRetransmits,
+			LastRetransmits:      c.LastRetransmits,
+		})
+	}
+	for _, c := range connectionsOf(prev) {
+		key := c.DeltaKey()
+		if _, ok := seen[key]; !ok {
+			delta.Removed = append(delta.Removed, key)
+		}
+	}
+
+	return json.Marshal(delta)
+}
+
+// ApplyDelta applies a delta encoded by MarshalDelta to prev and returns the resulting snapshot,
+// the remaining connections keep their order in prev and the added ones are appended in their order in curr
+func ApplyDelta(prev *Connections, data []byte) (*Connections, error) {
+	delta := connectionsDelta{}
+	if err := json.Unmarshal(data, &delta); err != nil {
+		return nil, err
+	}
+
+	removed := make(map[string]struct{}, len(delta.Removed)+len(delta.Added))
+	for _, key := range delta.Removed {
+		removed[key] = struct{}{}
+	}
+	for _, c := range delta.Added {
+		// connections that changed otherwise than by their counters growing are replaced
+		removed[c.DeltaKey()] = struct{}{}
+	}
+	changed := make(map[string]connectionDelta, len(delta.Changed))
+	for _, d := range delta.Changed {
+		changed[d.Key] = d
+	}
+
+	curr := &Connections{Conns: make([]ConnectionStats, 0, len(connectionsOf(prev))+len(delta.Added))}
+	for _, c := range connectionsOf(prev) {
+		key := c.DeltaKey()
+		if _, ok := removed[key]; ok {
+			continue
+		}
+		if d, ok := changed[key]; ok {
+			c.MonotonicSentBytes += d.MonotonicSentBytes
+			c.LastSentBytes = d.LastSentBytes
+			c.MonotonicRecvBytes += d.MonotonicRecvBytes
+			c.LastRecvBytes = d.LastRecvBytes
+			c.LastUpdateEpoch += d.LastUpdateEpoch
+			c.MonotonicRetransmits += d.MonotonicRetransmits
+			c.LastRetransmits = d.LastRetransmits
+		}
+		curr.Conns = append(curr.Conns, c)
+	}
+	curr.Conns = append(curr.Conns, delta.Added...)
+	return curr, nil
+}
+
+// countersGrew returns true if none of the monotonic counters of the connection went backwards
+func countersGrew(prev, curr ConnectionStats) bool {
+	return curr.MonotonicSentBytes >= prev.MonotonicSentBytes &&
+		curr.MonotonicRecvBytes >= prev.MonotonicRecvBytes &&
+		curr.MonotonicRetransmits >= prev.MonotonicRetransmits &&
+		curr.LastUpdateEpoch >= prev.LastUpdateEpoch
+}
+
+// sameAttributes returns true if the connections only differ by their counters
+func sameAttributes(prev, curr ConnectionStats) bool {
+	if prev.NetNS != curr.NetNS || prev.Direction != curr.Direction {
+		return false
+	}
+	return equalIPTranslation(prev.IPTranslation, curr.IPTranslation)
+}
@@ -0,0 +1,98 @@
+package ebpf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/DataDog/datadog-agent/pkg/ebpf/netlink"
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+)
+
+func TestConnectionsDelta(t *testing.T) {
+	newConn := func(pid uint32, sent, recv uint64) ConnectionStats {
+		return ConnectionStats{
+			Pid:                pid,
+			Source:             util.AddressFromString("10.0.0.1"),
+			SPort:              uint16(1000 + pid),
+			Dest:               util.AddressFromString("10.0.0.2"),
+			DPort:              443,
+			Family:             AFINET,
+			Type:               TCP,
+			Direction:          OUTGOING,
+			MonotonicSentBytes: sent,
+			LastSentBytes:      sent,
+			MonotonicRecvBytes: recv,
+			LastRecvBytes:      recv,
+			LastUpdateEpoch:    uint64(pid),
+		}
+	}
+
+	unchanged := newConn(1, 10, 20)
+	grown := newConn(2, 10, 20)
+	reset := newConn(3, 10, 20)
+	translated := newConn(4, 10, 20)
+	gone := newConn(5, 10, 20)
+	prev := &Connections{Conns: []ConnectionStats{unchanged, grown, reset, translated, gone}}
+
+	grown.MonotonicSentBytes += 5
+	grown.LastSentBytes = 5
+	grown.MonotonicRetransmits = 1
+	grown.LastRetransmits = 1
+	grown.LastUpdateEpoch += 10
+	reset.MonotonicSentBytes = 1
+	translated.IPTranslation = &netlink.IPTranslation{ReplSrcIP: "10.0.0.3", ReplSrcPort: 80}
+	added := newConn(6, 1, 2)
+	added.Type = UDP
+	curr := &Connections{Conns: []ConnectionStats{unchanged, grown, reset, translated, added}}
+
+	delta, err := MarshalDelta(prev, curr)
+	require.NoError(t, err)
+
+	result, err := ApplyDelta(prev, delta)
+	require.NoError(t, err)
+	require.Len(t, result.Conns, 5)
+	assert.Equal(t, grown, result.Conns[1])
+
+	// the replaced and added connections come after the remaining ones, as in curr
+	expectedJSON, err := curr.MarshalJSON()
+	require.NoError(t, err)
+	resultJSON, err := result.MarshalJSON()
+	require.NoError(t, err)
+	assert.JSONEq(t, string(expectedJSON), string(resultJSON))
+}
+
+func TestConnectionsDeltaOnlyHoldsChanges(t *testing.T) {
+	conns := &Connections{
+		Conns: []ConnectionStats{
+			{Pid: 1, Source: "10.0.0.1", Dest: "10.0.0.2", MonotonicSentBytes: 10},
+			{Pid: 2, Source: "10.0.0.1", Dest: "10.0.0.2", MonotonicSentBytes: 20},
+		},
+	}
+
+	delta, err := MarshalDelta(conns, conns)
+	require.NoError(t, err)
+	assert.Equal(t, "{}", string(delta))
+
+	result, err := ApplyDelta(conns, delta)
+	require.NoError(t, err)
+	assert.Equal(t, conns, result)
+}
+
+func TestDeltaKey(t *testing.T) {
+	conn := ConnectionStats{
+		Pid:    42,
+		Source: util.AddressFromString("10.0.0.1"),
+		SPort:  4242,
+		Dest:   util.AddressFromString("2001:db8::1"),
+		DPort:  443,
+		Family: AFINET6,
+		Type:   TCP,
+	}
+	assert.Equal(t, "p:42|src:10.0.0.1:4242|dst:2001:db8::1:443|f:1|t:0", conn.DeltaKey())
+
+	// unmarshaled connections hold the addresses as strings
+	conn.Source, conn.Dest = "10.0.0.1", "2001:db8::1"
+	assert.Equal(t, "p:42|src:10.0.0.1:4242|dst:2001:db8::1:443|f:1|t:0", conn.DeltaKey())
+}
@@ -0,0 +1,62 @@
+package ebpf
+
+import (
+	"fmt"
+
+	"github.com/DataDog/datadog-agent/pkg/ebpf/netlink"
+)
+
+// FieldDiff describes a field that differs between two connections
+type FieldDiff struct {
+	Field string
+	A, B  interface{}
+}
+
+func (d FieldDiff) String() string {
+	return fmt.Sprintf("%s: %v != %v", d.Field, d.A, d.B)
+}
+
+// Equal returns true if the connections have the same fields, the addresses are compared
+// by value whether they are held as util.Address or as strings
+func (c ConnectionStats) Equal(other ConnectionStats) bool {
+	return len(c.Diff(other)) == 0
+}
+
+// Diff returns the fields that differ between the connections, in the order of the struct
+func (c ConnectionStats) Diff(other ConnectionStats) []FieldDiff {
+	var diffs []FieldDiff
+	add := func(field string, a, b interface{}) {
+		if a != b {
+			diffs = append(diffs, FieldDiff{Field: field, A: a, B: b})
+		}
+	}
+
+	add("Source", formatAddr(c.Source), formatAddr(other.Source))
+	add("Dest", formatAddr(c.Dest), formatAddr(other.Dest))
+	add("MonotonicSentBytes", c.MonotonicSentBytes, other.MonotonicSentBytes)
+	add("LastSentBytes", c.LastSentBytes, other.LastSentBytes)
+	add("MonotonicRecvBytes", c.MonotonicRecvBytes, other.MonotonicRecvBytes)
+	add("LastRecvBytes", c.LastRecvBytes, other.LastRecvBytes)
+	add("LastUpdateEpoch", c.LastUpdateEpoch, other.LastUpdateEpoch)
+	add("MonotonicRetransmits", c.MonotonicRetransmits, other.MonotonicRetransmits)
+	add("LastRetransmits", c.LastRetransmits, other.LastRetransmits)
+	add("Pid", c.Pid, other.Pid)
+	add("NetNS", c.NetNS, other.NetNS)
+	add("SPort", c.SPort, other.SPort)
+	add("DPort", c.DPort, other.DPort)
+	add("Type", c.Type, other.Type)
+	add("Family", c.Family, other.Family)
+	add("Direction", c.Direction, other.Direction)
+	if !equalIPTranslation(c.IPTranslation, other.IPTranslation) {
+		diffs = append(diffs, FieldDiff{Field: "IPTranslation", A: c.IPTranslation, B: other.IPTranslation})
+	}
+	return diffs
+}
+
+// equalIPTranslation compares the translations by value
+func equalIPTranslation(a, b *netlink.IPTranslation) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
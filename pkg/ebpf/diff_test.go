@@ -0,0 +1,51 @@
+package ebpf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DataDog/datadog-agent/pkg/ebpf/netlink"
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+)
+
+func TestConnectionStatsEqual(t *testing.T) {
+	a := ConnectionStats{
+		Pid:                42,
+		Source:             util.AddressFromString("10.0.0.1"),
+		SPort:              4242,
+		Dest:               util.AddressFromString("10.0.0.2"),
+		DPort:              443,
+		MonotonicSentBytes: 10,
+		IPTranslation:      &netlink.IPTranslation{ReplSrcIP: "10.0.0.3", ReplSrcPort: 80},
+	}
+	b := a
+	assert.True(t, a.Equal(b))
+	assert.Empty(t, a.Diff(b))
+
+	// the addresses and translations are compared by value
+	b.Source = util.AddressFromString("10.0.0.1")
+	b.Dest = "10.0.0.2"
+	b.IPTranslation = &netlink.IPTranslation{ReplSrcIP: "10.0.0.3", ReplSrcPort: 80}
+	assert.True(t, a.Equal(b))
+
+	b.MonotonicSentBytes = 20
+	b.Dest = util.AddressFromString("10.0.0.4")
+	assert.False(t, a.Equal(b))
+	assert.Equal(t, []FieldDiff{
+		{Field: "Dest", A: "10.0.0.2", B: "10.0.0.4"},
+		{Field: "MonotonicSentBytes", A: uint64(10), B: uint64(20)},
+	}, a.Diff(b))
+}
+
+func TestConnectionStatsDiffIPTranslation(t *testing.T) {
+	translation := &netlink.IPTranslation{ReplSrcIP: "10.0.0.3"}
+	a := ConnectionStats{}
+	b := ConnectionStats{IPTranslation: translation}
+
+	assert.True(t, a.Equal(ConnectionStats{}))
+	assert.False(t, a.Equal(b))
+	assert.False(t, b.Equal(a))
+	assert.Equal(t, []FieldDiff{{Field: "IPTranslation", A: (*netlink.IPTranslation)(nil), B: translation}}, a.Diff(b))
+	assert.Equal(t, "IPTranslation: <nil> != &{10.0.0.3  0 0}", a.Diff(b)[0].String())
+}
@@ -0,0 +1,120 @@
+package ebpf
+
+// DefaultEphemeralPortMin is the first port of the default ephemeral port range of Linux
+const DefaultEphemeralPortMin = 32768
+
+// DefaultServicePorts are the ports of the common services the PortDirectionInferrer knows of
+var DefaultServicePorts = []uint16{
+	21,    // ftp
+	22,    // ssh
+	25,    // smtp
+	53,    // dns
+	80,    // http
+	110,   // pop3
+	123,   // ntp
+	143,   // imap
+	443,   // https
+	3306,  // mysql
+	5432,  // postgresql
+	6379,  // redis
+	8080,  // http alternate
+	8126,  // datadog trace agent
+	9042,  // cassandra
+	9092,  // kafka
+	11211, // memcached
+	27017, // mongodb
+}
+
+// DirectionInferrer guesses the direction of a connection the probe could not determine,
+// it returns zero when it can not guess it either
+type DirectionInferrer interface {
+	InferDirection(c ConnectionStats) ConnectionDirection
+}
+
+// DirectionInferrerFunc adapts a function into a DirectionInferrer
+type DirectionInferrerFunc func(c ConnectionStats) ConnectionDirection
+
+// InferDirection calls f(c)
+func (f DirectionInferrerFunc) InferDirection(c ConnectionStats) ConnectionDirection {
+	return f(c)
+}
+
+// PortDirectionInferrer infers the direction of a connection from its ports, the side using a service
+// port being the server: a connection from a service port of the host is incoming and a connection to
+// a service port is outgoing. Ports below 1024 and the ones in ServicePorts are service ports,
+// ports from EphemeralPortMin are client ports.
+type PortDirectionInferrer struct {
+	ServicePorts     map[uint16]struct{}
+	EphemeralPortMin uint16
+}
+
+// NewPortDirectionInferrer returns a PortDirectionInferrer knowing of the service ports,
+// with the default ephemeral port range of Linux
+func NewPortDirectionInferrer(servicePorts []uint16) *PortDirectionInferrer {
+	ports := make(map[uint16]struct{}, len(servicePorts))
+	for _, port := range servicePorts {
+		ports[port] = struct{}{}
+	}
+	return &PortDirectionInferrer{
+		ServicePorts:     ports,
+		EphemeralPortMin: DefaultEphemeralPortMin,
+	}
+}
+
+// InferDirection returns INCOMING when only the source port is a service port and OUTGOING when only
+// the destination one is, falling back to the ephemeral ports when both or none of them are
+func (p *PortDirectionInferrer) InferDirection(c ConnectionStats) ConnectionDirection {
+	sourceService, destService := p.isServicePort(c.SPort), p.isServicePort(c.DPort)
+	switch {
+	case sourceService && !destService:
+		return INCOMING
+	case destService && !sourceService:
+		return OUTGOING
+	}
+
+	sourceEphemeral, destEphemeral := p.isEphemeralPort(c.SPort), p.isEphemeralPort(c.DPort)
+	switch {
+	case sourceEphemeral && !destEphemeral:
+		return OUTGOING
+	case destEphemeral && !sourceEphemeral:
+		return INCOMING
+	default:
+		return 0
+	}
+}
+
+func (p *PortDirectionInferrer) isServicePort(port uint16) bool {
+	if port != 0 && port < 1024 {
+		return true
+	}
+	_, ok := p.ServicePorts[port]
+	return ok
+}
+
+func (p *PortDirectionInferrer) isEphemeralPort(port uint16) bool {
+	return p.EphemeralPortMin > 0 && port >= p.EphemeralPortMin
+}
+
+// InferDirections sets the direction guessed by the inferrer on the connections whose direction is unspecified,
+// the known directions are never changed. The original Connections is left untouched, and returned when
+// no direction could be guessed
+func InferDirections(conns *Connections, inferrer DirectionInferrer) *Connections {
+	var inferred *Connections
+	for i, c := range connectionsOf(conns) {
+		if c.Direction != 0 {
+			continue
+		}
+		d := inferrer.InferDirection(c)
+		if d == 0 {
+			continue
+		}
+		if inferred == nil {
+			inferred = &Connections{Conns: append([]ConnectionStats(nil), conns.Conns...)}
+		}
+		inferred.Conns[i].Direction = d
+	}
+	if inferred == nil {
+		return conns
+	}
+	return inferred
+}
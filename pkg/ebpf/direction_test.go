@@ -0,0 +1,72 @@
+package ebpf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPortDirectionInferrer(t *testing.T) {
+	inferrer := NewPortDirectionInferrer(DefaultServicePorts)
+
+	for _, tc := range []struct {
+		sport, dport uint16
+		expected     ConnectionDirection
+	}{
+		{sport: 443, dport: 51234, expected: INCOMING},
+		{sport: 51234, dport: 443, expected: OUTGOING},
+		{sport: 5432, dport: 12000, expected: INCOMING},
+		{sport: 12000, dport: 6379, expected: OUTGOING},
+		{sport: 12000, dport: 40000, expected: INCOMING},
+		{sport: 40000, dport: 12000, expected: OUTGOING},
+		{sport: 12000, dport: 13000, expected: 0},
+		{sport: 40000, dport: 50000, expected: 0},
+		{sport: 80, dport: 443, expected: 0},
+	} {
+		c := ConnectionStats{SPort: tc.sport, DPort: tc.dport}
+		assert.Equal(t, tc.expected, inferrer.InferDirection(c), "%d -> %d", tc.sport, tc.dport)
+	}
+}
+
+func TestPortDirectionInferrerServicePorts(t *testing.T) {
+	c := ConnectionStats{SPort: 12000, DPort: 9999}
+	assert.Equal(t, ConnectionDirection(0), NewPortDirectionInferrer(nil).InferDirection(c))
+	assert.Equal(t, OUTGOING, NewPortDirectionInferrer([]uint16{9999}).InferDirection(c))
+}
+
+func TestInferDirections(t *testing.T) {
+	conns := &Connections{Conns: []ConnectionStats{
+		{SPort: 443, DPort: 51234, Direction: OUTGOING},
+		{SPort: 443, DPort: 51234, Direction: LOCAL},
+		{SPort: 443, DPort: 51234},
+		{SPort: 51234, DPort: 443},
+		{SPort: 12000, DPort: 13000},
+	}}
+
+	inferred := InferDirections(conns, NewPortDirectionInferrer(DefaultServicePorts))
+	assert.Equal(t, []ConnectionDirection{OUTGOING, LOCAL, INCOMING, OUTGOING, 0}, directions(inferred))
+	assert.Equal(t, []ConnectionDirection{OUTGOING, LOCAL, 0, 0, 0}, directions(conns))
+}
+
+func TestInferDirectionsNeverOverridesKnownDirections(t *testing.T) {
+	conns := &Connections{Conns: []ConnectionStats{
+		{Direction: INCOMING},
+		{Direction: OUTGOING},
+		{Direction: LOCAL},
+	}}
+	inferrer := DirectionInferrerFunc(func(c ConnectionStats) ConnectionDirection {
+		assert.Fail(t, "the inferrer was called for a known direction")
+		return OUTGOING
+	})
+
+	assert.True(t, conns == InferDirections(conns, inferrer))
+	assert.Nil(t, InferDirections(nil, inferrer))
+}
+
+func directions(conns *Connections) []ConnectionDirection {
+	var directions []ConnectionDirection
+	for _, c := range conns.Conns {
+		directions = append(directions, c.Direction)
+	}
+	return directions
+}
@@ -0,0 +1,132 @@
+package ebpf
+
+import (
+	"bytes"
+	"errors"
+	"mime"
+	"strings"
+	"sync"
+)
+
+const (
+	// ContentTypeJSON is the content type of the JSON encoding of the connections
+	ContentTypeJSON = "application/json"
+	// ContentTypeCSV is the content type of the CSV encoding of the connections
+	ContentTypeCSV = "text/csv"
+	// ContentTypePrometheus is the content type of the Prometheus text exposition format
+	ContentTypePrometheus = "text/plain; version=0.0.4"
+	// ContentTypeProtobuf is the content type of the protobuf encoding of the connections, its encoder
+	// is registered by pkg/process/checks which maps the connections to the process-agent payload
+	ContentTypeProtobuf = "application/x-protobuf"
+)
+
+// ErrEmptyConnections is returned by Encode when there are no connections to encode,
+// so that callers can skip sending an empty payload
+var ErrEmptyConnections = errors.New("no connections to encode")
+
+// Encoder encodes connections into a wire format
+type Encoder interface {
+	Encode(conns *Connections) ([]byte, error)
+}
+
+// EncoderFunc adapts a function into an Encoder
+type EncoderFunc func(conns *Connections) ([]byte, error)
+
+// Encode calls f(conns)
+func (f EncoderFunc) Encode(conns *Connections) ([]byte, error) {
+	return f(conns)
+}
+
+var (
+	encodersMu sync.RWMutex
+	encoders   = map[string]registeredEncoder{}
+)
+
+type registeredEncoder struct {
+	contentType string
+	encoder     Encoder
+}
+
+func init() {
+	RegisterEncoder(ContentTypeJSON, EncoderFunc(func(conns *Connections) ([]byte, error) {
+		return conns.MarshalJSON()
+	}))
+	RegisterEncoder(ContentTypeCSV, EncoderFunc(MarshalCSV))
+	RegisterEncoder(ContentTypePrometheus, EncoderFunc(func(conns *Connections) ([]byte, error) {
+		var buf bytes.Buffer
+		err := WritePrometheus(&buf, conns)
+		return buf.Bytes(), err
+	}))
+}
+
+// RegisterEncoder registers the encoder for the content type, replacing any previous one,
+// the parameters of the content type are ignored when looking it up
+func RegisterEncoder(contentType string, enc Encoder) {
+	encodersMu.Lock()
+	defer encodersMu.Unlock()
+	encoders[mediaType(contentType)] = registeredEncoder{contentType: contentType, encoder: enc}
+}
+
+// EncodeOption configures a call to Encode
+type EncodeOption func(*encodeOptions)
+
+type encodeOptions struct {
+	directionInferrer DirectionInferrer
+}
+
+// WithDirectionInferrer lets Encode guess the direction of the connections the probe could not determine
+// with the inferrer, nil disables it which is the default
+func WithDirectionInferrer(inferrer DirectionInferrer) EncodeOption {
+	return func(o *encodeOptions) {
+		o.directionInferrer = inferrer
+	}
+}
+
+// Encode encodes the connections with the encoder registered for the content type and returns
+// the content type actually used. Unknown or empty content types fall back to JSON, which is
+// what the system-probe serves by default. ErrEmptyConnections is returned when conns is nil
+// or holds no connections. The unspecified directions are guessed first when WithDirectionInferrer is given.
+func Encode(contentType string, conns *Connections, opts ...EncodeOption) ([]byte, string, error) {
+	if len(connectionsOf(conns)) == 0 {
+		return nil, "", ErrEmptyConnections
+	}
+
+	var options encodeOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	encodersMu.RLock()
+	registered, ok := encoders[mediaType(contentType)]
+	if !ok {
+		registered = encoders[ContentTypeJSON]
+	}
+	encodersMu.RUnlock()
+
+	if options.directionInferrer != nil {
+		conns = InferDirections(conns, options.directionInferrer)
+	}
+
+	data, err := registered.encoder.Encode(conns)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, registered.contentType, nil
+}
+
+// connectionsOf returns the connections, nil connections have none
+func connectionsOf(conns *Connections) []ConnectionStats {
+	if conns == nil {
+		return nil
+	}
+	return conns.Conns
+}
+
+// mediaType returns the lower case media type without its parameters
+func mediaType(contentType string) string {
+	t, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(contentType))
+	}
+	return t
+}
@@ -0,0 +1,99 @@
+package ebpf
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncode(t *testing.T) {
+	conns := &Connections{Conns: []ConnectionStats{{Pid: 42, Source: "10.0.0.1", Dest: "10.0.0.2"}}}
+
+	data, contentType, err := Encode("application/json; charset=utf-8", conns)
+	require.NoError(t, err)
+	assert.Equal(t, ContentTypeJSON, contentType)
+	expected, err := conns.MarshalJSON()
+	require.NoError(t, err)
+	assert.Equal(t, expected, data)
+
+	data, contentType, err = Encode("text/csv", conns)
+	require.NoError(t, err)
+	assert.Equal(t, ContentTypeCSV, contentType)
+	expected, err = MarshalCSV(conns)
+	require.NoError(t, err)
+	assert.Equal(t, expected, data)
+
+	_, contentType, err = Encode("text/plain", conns)
+	require.NoError(t, err)
+	assert.Equal(t, ContentTypePrometheus, contentType)
+}
+
+func TestEncodeFallsBackToJSON(t *testing.T) {
+	conns := &Connections{Conns: []ConnectionStats{{Pid: 42, Source: "10.0.0.1", Dest: "10.0.0.2"}}}
+	expected, err := conns.MarshalJSON()
+	require.NoError(t, err)
+
+	for _, contentType := range []string{"", "application/x-unknown", "not a content type;;"} {
+		data, resolved, err := Encode(contentType, conns)
+		require.NoError(t, err)
+		assert.Equal(t, ContentTypeJSON, resolved, contentType)
+		assert.Equal(t, expected, data, contentType)
+	}
+}
+
+func TestRegisterEncoder(t *testing.T) {
+	RegisterEncoder("application/x-count", EncoderFunc(func(conns *Connections) ([]byte, error) {
+		return []byte{byte(len(conns.Conns))}, nil
+	}))
+	defer func() {
+		encodersMu.Lock()
+		delete(encoders, "application/x-count")
+		encodersMu.Unlock()
+	}()
+
+	data, contentType, err := Encode("Application/X-Count", &Connections{Conns: make([]ConnectionStats, 3)})
+	require.NoError(t, err)
+	assert.Equal(t, "application/x-count", contentType)
+	assert.Equal(t, []byte{3}, data)
+}
+
+func TestEncodeEmptyConnections(t *testing.T) {
+	for _, conns := range []*Connections{nil, {}, {Conns: []ConnectionStats{}}} {
+		data, contentType, err := Encode(ContentTypeJSON, conns)
+		assert.Equal(t, ErrEmptyConnections, err)
+		assert.Nil(t, data)
+		assert.Equal(t, "", contentType)
+	}
+}
+
+func TestMarshalersAcceptNilConnections(t *testing.T) {
+	csv, err := MarshalCSV(nil)
+	require.NoError(t, err)
+	empty, err := MarshalCSV(&Connections{})
+	require.NoError(t, err)
+	assert.Equal(t, empty, csv)
+
+	assert.NoError(t, WritePrometheus(ioutil.Discard, nil))
+
+	conns := &Connections{Conns: []ConnectionStats{{Pid: 42, Source: "10.0.0.1", Dest: "10.0.0.2"}}}
+	delta, err := MarshalDelta(nil, conns)
+	require.NoError(t, err)
+	applied, err := ApplyDelta(nil, delta)
+	require.NoError(t, err)
+	assert.Equal(t, conns, applied)
+}
+
+func TestEncodeInfersDirections(t *testing.T) {
+	conns := &Connections{Conns: []ConnectionStats{{Source: "10.0.0.1", Dest: "10.0.0.2", SPort: 51234, DPort: 443}}}
+
+	data, _, err := Encode(ContentTypeCSV, conns)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), ",unspecified,")
+
+	data, _, err = Encode(ContentTypeCSV, conns, WithDirectionInferrer(NewPortDirectionInferrer(DefaultServicePorts)))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), ",outgoing,")
+	assert.Equal(t, ConnectionDirection(0), conns.Conns[0].Direction)
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"strings"
 
 	"github.com/DataDog/datadog-agent/pkg/ebpf/netlink"
 	"github.com/DataDog/datadog-agent/pkg/process/util"
@@ -27,6 +28,18 @@ func (c ConnectionType) String() string {
 	return "UDP"
 }
 
+// ParseConnectionType returns the ConnectionType of a label, either tcp or udp, ignoring case
+func ParseConnectionType(s string) (ConnectionType, error) {
+	switch strings.ToLower(s) {
+	case "tcp":
+		return TCP, nil
+	case "udp":
+		return UDP, nil
+	default:
+		return 0, fmt.Errorf("unknown connection type %q", s)
+	}
+}
+
 const (
 	// AFINET represents v4 connections
 	AFINET ConnectionFamily = 0
@@ -38,6 +51,25 @@ const (
 // ConnectionFamily will be either v4 or v6
 type ConnectionFamily uint8
 
+func (c ConnectionFamily) String() string {
+	if c == AFINET6 {
+		return "v6"
+	}
+	return "v4"
+}
+
+// ParseConnectionFamily returns the ConnectionFamily of a label, either v4 or v6, ignoring case
+func ParseConnectionFamily(s string) (ConnectionFamily, error) {
+	switch strings.ToLower(s) {
+	case "v4":
+		return AFINET, nil
+	case "v6":
+		return AFINET6, nil
+	default:
+		return 0, fmt.Errorf("unknown connection family %q", s)
+	}
+}
+
 // ConnectionDirection indicates if the connection is incoming to the host or outbound
 type ConnectionDirection uint8
 
@@ -63,6 +95,20 @@ func (d ConnectionDirection) String() string {
 	}
 }
 
+// ParseConnectionDirection returns the ConnectionDirection of a label, either incoming, outgoing or local, ignoring case
+func ParseConnectionDirection(s string) (ConnectionDirection, error) {
+	switch strings.ToLower(s) {
+	case "incoming":
+		return INCOMING, nil
+	case "outgoing":
+		return OUTGOING, nil
+	case "local":
+		return LOCAL, nil
+	default:
+		return 0, fmt.Errorf("unknown connection direction %q", s)
+	}
+}
+
 // Connections wraps a collection of ConnectionStats
 //easyjson:json
 type Connections struct {
@@ -117,3 +117,40 @@ func TestConnStatsByteKey(t *testing.T) {
 		assert.NotEqual(t, keyA, keyB)
 	}
 }
+
+func TestParseConnectionType(t *testing.T) {
+	for _, c := range []ConnectionType{TCP, UDP} {
+		parsed, err := ParseConnectionType(c.String())
+		require.NoError(t, err)
+		assert.Equal(t, c, parsed)
+	}
+
+	parsed, err := ParseConnectionType("udp")
+	require.NoError(t, err)
+	assert.Equal(t, UDP, parsed)
+
+	_, err = ParseConnectionType("sctp")
+	assert.EqualError(t, err, `unknown connection type "sctp"`)
+}
+
+func TestParseConnectionFamily(t *testing.T) {
+	for _, f := range []ConnectionFamily{AFINET, AFINET6} {
+		parsed, err := ParseConnectionFamily(f.String())
+		require.NoError(t, err)
+		assert.Equal(t, f, parsed)
+	}
+
+	_, err := ParseConnectionFamily("ipx")
+	assert.EqualError(t, err, `unknown connection family "ipx"`)
+}
+
+func TestParseConnectionDirection(t *testing.T) {
+	for _, d := range []ConnectionDirection{INCOMING, OUTGOING, LOCAL} {
+		parsed, err := ParseConnectionDirection(d.String())
+		require.NoError(t, err)
+		assert.Equal(t, d, parsed)
+	}
+
+	_, err := ParseConnectionDirection("")
+	assert.EqualError(t, err, `unknown connection direction ""`)
+}
@@ -0,0 +1,84 @@
+package ebpf
+
+import (
+	"net"
+)
+
+// FilterConnections returns a new Connections holding only the connections for which keep returns true,
+// the original Connections is left untouched, a nil Connections is handled as an empty one
+func FilterConnections(conns *Connections, keep func(ConnectionStats) bool) *Connections {
+	all := connectionsOf(conns)
+	filtered := &Connections{Conns: make([]ConnectionStats, 0, len(all))}
+	for _, c := range all {
+		if keep(c) {
+			filtered.Conns = append(filtered.Conns, c)
+		}
+	}
+	return filtered
+}
+
+// IsNotLoopbackOrLinkLocal is a FilterConnections predicate dropping the connections whose source or
+// destination is a loopback (127.0.0.0/8, ::1) or a link-local (169.254.0.0/16, fe80::/10) address,
+// the connections whose addresses are missing or can not be parsed are kept
+func IsNotLoopbackOrLinkLocal(c ConnectionStats) bool {
+	return !isLoopbackOrLinkLocal(c.Source) && !isLoopbackOrLinkLocal(c.Dest)
+}
+
+// isLoopbackOrLinkLocal accepts the addresses in any of the forms handled by formatAddr
+func isLoopbackOrLinkLocal(addr interface{}) bool {
+	ip := net.ParseIP(formatAddr(addr))
+	if ip == nil {
+		return false
+	}
+	return ip.IsLoopback() || ip.IsLinkLocalUnicast()
+}
+
+// FilterConnectionsByPID keeps only the connections of the allowed PIDs, an empty allow list keeps all of them.
+// Unlike FilterConnections, the connections are filtered in place and conns is returned
+func FilterConnectionsByPID(conns *Connections, allow []uint32) *Connections {
+	if len(allow) == 0 {
+		return conns
+	}
+	allowed := pidSet(allow)
+	return filterConnectionsInPlace(conns, func(c ConnectionStats) bool {
+		_, ok := allowed[c.Pid]
+		return ok
+	})
+}
+
+// ExcludeConnectionsByPID removes the connections of the denied PIDs.
+// Unlike FilterConnections, the connections are filtered in place and conns is returned
+func ExcludeConnectionsByPID(conns *Connections, deny []uint32) *Connections {
+	if len(deny) == 0 {
+		return conns
+	}
+	denied := pidSet(deny)
+	return filterConnectionsInPlace(conns, func(c ConnectionStats) bool {
+		_, ok := denied[c.Pid]
+		return !ok
+	})
+}
+
+func pidSet(pids []uint32) map[uint32]struct{} {
+	set := make(map[uint32]struct{}, len(pids))
+	for _, pid := range pids {
+		set[pid] = struct{}{}
+	}
+	return set
+}
+
+// filterConnectionsInPlace keeps the connections for which keep returns true, reusing the slice of conns
+func filterConnectionsInPlace(conns *Connections, keep func(ConnectionStats) bool) *Connections {
+	kept := conns.Conns[:0]
+	for _, c := range conns.Conns {
+		if keep(c) {
+			kept = append(kept, c)
+		}
+	}
+	// reset the remaining connections so that they can be garbage collected
+	for i := len(kept); i < len(conns.Conns); i++ {
+		conns.Conns[i] = ConnectionStats{}
+	}
+	conns.Conns = kept
+	return conns
+}
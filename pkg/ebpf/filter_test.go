@@ -0,0 +1,133 @@
+package ebpf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+)
+
+func TestIsNotLoopbackOrLinkLocal(t *testing.T) {
+	for _, tc := range []struct {
+		addr     string
+		expected bool
+	}{
+		// loopback v4
+		{"127.0.0.1", false},
+		{"127.255.255.254", false},
+		{"128.0.0.1", true},
+		// loopback v6
+		{"::1", false},
+		{"::2", true},
+		// link-local v4
+		{"169.254.0.1", false},
+		{"169.254.255.255", false},
+		{"169.253.255.255", true},
+		{"169.255.0.1", true},
+		// link-local v6
+		{"fe80::1", false},
+		{"febf:ffff::1", false},
+		{"fec0::1", true},
+		// external
+		{"10.0.0.1", true},
+		{"2001:db8::1", true},
+	} {
+		addr := util.AddressFromString(tc.addr)
+		external := util.AddressFromString("8.8.8.8")
+
+		asSource := ConnectionStats{Source: addr, Dest: external}
+		asDest := ConnectionStats{Source: external, Dest: addr}
+
+		assert.Equal(t, tc.expected, IsNotLoopbackOrLinkLocal(asSource), "source %s", tc.addr)
+		assert.Equal(t, tc.expected, IsNotLoopbackOrLinkLocal(asDest), "dest %s", tc.addr)
+	}
+}
+
+func TestIsNotLoopbackOrLinkLocalWithoutAddresses(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		source   interface{}
+		expected bool
+	}{
+		{"loopback string", "127.0.0.1", false},
+		{"link-local string", "fe80::1", false},
+		{"external string", "10.0.0.1", true},
+		{"unparsable string", "not an ip", true},
+		{"nil", nil, true},
+	} {
+		c := ConnectionStats{Source: tc.source, Dest: nil}
+		assert.Equal(t, tc.expected, IsNotLoopbackOrLinkLocal(c), tc.name)
+	}
+}
+
+func TestFilterNilConnections(t *testing.T) {
+	filtered := FilterConnections(nil, IsNotLoopbackOrLinkLocal)
+	assert.NotNil(t, filtered)
+	assert.Len(t, filtered.Conns, 0)
+}
+
+func TestFilterConnections(t *testing.T) {
+	conns := &Connections{
+		Conns: []ConnectionStats{
+			{Source: util.AddressFromString("127.0.0.1"), Dest: util.AddressFromString("127.0.0.1"), SPort: 1},
+			{Source: util.AddressFromString("10.0.0.1"), Dest: util.AddressFromString("8.8.8.8"), SPort: 2},
+			{Source: util.AddressFromString("fe80::1"), Dest: util.AddressFromString("2001:db8::1"), SPort: 3},
+			{Source: util.AddressFromString("2001:db8::2"), Dest: util.AddressFromString("2001:db8::1"), SPort: 4},
+		},
+	}
+
+	filtered := FilterConnections(conns, IsNotLoopbackOrLinkLocal)
+	assert.Len(t, filtered.Conns, 2)
+	assert.Equal(t, uint16(2), filtered.Conns[0].SPort)
+	assert.Equal(t, uint16(4), filtered.Conns[1].SPort)
+
+	// the original connections are left untouched
+	assert.Len(t, conns.Conns, 4)
+}
+
+func pids(conns *Connections) []uint32 {
+	var pids []uint32
+	for _, c := range conns.Conns {
+		pids = append(pids, c.Pid)
+	}
+	return pids
+}
+
+func newConnectionsWithPIDs(pids ...uint32) *Connections {
+	conns := &Connections{}
+	for _, pid := range pids {
+		conns.Conns = append(conns.Conns, ConnectionStats{Pid: pid})
+	}
+	return conns
+}
+
+func TestFilterConnectionsByPID(t *testing.T) {
+	conns := newConnectionsWithPIDs(1, 2, 3, 2, 4)
+	original := conns.Conns
+	filtered := FilterConnectionsByPID(conns, []uint32{2, 4, 5})
+	assert.Equal(t, []uint32{2, 2, 4}, pids(filtered))
+
+	// the connections are filtered in place
+	assert.True(t, filtered == conns)
+	assert.True(t, &original[0] == &filtered.Conns[0])
+	assert.Equal(t, ConnectionStats{}, original[4])
+
+	// an empty allow list keeps all the connections
+	conns = newConnectionsWithPIDs(1, 2, 3)
+	assert.Equal(t, []uint32{1, 2, 3}, pids(FilterConnectionsByPID(conns, nil)))
+	assert.Equal(t, []uint32{1, 2, 3}, pids(FilterConnectionsByPID(conns, []uint32{})))
+}
+
+func TestExcludeConnectionsByPID(t *testing.T) {
+	conns := newConnectionsWithPIDs(1, 2, 3, 2, 4)
+	filtered := ExcludeConnectionsByPID(conns, []uint32{2, 4, 5})
+	assert.Equal(t, []uint32{1, 3}, pids(filtered))
+	assert.True(t, filtered == conns)
+
+	conns = newConnectionsWithPIDs(1, 2, 3)
+	assert.Equal(t, []uint32{1, 2, 3}, pids(ExcludeConnectionsByPID(conns, nil)))
+
+	conns = newConnectionsWithPIDs(1, 1)
+	assert.Empty(t, ExcludeConnectionsByPID(conns, []uint32{1}).Conns)
+}
@@ -0,0 +1,39 @@
+package ebpf
+
+import (
+	"io"
+
+	"github.com/mailru/easyjson/jwriter"
+)
+
+// jsonWriteSize is the size of the JSON buffered by MarshalJSONTo before it is written out
+const jsonWriteSize = 4096
+
+// MarshalJSONTo writes the same JSON as MarshalJSON to w one connection after the other, so that the
+// document is never held in memory as a whole. Nothing but the connections being encoded is buffered.
+func MarshalJSONTo(w io.Writer, conns *Connections) error {
+	if conns == nil || conns.Conns == nil {
+		_, err := io.WriteString(w, `{"connections":null}`)
+		return err
+	}
+
+	jw := jwriter.Writer{}
+	jw.RawString(`{"connections":[`)
+	for i, c := range conns.Conns {
+		if i > 0 {
+			jw.RawByte(',')
+		}
+		c.MarshalEasyJSON(&jw)
+		if jw.Error != nil {
+			return jw.Error
+		}
+		if jw.Size() >= jsonWriteSize {
+			if _, err := jw.DumpTo(w); err != nil {
+				return err
+			}
+		}
+	}
+	jw.RawString("]}")
+	_, err := jw.DumpTo(w)
+	return err
+}
@@ -0,0 +1,52 @@
+package ebpf
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarshalJSONTo(t *testing.T) {
+	// enough connections for the JSON to be written out several times
+	conns := &Connections{}
+	for i := 0; i < 200; i++ {
+		conns.Conns = append(conns.Conns, ConnectionStats{
+			Pid:       uint32(i),
+			Source:    "10.0.0.1",
+			Dest:      "2001:db8::2",
+			SPort:     uint16(4000 + i),
+			DPort:     443,
+			Direction: OUTGOING,
+		})
+	}
+
+	for name, c := range map[string]*Connections{
+		"connections": conns,
+		"empty":       {Conns: []ConnectionStats{}},
+		"nil":         {},
+	} {
+		var buf bytes.Buffer
+		require.NoError(t, MarshalJSONTo(&buf, c), name)
+		expected, err := c.MarshalJSON()
+		require.NoError(t, err)
+		assert.Equal(t, string(expected), buf.String(), name)
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, MarshalJSONTo(&buf, nil))
+	assert.Equal(t, `{"connections":null}`, buf.String())
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestMarshalJSONToWriteError(t *testing.T) {
+	conns := &Connections{Conns: []ConnectionStats{{Pid: 1, Source: "10.0.0.1", Dest: "10.0.0.2"}}}
+	assert.EqualError(t, MarshalJSONTo(failingWriter{}, conns), "connection reset")
+}
@@ -0,0 +1,69 @@
+package ebpf
+
+// mergeKey identifies a connection across the snapshots of several probes: its 5-tuple, the addresses
+// being compared by value whether they are held as util.Address or as strings, along with its network
+// namespace, since the same 5-tuple can be used in several namespaces, and its PID, like ByteKey
+type mergeKey struct {
+	source, dest string
+	sport, dport uint16
+	family       ConnectionFamily
+	connType     ConnectionType
+	netNS        uint32
+	pid          uint32
+}
+
+func mergeKeyOf(c ConnectionStats) mergeKey {
+	return mergeKey{
+		source:   formatAddr(c.Source),
+		dest:     formatAddr(c.Dest),
+		sport:    c.SPort,
+		dport:    c.DPort,
+		family:   c.Family,
+		connType: c.Type,
+		netNS:    c.NetNS,
+		pid:      c.Pid,
+	}
+}
+
+// Merge unions the connections of the snapshots by their 5-tuple: the addresses and ports,
+// the family and the type, within a network namespace and a process. The connections seen by several snapshots are merged into one whose monotonic
+// and last-interval counters are both the sums of theirs, each probe having counted its share of the traffic,
+// and whose update epoch is the latest one. Its other fields are the ones of the first snapshot holding
+// the connection, the direction and the IP translation being taken from the next snapshots when unknown.
+// The merged connections are in the order they first appear in the snapshots, which are left untouched.
+func Merge(snapshots ...*Connections) *Connections {
+	merged := &Connections{}
+	indices := make(map[mergeKey]int)
+	for _, snapshot := range snapshots {
+		for _, c := range connectionsOf(snapshot) {
+			key := mergeKeyOf(c)
+			i, ok := indices[key]
+			if !ok {
+				indices[key] = len(merged.Conns)
+				merged.Conns = append(merged.Conns, c)
+				continue
+			}
+			mergeConnection(&merged.Conns[i], c)
+		}
+	}
+	return merged
+}
+
+// mergeConnection adds the counters of other to c
+func mergeConnection(c *ConnectionStats, other ConnectionStats) {
+	c.MonotonicSentBytes += other.MonotonicSentBytes
+	c.LastSentBytes += other.LastSentBytes
+	c.MonotonicRecvBytes += other.MonotonicRecvBytes
+	c.LastRecvBytes += other.LastRecvBytes
+	c.MonotonicRetransmits += other.MonotonicRetransmits
+	c.LastRetransmits += other.LastRetransmits
+	if other.LastUpdateEpoch > c.LastUpdateEpoch {
+		c.LastUpdateEpoch = other.LastUpdateEpoch
+	}
+	if c.Direction == 0 {
+		c.Direction = other.Direction
+	}
+	if c.IPTranslation == nil {
+		c.IPTranslation = other.IPTranslation
+	}
+}
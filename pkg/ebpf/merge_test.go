@@ -0,0 +1,101 @@
+package ebpf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/DataDog/datadog-agent/pkg/ebpf/netlink"
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+)
+
+func TestMerge(t *testing.T) {
+	shared := ConnectionStats{
+		Pid:                  1,
+		Source:               util.AddressFromString("10.0.0.1"),
+		SPort:                40000,
+		Dest:                 util.AddressFromString("10.0.0.2"),
+		DPort:                443,
+		Family:               AFINET,
+		Type:                 TCP,
+		MonotonicSentBytes:   100,
+		LastSentBytes:        10,
+		MonotonicRecvBytes:   200,
+		LastRecvBytes:        20,
+		MonotonicRetransmits: 3,
+		LastRetransmits:      1,
+		LastUpdateEpoch:      50,
+	}
+	// the second probe holds the addresses as strings, and knows the direction and the translation
+	seenAgain := shared
+	seenAgain.Source = "10.0.0.1"
+	seenAgain.Dest = "10.0.0.2"
+	seenAgain.MonotonicSentBytes = 1000
+	seenAgain.LastSentBytes = 5
+	seenAgain.MonotonicRecvBytes = 2000
+	seenAgain.LastRecvBytes = 0
+	seenAgain.MonotonicRetransmits = 1
+	seenAgain.LastRetransmits = 0
+	seenAgain.LastUpdateEpoch = 60
+	seenAgain.Direction = OUTGOING
+	seenAgain.IPTranslation = &netlink.IPTranslation{ReplSrcIP: "10.0.0.3", ReplSrcPort: 443}
+
+	first := shared
+	first.SPort = 40001
+	udp := shared
+	udp.Type = UDP
+
+	a := &Connections{Conns: []ConnectionStats{first, shared}}
+	b := &Connections{Conns: []ConnectionStats{seenAgain, udp}}
+	merged := Merge(a, nil, b)
+
+	require.Len(t, merged.Conns, 3)
+	assert.Equal(t, first, merged.Conns[0])
+	assert.Equal(t, udp, merged.Conns[2])
+
+	m := merged.Conns[1]
+	assert.Equal(t, uint64(1100), m.MonotonicSentBytes)
+	assert.Equal(t, uint64(15), m.LastSentBytes)
+	assert.Equal(t, uint64(2200), m.MonotonicRecvBytes)
+	assert.Equal(t, uint64(20), m.LastRecvBytes)
+	assert.Equal(t, uint32(4), m.MonotonicRetransmits)
+	assert.Equal(t, uint32(1), m.LastRetransmits)
+	assert.Equal(t, uint64(60), m.LastUpdateEpoch)
+	assert.Equal(t, OUTGOING, m.Direction)
+	assert.Equal(t, seenAgain.IPTranslation, m.IPTranslation)
+	assert.Equal(t, shared.Source, m.Source)
+
+	// the snapshots are left untouched
+	assert.Equal(t, shared, a.Conns[1])
+	assert.Equal(t, seenAgain, b.Conns[0])
+}
+
+func TestMergeKeepsTheNamespacesApart(t *testing.T) {
+	c := ConnectionStats{
+		Pid:                1,
+		NetNS:              4026531992,
+		Source:             "10.0.0.1",
+		SPort:              40000,
+		Dest:               "10.0.0.2",
+		DPort:              443,
+		MonotonicSentBytes: 100,
+	}
+	// the same 5-tuple in another namespace, like two containers using the same private addresses
+	otherNS := c
+	otherNS.NetNS = 4026532281
+	otherNS.MonotonicSentBytes = 10
+	otherPid := c
+	otherPid.Pid = 2
+
+	merged := Merge(&Connections{Conns: []ConnectionStats{c}}, &Connections{Conns: []ConnectionStats{otherNS, otherPid, c}})
+	require.Len(t, merged.Conns, 3)
+	assert.Equal(t, uint64(200), merged.Conns[0].MonotonicSentBytes)
+	assert.Equal(t, otherNS, merged.Conns[1])
+	assert.Equal(t, otherPid, merged.Conns[2])
+}
+
+func TestMergeWithoutSnapshots(t *testing.T) {
+	assert.Empty(t, Merge().Conns)
+	assert.Empty(t, Merge(nil, &Connections{}).Conns)
+}
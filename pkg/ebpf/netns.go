@@ -0,0 +1,37 @@
+package ebpf
+
+import "sort"
+
+// NetNSSummary holds the number of connections and the bytes they transferred
+// in a network namespace
+type NetNSSummary struct {
+	NetNS       uint32 `json:"ns"`
+	Connections int    `json:"conns"`
+	SentBytes   uint64 `json:"sent_b"`
+	RecvBytes   uint64 `json:"recv_b"`
+}
+
+// SummarizeByNetNS groups the connections by network namespace and returns the summaries
+// sorted by namespace, the bytes are summed from the monotonic counters
+func SummarizeByNetNS(conns *Connections) []NetNSSummary {
+	byNetNS := make(map[uint32]*NetNSSummary)
+	for _, c := range conns.Conns {
+		summary, ok := byNetNS[c.NetNS]
+		if !ok {
+			summary = &NetNSSummary{NetNS: c.NetNS}
+			byNetNS[c.NetNS] = summary
+		}
+		summary.Connections++
+		summary.SentBytes += c.MonotonicSentBytes
+		summary.RecvBytes += c.MonotonicRecvBytes
+	}
+
+	summaries := make([]NetNSSummary, 0, len(byNetNS))
+	for _, summary := range byNetNS {
+		summaries = append(summaries, *summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].NetNS < summaries[j].NetNS
+	})
+	return summaries
+}
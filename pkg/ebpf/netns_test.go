@@ -0,0 +1,31 @@
+package ebpf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSummarizeByNetNS(t *testing.T) {
+	conns := &Connections{
+		Conns: []ConnectionStats{
+			{NetNS: 3, MonotonicSentBytes: 1, MonotonicRecvBytes: 2},
+			{NetNS: 1, MonotonicSentBytes: 10, MonotonicRecvBytes: 20, LastSentBytes: 5},
+			{NetNS: 2, MonotonicSentBytes: 100, MonotonicRecvBytes: 200},
+			{NetNS: 1, MonotonicSentBytes: 30, MonotonicRecvBytes: 40},
+			{NetNS: 3, MonotonicSentBytes: 3, MonotonicRecvBytes: 4},
+			{NetNS: 3},
+		},
+	}
+
+	expected := []NetNSSummary{
+		{NetNS: 1, Connections: 2, SentBytes: 40, RecvBytes: 60},
+		{NetNS: 2, Connections: 1, SentBytes: 100, RecvBytes: 200},
+		{NetNS: 3, Connections: 3, SentBytes: 4, RecvBytes: 6},
+	}
+	assert.Equal(t, expected, SummarizeByNetNS(conns))
+}
+
+func TestSummarizeByNetNSEmpty(t *testing.T) {
+	assert.Empty(t, SummarizeByNetNS(&Connections{}))
+}
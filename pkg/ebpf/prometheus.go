@@ -0,0 +1,68 @@
+package ebpf
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// connectionLabels renders the labels of the exported metrics,
+// addresses are left out to bound their cardinality
+func connectionLabels(c ConnectionStats) string {
+	return fmt.Sprintf(`{direction="%s",family="%s",type="%s"}`, c.Direction, c.Family, c.Type)
+}
+
+type connectionCounters struct {
+	sentBytes   uint64
+	recvBytes   uint64
+	retransmits uint64
+}
+
+// WritePrometheus writes the monotonic counters of the connections, summed by direction,
+// family and type, in the Prometheus text exposition format.
+// The sums only cover the connections of the snapshot and go down when connections are closed,
+// so they are exported as gauges.
+func WritePrometheus(w io.Writer, conns *Connections) error {
+	// the series are keyed by their rendered labels, different values can render the same way
+	counters := make(map[string]*connectionCounters)
+	for _, c := range connectionsOf(conns) {
+		l := connectionLabels(c)
+		cs, ok := counters[l]
+		if !ok {
+			cs = &connectionCounters{}
+			counters[l] = cs
+		}
+		cs.sentBytes += c.MonotonicSentBytes
+		cs.recvBytes += c.MonotonicRecvBytes
+		cs.retransmits += uint64(c.MonotonicRetransmits)
+	}
+
+	labels := make([]string, 0, len(counters))
+	for l := range counters {
+		labels = append(labels, l)
+	}
+	// keep the output deterministic
+	sort.Strings(labels)
+
+	var buf bytes.Buffer
+	metrics := []struct {
+		name  string
+		help  string
+		value func(*connectionCounters) uint64
+	}{
+		{"connection_bytes_sent", "Bytes sent by the live connections.", func(c *connectionCounters) uint64 { return c.sentBytes }},
+		{"connection_bytes_received", "Bytes received by the live connections.", func(c *connectionCounters) uint64 { return c.recvBytes }},
+		{"connection_retransmits", "TCP retransmits of the live connections.", func(c *connectionCounters) uint64 { return c.retransmits }},
+	}
+	for _, m := range metrics {
+		fmt.Fprintf(&buf, "# HELP %s %s\n", m.name, m.help)
+		fmt.Fprintf(&buf, "# TYPE %s gauge\n", m.name)
+		for _, l := range labels {
+			fmt.Fprintf(&buf, "%s%s %d\n", m.name, l, m.value(counters[l]))
+		}
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
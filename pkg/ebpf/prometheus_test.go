@@ -0,0 +1,87 @@
+package ebpf
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	conns := &Connections{
+		Conns: []ConnectionStats{
+			{
+				Source:               util.AddressFromString("10.0.0.1"),
+				Dest:                 util.AddressFromString("10.0.0.2"),
+				Family:               AFINET,
+				Type:                 TCP,
+				Direction:            OUTGOING,
+				MonotonicSentBytes:   100,
+				MonotonicRecvBytes:   200,
+				MonotonicRetransmits: 3,
+			},
+			{
+				Source:               util.AddressFromString("10.0.0.1"),
+				Dest:                 util.AddressFromString("10.0.0.3"),
+				Family:               AFINET,
+				Type:                 TCP,
+				Direction:            OUTGOING,
+				MonotonicSentBytes:   10,
+				MonotonicRecvBytes:   20,
+				MonotonicRetransmits: 1,
+			},
+			{
+				Source:             util.AddressFromString("::1"),
+				Dest:               util.AddressFromString("::1"),
+				Family:             AFINET6,
+				Type:               UDP,
+				Direction:          LOCAL,
+				MonotonicSentBytes: 5,
+				MonotonicRecvBytes: 5,
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, WritePrometheus(&buf, conns))
+
+	expected := `# HELP connection_bytes_sent Bytes sent by the live connections.
+# TYPE connection_bytes_sent gauge
+connection_bytes_sent{direction="local",family="v6",type="UDP"} 5
+connection_bytes_sent{direction="outgoing",family="v4",type="TCP"} 110
+# HELP connection_bytes_received Bytes received by the live connections.
+# TYPE connection_bytes_received gauge
+connection_bytes_received{direction="local",family="v6",type="UDP"} 5
+connection_bytes_received{direction="outgoing",family="v4",type="TCP"} 220
+# HELP connection_retransmits TCP retransmits of the live connections.
+# TYPE connection_retransmits gauge
+connection_retransmits{direction="local",family="v6",type="UDP"} 0
+connection_retransmits{direction="outgoing",family="v4",type="TCP"} 4
+`
+	assert.Equal(t, expected, buf.String())
+}
+
+func TestWritePrometheusEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, WritePrometheus(&buf, &Connections{}))
+	assert.NotContains(t, buf.String(), "{")
+}
+
+func TestWritePrometheusMergesTheValuesRenderedAlike(t *testing.T) {
+	// the unknown families are rendered as v4
+	conns := &Connections{
+		Conns: []ConnectionStats{
+			{Family: ConnectionFamily(7), Type: TCP, Direction: INCOMING, MonotonicSentBytes: 1},
+			{Family: AFINET, Type: TCP, Direction: INCOMING, MonotonicSentBytes: 2},
+		},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, WritePrometheus(&buf, conns))
+	assert.Equal(t, 1, strings.Count(buf.String(), "connection_bytes_sent{"))
+	assert.Contains(t, buf.String(), `connection_bytes_sent{direction="incoming",family="v4",type="TCP"} 3`)
+}
@@ -0,0 +1,69 @@
+package ebpf
+
+import (
+	"bytes"
+	"net"
+	"sort"
+)
+
+// MarshalJSONStable returns the same JSON as MarshalJSON with the connections sorted
+// by pid, source address and port, destination address and port, then type.
+// Connections equal on all of these are ordered by their encoding so that
+// the output does not depend on the order of the connections.
+func (v Connections) MarshalJSONStable() ([]byte, error) {
+	type sortable struct {
+		conn    ConnectionStats
+		src     net.IP
+		dst     net.IP
+		encoded []byte
+	}
+
+	conns := make([]sortable, len(v.Conns))
+	for i, c := range v.Conns {
+		encoded, err := c.MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		conns[i] = sortable{
+			conn:    c,
+			src:     parseAddr(c.Source),
+			dst:     parseAddr(c.Dest),
+			encoded: encoded,
+		}
+	}
+
+	sort.Slice(conns, func(i, j int) bool {
+		a, b := conns[i], conns[j]
+		if a.conn.Pid != b.conn.Pid {
+			return a.conn.Pid < b.conn.Pid
+		}
+		if cmp := bytes.Compare(a.src, b.src); cmp != 0 {
+			return cmp < 0
+		}
+		if a.conn.SPort != b.conn.SPort {
+			return a.conn.SPort < b.conn.SPort
+		}
+		if cmp := bytes.Compare(a.dst, b.dst); cmp != 0 {
+			return cmp < 0
+		}
+		if a.conn.DPort != b.conn.DPort {
+			return a.conn.DPort < b.conn.DPort
+		}
+		if a.conn.Type != b.conn.Type {
+			return a.conn.Type < b.conn.Type
+		}
+		return bytes.Compare(a.encoded, b.encoded) < 0
+	})
+
+	sorted := Connections{Conns: make([]ConnectionStats, len(conns))}
+	for i, c := range conns {
+		sorted.Conns[i] = c.conn
+	}
+	return sorted.MarshalJSON()
+}
+
+// parseAddr returns the 16-byte representation of an address so that v4 and v6 addresses
+// can be compared, it is nil when the address is missing or invalid
+func parseAddr(addr interface{}) net.IP {
+	return net.ParseIP(formatAddr(addr)).To16()
+}
@@ -0,0 +1,49 @@
+package ebpf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+)
+
+func TestMarshalJSONStable(t *testing.T) {
+	conns := []ConnectionStats{
+		{Pid: 2, Source: util.AddressFromString("10.0.0.1"), SPort: 80, Dest: util.AddressFromString("10.0.0.2"), DPort: 443},
+		{Pid: 1, Source: util.AddressFromString("10.0.0.2"), SPort: 80, Dest: util.AddressFromString("10.0.0.2"), DPort: 443},
+		{Pid: 1, Source: util.AddressFromString("10.0.0.1"), SPort: 81, Dest: util.AddressFromString("10.0.0.2"), DPort: 443},
+		{Pid: 1, Source: util.AddressFromString("10.0.0.1"), SPort: 80, Dest: util.AddressFromString("10.0.0.3"), DPort: 443},
+		{Pid: 1, Source: util.AddressFromString("10.0.0.1"), SPort: 80, Dest: util.AddressFromString("10.0.0.2"), DPort: 53, Type: UDP},
+		{Pid: 1, Source: util.AddressFromString("10.0.0.1"), SPort: 80, Dest: util.AddressFromString("10.0.0.2"), DPort: 53},
+		// only differ by their counters
+		{Pid: 1, Source: "10.0.0.1", SPort: 80, Dest: "10.0.0.2", DPort: 53, MonotonicSentBytes: 2},
+		{Pid: 1, Source: "10.0.0.1", SPort: 80, Dest: "10.0.0.2", DPort: 53, MonotonicSentBytes: 1},
+	}
+
+	reversed := make([]ConnectionStats, len(conns))
+	for i, c := range conns {
+		reversed[len(conns)-1-i] = c
+	}
+
+	expected, err := Connections{Conns: conns}.MarshalJSONStable()
+	require.NoError(t, err)
+	actual, err := Connections{Conns: reversed}.MarshalJSONStable()
+	require.NoError(t, err)
+	assert.Equal(t, string(expected), string(actual))
+
+	sorted := Connections{}
+	require.NoError(t, sorted.UnmarshalJSON(expected))
+	require.Len(t, sorted.Conns, len(conns))
+	assert.Equal(t, uint32(1), sorted.Conns[0].Pid)
+	assert.Equal(t, uint16(53), sorted.Conns[0].DPort)
+	assert.Equal(t, uint64(0), sorted.Conns[0].MonotonicSentBytes)
+	assert.Equal(t, uint64(1), sorted.Conns[1].MonotonicSentBytes)
+	assert.Equal(t, uint64(2), sorted.Conns[2].MonotonicSentBytes)
+	assert.Equal(t, UDP, sorted.Conns[3].Type)
+	assert.Equal(t, "10.0.0.3", sorted.Conns[4].Dest)
+	assert.Equal(t, uint16(81), sorted.Conns[5].SPort)
+	assert.Equal(t, "10.0.0.2", sorted.Conns[6].Source)
+	assert.Equal(t, uint32(2), sorted.Conns[7].Pid)
+}
@@ -10,3 +10,31 @@ type Destination interface {
 	Send(payload []byte) error
 	SendAsync(payload []byte)
 }
+
+// SignedDestination is a Destination that can send a payload along with its signature.
+type SignedDestination interface {
+	Destination
+	SendSigned(payload []byte, signature []byte) error
+}
+
+// Envelope is a payload along with the metadata identifying it.
+type Envelope struct {
+	Payload   []byte
+	Signature []byte
+	// Sequence numbers the payloads of a sender starting at 1,
+	// a payload sent again keeps the same sequence number.
+	Sequence uint64
+	// Checksum is the CRC-32C of the payload as sent in big endian, nil when it is not computed.
+	Checksum []byte
+	// ContentEncoding is the HTTP content encoding of the payload, empty when it is not compressed.
+	// It applies to the payload once decrypted when the payload is encrypted.
+	ContentEncoding string
+	// Encryption is the cipher the payload is encrypted with, empty when it is not encrypted.
+	Encryption string
+}
+
+// EnvelopeDestination is a Destination that can send a payload along with its metadata.
+type EnvelopeDestination interface {
+	Destination
+	SendEnvelope(envelope Envelope) error
+}
@@ -0,0 +1,204 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package file
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/DataDog/datadog-agent/pkg/logs/client"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+const (
+	filePrefix = "logs-"
+	fileSuffix = ".spool"
+	// recordHeaderLength is the length of the header prefixing every payload:
+	// the length then the CRC32 of the payload, both big endian uint32.
+	recordHeaderLength = 4 + 4
+)
+
+// Destination writes the payloads to rotating files in a directory, each payload is appended
+// along with its metadata as a record prefixed by its length and its checksum.
+type Destination struct {
+	mu          sync.Mutex
+	dir         string
+	maxFileSize int64
+	maxFiles    int
+	file        *os.File
+	size        int64
+	sequence    uint64
+}
+
+// NewDestination returns a new Destination writing to dir, a new file is started when the current one
+// would exceed maxFileSize and only the maxFiles most recent files are kept, zero means no limit.
+func NewDestination(dir string, maxFileSize int64, maxFiles int) (*Destination, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	d := &Destination{
+		dir:         dir,
+		maxFileSize: maxFileSize,
+		maxFiles:    maxFiles,
+	}
+	files, err := d.files()
+	if err != nil {
+		return nil, err
+	}
+	if len(files) > 0 {
+		// carry on after the files left by a previous run
+		d.sequence, _ = parseSequence(files[len(files)-1])
+	}
+	return d, nil
+}
+
+// Send appends the payload to the current file like SendEnvelope.
+func (d *Destination) Send(payload []byte) error {
+	return d.SendEnvelope(client.Envelope{Payload: payload})
+}
+
+// SendEnvelope appends the payload along with its metadata to the current file, rotating it when needed.
+// The errors returned are retryable, except when the disk is full or the files can not be written
+// since retrying would not help.
+func (d *Destination) SendEnvelope(envelope client.Envelope) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	record := encodeRecord(encodeEnvelope(envelope))
+	if d.file != nil && d.maxFileSize > 0 && d.size > 0 && d.size+int64(len(record)) > d.maxFileSize {
+		if err := d.closeFile(); err != nil {
+			return classifyError(err)
+		}
+	}
+	if d.file == nil {
+		if err := d.openFile(); err != nil {
+			return classifyError(err)
+		}
+	}
+
+	n, err := d.file.Write(record)
+	d.size += int64(n)
+	if err != nil {
+		return classifyError(err)
+	}
+	return nil
+}
+
+// classifyError returns the error as a retryable one, unless the disk is full or permission is denied.
+func classifyError(err error) error {
+	if os.IsPermission(err) || isNoSpace(err) {
+		return err
+	}
+	return client.NewRetryableError(err)
+}
+
+// isNoSpace returns true when the error is caused by a full disk.
+func isNoSpace(err error) bool {
+	switch e := err.(type) {
+	case *os.PathError:
+		err = e.Err
+	case *os.SyscallError:
+		err = e.Err
+	}
+	return err == syscall.ENOSPC
+}
+
+// SendAsync writes the payload like Send, the errors are only logged.
+func (d *Destination) SendAsync(payload []byte) {
+	if err := d.Send(payload); err != nil {
+		log.Warnf("Could not write payload to %s: %v", d.dir, err)
+	}
+}
+
+// Close syncs and closes the current file.
+func (d *Destination) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.file == nil {
+		return nil
+	}
+	return d.closeFile()
+}
+
+// openFile starts a new file and deletes the oldest ones beyond maxFiles.
+func (d *Destination) openFile() error {
+	d.sequence++
+	file, err := os.OpenFile(filepath.Join(d.dir, fileName(d.sequence)), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	d.file = file
+	d.size = 0
+	return d.removeOldFiles()
+}
+
+// closeFile syncs the current file so that it is complete on disk once rotated, then closes it.
+func (d *Destination) closeFile() error {
+	file := d.file
+	d.file = nil
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// removeOldFiles deletes the oldest files so that at most maxFiles are kept.
+func (d *Destination) removeOldFiles() error {
+	if d.maxFiles <= 0 {
+		return nil
+	}
+	files, err := d.files()
+	if err != nil {
+		return err
+	}
+	for len(files) > d.maxFiles {
+		if err := os.Remove(files[0]); err != nil {
+			return err
+		}
+		files = files[1:]
+	}
+	return nil
+}
+
+// files returns the paths of the files written in dir, from the oldest to the most recent.
+func (d *Destination) files() ([]string, error) {
+	infos, err := ioutil.ReadDir(d.dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, info := range infos {
+		if _, ok := parseSequence(info.Name()); ok && !info.IsDir() {
+			files = append(files, filepath.Join(d.dir, info.Name()))
+		}
+	}
+	// the sequence is zero padded, the names sort in the order of the files
+	sort.Strings(files)
+	return files, nil
+}
+
+// fileName returns the name of the file with the sequence number.
+func fileName(sequence uint64) string {
+	return fmt.Sprintf("%s%020d%s", filePrefix, sequence, fileSuffix)
+}
+
+// parseSequence returns the sequence number of the file.
+func parseSequence(path string) (uint64, bool) {
+	name := filepath.Base(path)
+	if !strings.HasPrefix(name, filePrefix) || !strings.HasSuffix(name, fileSuffix) {
+		return 0, false
+	}
+	sequence, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, filePrefix), fileSuffix), 10, 64)
+	return sequence, err == nil
+}
@@ -0,0 +1,104 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/DataDog/datadog-agent/pkg/logs/client"
+)
+
+func readFile(t *testing.T, path string) []string {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	envelopes, err := ReadEnvelopes(f)
+	require.NoError(t, err)
+	var payloads []string
+	for _, envelope := range envelopes {
+		payloads = append(payloads, string(envelope.Payload))
+	}
+	return payloads
+}
+
+func TestDestinationRotatesFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logs-spool")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// two records of 8 bytes of header, 25 bytes of metadata and 6 bytes of payload fit in a file
+	destination, err := NewDestination(dir, 80, 0)
+	require.NoError(t, err)
+	for _, payload := range []string{"first1", "secnd2", "third3"} {
+		require.NoError(t, destination.Send([]byte(payload)))
+	}
+	require.NoError(t, destination.Close())
+
+	files, err := destination.files()
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	assert.Equal(t, []string{"first1", "secnd2"}, readFile(t, files[0]))
+	assert.Equal(t, []string{"third3"}, readFile(t, files[1]))
+}
+
+func TestDestinationKeepsMaxFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logs-spool")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	destination, err := NewDestination(dir, 1, 2)
+	require.NoError(t, err)
+	for _, payload := range []string{"a", "b", "c", "d"} {
+		require.NoError(t, destination.Send([]byte(payload)))
+	}
+	require.NoError(t, destination.Close())
+
+	files, err := destination.files()
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	assert.Equal(t, filepath.Join(dir, fileName(3)), files[0])
+	assert.Equal(t, []string{"c"}, readFile(t, files[0]))
+	assert.Equal(t, []string{"d"}, readFile(t, files[1]))
+}
+
+func TestDestinationCarriesOnAfterExistingFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logs-spool")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	destination, err := NewDestination(dir, 0, 0)
+	require.NoError(t, err)
+	require.NoError(t, destination.Send([]byte("a")))
+	require.NoError(t, destination.Close())
+
+	destination, err = NewDestination(dir, 0, 0)
+	require.NoError(t, err)
+	require.NoError(t, destination.Send([]byte("b")))
+	require.NoError(t, destination.Close())
+
+	files, err := destination.files()
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	assert.Equal(t, []string{"a"}, readFile(t, files[0]))
+	assert.Equal(t, []string{"b"}, readFile(t, files[1]))
+}
+
+func TestDestinationErrorsThatCanNotBeRetried(t *testing.T) {
+	noSpace := &os.PathError{Op: "write", Path: "logs.spool", Err: syscall.ENOSPC}
+	assert.Equal(t, noSpace, classifyError(noSpace))
+	denied := &os.PathError{Op: "open", Path: "logs.spool", Err: syscall.EACCES}
+	assert.Equal(t, denied, classifyError(denied))
+
+	ioErr := &os.PathError{Op: "write", Path: "logs.spool", Err: syscall.EIO}
+	assert.IsType(t, &client.RetryableError{}, classifyError(ioErr))
+}
@@ -0,0 +1,79 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package file
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/DataDog/datadog-agent/pkg/logs/client"
+)
+
+// envelopeVersion is the version of the encoding of the envelopes spooled, written first in every record.
+const envelopeVersion = 1
+
+// encodeEnvelope returns the envelope encoded as the version, the sequence number as a big endian uint64,
+// the signature, the checksum, the content encoding and the encryption each prefixed by their length
+// as a big endian uint32, then the payload up to the end of the record.
+func encodeEnvelope(envelope client.Envelope) []byte {
+	fields := [][]byte{
+		envelope.Signature,
+		envelope.Checksum,
+		[]byte(envelope.ContentEncoding),
+		[]byte(envelope.Encryption),
+	}
+	length := 1 + 8 + len(envelope.Payload)
+	for _, field := range fields {
+		length += 4 + len(field)
+	}
+
+	data := make([]byte, 0, length)
+	data = append(data, envelopeVersion)
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], envelope.Sequence)
+	data = append(data, buf[:]...)
+	for _, field := range fields {
+		binary.BigEndian.PutUint32(buf[:4], uint32(len(field)))
+		data = append(data, buf[:4]...)
+		data = append(data, field...)
+	}
+	return append(data, envelope.Payload...)
+}
+
+// decodeEnvelope returns the envelope encoded by encodeEnvelope.
+func decodeEnvelope(data []byte) (client.Envelope, error) {
+	var envelope client.Envelope
+	if len(data) < 1+8 {
+		return envelope, fmt.Errorf("truncated envelope of %d bytes", len(data))
+	}
+	if data[0] != envelopeVersion {
+		return envelope, fmt.Errorf("unknown envelope version: %d", data[0])
+	}
+	envelope.Sequence = binary.BigEndian.Uint64(data[1:9])
+	data = data[9:]
+
+	var fields [4][]byte
+	for i := range fields {
+		if len(data) < 4 {
+			return envelope, fmt.Errorf("truncated envelope field")
+		}
+		length := binary.BigEndian.Uint32(data[:4])
+		data = data[4:]
+		if uint64(length) > uint64(len(data)) {
+			return envelope, fmt.Errorf("invalid envelope field length: %d", length)
+		}
+		if length > 0 {
+			fields[i] = data[:length]
+		}
+		data = data[length:]
+	}
+	envelope.Signature = fields[0]
+	envelope.Checksum = fields[1]
+	envelope.ContentEncoding = string(fields[2])
+	envelope.Encryption = string(fields[3])
+	envelope.Payload = data
+	return envelope, nil
+}
@@ -0,0 +1,77 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package file
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/DataDog/datadog-agent/pkg/logs/client"
+)
+
+// maxRecordLength is the maximum length of a payload, a larger length means the header is corrupted.
+const maxRecordLength = 64 * 1024 * 1024
+
+// ErrCorruptRecord is returned when a payload does not match its checksum.
+var ErrCorruptRecord = errors.New("corrupt record")
+
+// encodeRecord returns the payload prefixed by its length and its checksum.
+func encodeRecord(payload []byte) []byte {
+	record := make([]byte, recordHeaderLength+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	copy(record[recordHeaderLength:], payload)
+	return record
+}
+
+// readRecord returns the next payload, or ErrCorruptRecord when it does not match its checksum
+// in which case the next record can still be read. io.EOF is returned at the end of the records.
+func readRecord(r io.Reader) ([]byte, error) {
+	var header [recordHeaderLength]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("truncated record header")
+		}
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header[0:4])
+	if length > maxRecordLength {
+		return nil, fmt.Errorf("invalid record length: %d", length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("truncated record of %d bytes", length)
+		}
+		return nil, err
+	}
+	if binary.BigEndian.Uint32(header[4:8]) != crc32.ChecksumIEEE(payload) {
+		return nil, ErrCorruptRecord
+	}
+	return payload, nil
+}
+
+// ReadEnvelopes returns the envelopes written to a file by a Destination.
+func ReadEnvelopes(r io.Reader) ([]client.Envelope, error) {
+	var envelopes []client.Envelope
+	for {
+		record, err := readRecord(r)
+		if err == io.EOF {
+			return envelopes, nil
+		}
+		if err != nil {
+			return envelopes, err
+		}
+		envelope, err := decodeEnvelope(record)
+		if err != nil {
+			return envelopes, err
+		}
+		envelopes = append(envelopes, envelope)
+	}
+}
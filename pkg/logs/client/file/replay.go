@@ -0,0 +1,75 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package file
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/logs/client"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+var (
+	replayBackoffBase = 1 * time.Second
+	replayBackoffMax  = 1 * time.Minute
+)
+
+// Replay reads the envelopes spooled by a Destination and sends each of them with the transport,
+// client.SendFunc adapts the functions sending bare payloads. An envelope is sent again with an
+// exponential backoff as long as the transport returns a retryable error, and skipped on other errors.
+// The corrupt records are skipped, the replay stops on unreadable ones or when the context is cancelled.
+func Replay(ctx context.Context, r io.Reader, transport client.Transport) error {
+	for {
+		record, err := readRecord(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err == ErrCorruptRecord {
+			log.Warnf("Skipping corrupt spooled payload")
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		envelope, err := decodeEnvelope(record)
+		if err != nil {
+			log.Warnf("Skipping invalid spooled payload: %v", err)
+			continue
+		}
+		if err := replay(ctx, envelope, transport); err != nil {
+			return err
+		}
+	}
+}
+
+// replay sends the envelope, retrying on retryable errors until the context is cancelled.
+func replay(ctx context.Context, envelope client.Envelope, transport client.Transport) error {
+	delay := replayBackoffBase
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err := transport.Send(ctx, envelope)
+		if err == nil {
+			return nil
+		}
+		if _, ok := err.(*client.RetryableError); !ok {
+			log.Warnf("Could not replay spooled payload, skipping it: %v", err)
+			return nil
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		delay *= 2
+		if delay > replayBackoffMax {
+			delay = replayBackoffMax
+		}
+	}
+}
@@ -0,0 +1,141 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package file
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/DataDog/datadog-agent/pkg/logs/client"
+)
+
+// spool returns the content of a file spooled with the payloads.
+func spool(t *testing.T, payloads ...string) []byte {
+	dir, err := ioutil.TempDir("", "logs-spool")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	destination, err := NewDestination(dir, 0, 0)
+	require.NoError(t, err)
+	for _, payload := range payloads {
+		require.NoError(t, destination.Send([]byte(payload)))
+	}
+	require.NoError(t, destination.Close())
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, fileName(1)))
+	require.NoError(t, err)
+	return content
+}
+
+func TestReplaySkipsCorruptRecords(t *testing.T) {
+	content := spool(t, "first", "second", "third")
+	// flip the last byte of the second payload
+	first := recordHeaderLength + len(encodeEnvelope(client.Envelope{Payload: []byte("first")}))
+	second := recordHeaderLength + len(encodeEnvelope(client.Envelope{Payload: []byte("second")}))
+	content[first+second-1] ^= 0xff
+
+	var replayed []string
+	err := Replay(context.Background(), bytes.NewReader(content), client.SendFunc(func(payload []byte) error {
+		replayed = append(replayed, string(payload))
+		return nil
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"first", "third"}, replayed)
+}
+
+func TestReplayRetriesRetryableErrors(t *testing.T) {
+	replayBackoffBase = time.Millisecond
+	defer func() { replayBackoffBase = time.Second }()
+
+	content := spool(t, "first", "second")
+
+	attempts := 0
+	var replayed []string
+	err := Replay(context.Background(), bytes.NewReader(content), client.SendFunc(func(payload []byte) error {
+		attempts++
+		if attempts < 3 {
+			return client.NewRetryableError(errors.New("connection refused"))
+		}
+		if string(payload) == "second" {
+			return errors.New("bad request")
+		}
+		replayed = append(replayed, string(payload))
+		return nil
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, 4, attempts)
+	assert.Equal(t, []string{"first"}, replayed)
+}
+
+func TestReplayStopsWhenCancelled(t *testing.T) {
+	content := spool(t, "first", "second")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	attempts := 0
+	err := Replay(ctx, bytes.NewReader(content), client.SendFunc(func(payload []byte) error {
+		attempts++
+		cancel()
+		return client.NewRetryableError(errors.New("connection refused"))
+	}))
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 1, attempts)
+}
+
+func TestReplayStopsOnTruncatedRecord(t *testing.T) {
+	content := spool(t, "first", "second")
+
+	var replayed []string
+	err := Replay(context.Background(), bytes.NewReader(content[:len(content)-1]), client.SendFunc(func(payload []byte) error {
+		replayed = append(replayed, string(payload))
+		return nil
+	}))
+	assert.Error(t, err)
+	assert.Equal(t, []string{"first"}, replayed)
+}
+
+func TestReplayKeepsTheEnvelopes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logs-spool")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	spooled := []client.Envelope{
+		{Payload: []byte("first"), Signature: []byte{0xca, 0xfe}, Sequence: 1, Checksum: []byte{1, 2, 3, 4}, ContentEncoding: "gzip", Encryption: "aes-gcm"},
+		{Payload: []byte("second"), Sequence: 2},
+	}
+	destination, err := NewDestination(dir, 0, 0)
+	require.NoError(t, err)
+	for _, envelope := range spooled {
+		require.NoError(t, destination.SendEnvelope(envelope))
+	}
+	require.NoError(t, destination.Close())
+
+	f, err := os.Open(filepath.Join(dir, fileName(1)))
+	require.NoError(t, err)
+	defer f.Close()
+	var replayed []client.Envelope
+	err = Replay(context.Background(), f, transportFunc(func(ctx context.Context, envelope client.Envelope) error {
+		replayed = append(replayed, envelope)
+		return nil
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, spooled, replayed)
+}
+
+// transportFunc adapts a function into a client.Transport.
+type transportFunc func(ctx context.Context, envelope client.Envelope) error
+
+func (f transportFunc) Send(ctx context.Context, envelope client.Envelope) error {
+	return f(ctx, envelope)
+}
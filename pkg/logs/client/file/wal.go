@@ -0,0 +1,103 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package file
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ErrWALFull is returned when a record does not fit in the write-ahead log.
+var ErrWALFull = errors.New("write-ahead log is full")
+
+// WAL is a write-ahead log appending records to a single file,
+// it is truncated once the records are not needed anymore.
+// The records are not synced to disk, so they survive a crash of the process but not of the host.
+type WAL struct {
+	mu      sync.Mutex
+	file    *os.File
+	maxSize int64
+	size    int64
+}
+
+// OpenWAL opens the write-ahead log at path, keeping the records written by a previous run,
+// the records can not be appended once the file reaches maxSize bytes, zero means no limit.
+func OpenWAL(path string, maxSize int64) (*WAL, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &WAL{
+		file:    file,
+		maxSize: maxSize,
+		size:    info.Size(),
+	}, nil
+}
+
+// Records returns the records of the log in the order they were appended. The corrupt records
+// are skipped, and the records read so far are returned along with the error when the log
+// ends with an incomplete record, e.g. when the process crashed while appending it.
+func (w *WAL) Records() ([][]byte, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	r := io.NewSectionReader(w.file, 0, w.size)
+	var records [][]byte
+	for {
+		record, err := readRecord(r)
+		switch err {
+		case nil:
+			records = append(records, record)
+		case ErrCorruptRecord:
+			continue
+		case io.EOF:
+			return records, nil
+		default:
+			return records, err
+		}
+	}
+}
+
+// Append appends the record to the log, ErrWALFull is returned when it would exceed the max size.
+func (w *WAL) Append(record []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	data := encodeRecord(record)
+	if w.maxSize > 0 && w.size+int64(len(data)) > w.maxSize {
+		return ErrWALFull
+	}
+	n, err := w.file.Write(data)
+	w.size += int64(n)
+	return err
+}
+
+// Truncate removes all the records from the log.
+func (w *WAL) Truncate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.file.Truncate(0); err != nil {
+		return err
+	}
+	w.size = 0
+	return nil
+}
+
+// Close closes the log, keeping its records on disk.
+func (w *WAL) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Close()
+}
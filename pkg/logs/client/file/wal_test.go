@@ -0,0 +1,98 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func walRecords(t *testing.T, wal *WAL) []string {
+	records, err := wal.Records()
+	require.NoError(t, err)
+	var payloads []string
+	for _, record := range records {
+		payloads = append(payloads, string(record))
+	}
+	return payloads
+}
+
+func TestWALKeepsRecordsAcrossRestarts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sender", "wal")
+
+	wal, err := OpenWAL(path, 0)
+	require.NoError(t, err)
+	require.NoError(t, wal.Append([]byte("a")))
+	require.NoError(t, wal.Append([]byte("b")))
+	require.NoError(t, wal.Close())
+
+	wal, err = OpenWAL(path, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, walRecords(t, wal))
+
+	require.NoError(t, wal.Append([]byte("c")))
+	assert.Equal(t, []string{"a", "b", "c"}, walRecords(t, wal))
+
+	require.NoError(t, wal.Truncate())
+	assert.Empty(t, walRecords(t, wal))
+	require.NoError(t, wal.Append([]byte("d")))
+	assert.Equal(t, []string{"d"}, walRecords(t, wal))
+	require.NoError(t, wal.Close())
+}
+
+func TestWALMaxSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// every record takes its header and one byte
+	wal, err := OpenWAL(filepath.Join(dir, "wal"), 2*(recordHeaderLength+1))
+	require.NoError(t, err)
+	defer wal.Close()
+	require.NoError(t, wal.Append([]byte("a")))
+	require.NoError(t, wal.Append([]byte("b")))
+	assert.Equal(t, ErrWALFull, wal.Append([]byte("c")))
+	assert.Equal(t, []string{"a", "b"}, walRecords(t, wal))
+
+	require.NoError(t, wal.Truncate())
+	assert.NoError(t, wal.Append([]byte("c")))
+}
+
+func TestWALRecoversFromCorruptRecords(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "wal")
+
+	wal, err := OpenWAL(path, 0)
+	require.NoError(t, err)
+	require.NoError(t, wal.Append([]byte("a")))
+	require.NoError(t, wal.Append([]byte("b")))
+	require.NoError(t, wal.Append([]byte("c")))
+	require.NoError(t, wal.Close())
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	// flip the payload of the second record, then cut the last one in the middle
+	data[2*recordHeaderLength+1] ^= 0xff
+	data = data[:len(data)-3]
+	require.NoError(t, ioutil.WriteFile(path, data, 0644))
+
+	wal, err = OpenWAL(path, 0)
+	require.NoError(t, err)
+	defer wal.Close()
+	records, err := wal.Records()
+	assert.Error(t, err)
+	assert.Equal(t, [][]byte{[]byte("a")}, records)
+}
@@ -2,10 +2,12 @@ package http
 
 import (
 	"context"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -14,7 +16,17 @@ import (
 	"github.com/DataDog/datadog-agent/pkg/util"
 )
 
-const contentType = "application/json"
+const (
+	contentType     = "application/json"
+	signatureHeader = "DD-Payload-Signature"
+	encodingHeader  = "Content-Encoding"
+	sequenceHeader  = "DD-Payload-Sequence"
+	checksumHeader  = "DD-Payload-Checksum"
+	// encrypted payloads must not be decoded before they are decrypted,
+	// their encoding is sent in its own header instead of Content-Encoding.
+	encryptionHeader        = "DD-Payload-Encryption"
+	encryptedEncodingHeader = "DD-Payload-Content-Encoding"
+)
 
 // HTTP errors
 var (
@@ -46,14 +58,47 @@ func NewDestination(endpoint config.Endpoint, destinationsContext *client.Destin
 // Send sends a payload over HTTP,
 // the error returned can be retryable and it is the responsibility of the callee to retry.
 func (d *Destination) Send(payload []byte) error {
+	return d.send(client.Envelope{Payload: payload})
+}
+
+// SendSigned sends a payload over HTTP with its signature hex encoded in a header,
+// the error returned can be retryable and it is the responsibility of the callee to retry.
+func (d *Destination) SendSigned(payload []byte, signature []byte) error {
+	return d.send(client.Envelope{Payload: payload, Signature: signature})
+}
+
+// SendEnvelope sends a payload over HTTP with its signature, its checksum, its sequence number, its content encoding and its encryption in headers,
+// the error returned can be retryable and it is the responsibility of the callee to retry.
+func (d *Destination) SendEnvelope(envelope client.Envelope) error {
+	return d.send(envelope)
+}
+
+func (d *Destination) send(envelope client.Envelope) error {
 	ctx := d.destinationsContext.Context()
-	req, err := http.NewRequest("POST", d.url, strings.NewReader(string(payload)))
+	req, err := http.NewRequest("POST", d.url, strings.NewReader(string(envelope.Payload)))
 	if err != nil {
 		// the request could not be built,
 		// this can happen when the method or the url are valid.
 		return err
 	}
 	req.Header.Set("Content-Type", contentType)
+	if envelope.Signature != nil {
+		req.Header.Set(signatureHeader, hex.EncodeToString(envelope.Signature))
+	}
+	if envelope.Encryption != "" {
+		req.Header.Set(encryptionHeader, envelope.Encryption)
+		if envelope.ContentEncoding != "" {
+			req.Header.Set(encryptedEncodingHeader, envelope.ContentEncoding)
+		}
+	} else if envelope.ContentEncoding != "" {
+		req.Header.Set(encodingHeader, envelope.ContentEncoding)
+	}
+	if envelope.Checksum != nil {
+		req.Header.Set(checksumHeader, hex.EncodeToString(envelope.Checksum))
+	}
+	if envelope.Sequence > 0 {
+		req.Header.Set(sequenceHeader, strconv.FormatUint(envelope.Sequence, 10))
+	}
 	req = req.WithContext(ctx)
 
 	resp, err := d.client.Do(req)
@@ -22,10 +22,16 @@ type HTTPServerTest struct {
 	httpServer  *httptest.Server
 	destCtx     *client.DestinationsContext
 	destination *Destination
+	headers     chan http.Header
 }
 
 func NewHTTPServerTest(statusCode int) *HTTPServerTest {
+	headers := make(chan http.Header, 1)
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case headers <- r.Header:
+		default:
+		}
 		w.WriteHeader(statusCode)
 	}))
 	url := strings.Split(ts.URL, ":")
@@ -42,6 +48,7 @@ func NewHTTPServerTest(statusCode int) *HTTPServerTest {
 		httpServer:  ts,
 		destCtx:     destCtx,
 		destination: dest,
+		headers:     headers,
 	}
 }
 
@@ -100,3 +107,53 @@ func TestDestinationSend400(t *testing.T) {
 	assert.Equal(t, "client error", err.Error())
 	server.stop()
 }
+
+func TestDestinationSendSigned(t *testing.T) {
+	server := NewHTTPServerTest(200)
+	err := server.destination.SendSigned([]byte("yo"), []byte{0xca, 0xfe})
+	assert.Nil(t, err)
+	assert.Equal(t, "cafe", (<-server.headers).Get("DD-Payload-Signature"))
+	server.stop()
+}
+
+func TestDestinationSendIsNotSigned(t *testing.T) {
+	server := NewHTTPServerTest(200)
+	err := server.destination.Send([]byte("yo"))
+	assert.Nil(t, err)
+	assert.Equal(t, "", (<-server.headers).Get("DD-Payload-Signature"))
+	server.stop()
+}
+
+func TestDestinationSendEnvelope(t *testing.T) {
+	server := NewHTTPServerTest(200)
+	err := server.destination.SendEnvelope(client.Envelope{Payload: []byte("yo"), Signature: []byte{0xca, 0xfe}, Sequence: 42, Checksum: []byte{0xbe, 0xef}, ContentEncoding: "gzip"})
+	assert.Nil(t, err)
+	headers := <-server.headers
+	assert.Equal(t, "cafe", headers.Get("DD-Payload-Signature"))
+	assert.Equal(t, "42", headers.Get("DD-Payload-Sequence"))
+	assert.Equal(t, "beef", headers.Get("DD-Payload-Checksum"))
+	assert.Equal(t, "gzip", headers.Get("Content-Encoding"))
+	server.stop()
+}
+
+func TestDestinationSendEncryptedEnvelope(t *testing.T) {
+	server := NewHTTPServerTest(200)
+	err := server.destination.SendEnvelope(client.Envelope{Payload: []byte("yo"), ContentEncoding: "gzip", Encryption: "aes-gcm"})
+	assert.Nil(t, err)
+	headers := <-server.headers
+	// the payload must be decrypted before it is decoded
+	assert.Equal(t, "", headers.Get("Content-Encoding"))
+	assert.Equal(t, "gzip", headers.Get("DD-Payload-Content-Encoding"))
+	assert.Equal(t, "aes-gcm", headers.Get("DD-Payload-Encryption"))
+	server.stop()
+}
+
+func TestDestinationSendHasNoSequence(t *testing.T) {
+	server := NewHTTPServerTest(200)
+	err := server.destination.Send([]byte("yo"))
+	assert.Nil(t, err)
+	headers := <-server.headers
+	assert.Equal(t, "", headers.Get("DD-Payload-Sequence"))
+	assert.Equal(t, "", headers.Get("Content-Encoding"))
+	server.stop()
+}
@@ -0,0 +1,49 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package client
+
+import (
+	"context"
+)
+
+// Transport sends a payload along with its metadata.
+type Transport interface {
+	// Send sends the envelope, the error returned can be retryable
+	// and it is the responsibility of the callee to retry.
+	Send(ctx context.Context, envelope Envelope) error
+}
+
+// SendFunc adapts a function sending bare payloads into a Transport,
+// the metadata of the envelopes and the context are ignored.
+type SendFunc func(payload []byte) error
+
+// Send calls f with the payload of the envelope.
+func (f SendFunc) Send(ctx context.Context, envelope Envelope) error {
+	return f(envelope.Payload)
+}
+
+// destinationTransport sends the envelopes to a Destination.
+type destinationTransport struct {
+	destination Destination
+}
+
+// NewDestinationTransport returns a Transport sending the envelopes to the destination with the richest interface
+// it supports: the whole envelope to an EnvelopeDestination, the payload and its signature to a SignedDestination
+// when it is signed, otherwise the bare payload. The context is ignored, the destinations have their own.
+func NewDestinationTransport(destination Destination) Transport {
+	return &destinationTransport{destination: destination}
+}
+
+// Send sends the envelope to the destination.
+func (t *destinationTransport) Send(ctx context.Context, envelope Envelope) error {
+	if destination, ok := t.destination.(EnvelopeDestination); ok {
+		return destination.SendEnvelope(envelope)
+	}
+	if destination, ok := t.destination.(SignedDestination); ok && envelope.Signature != nil {
+		return destination.SendSigned(envelope.Payload, envelope.Signature)
+	}
+	return t.destination.Send(envelope.Payload)
+}
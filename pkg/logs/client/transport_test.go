@@ -0,0 +1,80 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendFuncSendsThePayload(t *testing.T) {
+	var sent []byte
+	sendErr := errors.New("send error")
+	transport := SendFunc(func(payload []byte) error {
+		sent = payload
+		return sendErr
+	})
+
+	err := transport.Send(context.Background(), Envelope{Payload: []byte("a"), Signature: []byte{1}, Sequence: 2, ContentEncoding: "gzip"})
+	assert.Equal(t, sendErr, err)
+	assert.Equal(t, []byte("a"), sent)
+}
+
+// recordingDestination records the way the payloads were sent.
+type recordingDestination struct {
+	calls []string
+}
+
+func (d *recordingDestination) Send(payload []byte) error {
+	d.calls = append(d.calls, "send "+string(payload))
+	return nil
+}
+
+func (d *recordingDestination) SendAsync(payload []byte) {}
+
+type recordingSignedDestination struct {
+	*recordingDestination
+}
+
+func (d *recordingSignedDestination) SendSigned(payload []byte, signature []byte) error {
+	d.calls = append(d.calls, "signed "+string(payload)+" "+string(signature))
+	return nil
+}
+
+type recordingEnvelopeDestination struct {
+	*recordingDestination
+	envelopes []Envelope
+}
+
+func (d *recordingEnvelopeDestination) SendEnvelope(envelope Envelope) error {
+	d.envelopes = append(d.envelopes, envelope)
+	return nil
+}
+
+func TestDestinationTransportUsesTheRichestInterface(t *testing.T) {
+	signed := Envelope{Payload: []byte("a"), Signature: []byte("s"), Sequence: 1, ContentEncoding: "gzip"}
+	unsigned := Envelope{Payload: []byte("b"), Sequence: 2}
+
+	plain := &recordingDestination{}
+	transport := NewDestinationTransport(plain)
+	assert.Nil(t, transport.Send(context.Background(), signed))
+	assert.Equal(t, []string{"send a"}, plain.calls)
+
+	signing := &recordingSignedDestination{&recordingDestination{}}
+	transport = NewDestinationTransport(signing)
+	assert.Nil(t, transport.Send(context.Background(), signed))
+	assert.Nil(t, transport.Send(context.Background(), unsigned))
+	assert.Equal(t, []string{"signed a s", "send b"}, signing.calls)
+
+	enveloping := &recordingEnvelopeDestination{recordingDestination: &recordingDestination{}}
+	transport = NewDestinationTransport(enveloping)
+	assert.Nil(t, transport.Send(context.Background(), signed))
+	assert.Equal(t, []Envelope{signed}, enveloping.envelopes)
+	assert.Empty(t, enveloping.calls)
+}
@@ -22,8 +22,8 @@ type Pipeline struct {
 	sender    sender.Sender
 }
 
-// NewPipeline returns a new Pipeline
-func NewPipeline(outputChan chan *message.Message, processingRules []*config.ProcessingRule, endpoints *config.Endpoints, destinationsContext *client.DestinationsContext) *Pipeline {
+// NewPipeline returns a new Pipeline, an error is returned when its sender can not be built
+func NewPipeline(outputChan chan *message.Message, processingRules []*config.ProcessingRule, endpoints *config.Endpoints, destinationsContext *client.DestinationsContext) (*Pipeline, error) {
 	var destinations *client.Destinations
 	if endpoints.UseHTTP {
 		main := http.NewDestination(endpoints.Main, destinationsContext)
@@ -43,11 +43,13 @@ func NewPipeline(outputChan chan *message.Message, processingRules []*config.Pro
 
 	senderChan := make(chan *message.Message, config.ChanSize)
 
-	var newSender sender.Sender
+	mode := sender.ModeStream
 	if endpoints.UseHTTP {
-		newSender = sender.NewBatchSender(senderChan, outputChan, destinations)
-	} else {
-		newSender = sender.NewStreamSender(senderChan, outputChan, destinations)
+		mode = sender.ModeBatch
+	}
+	newSender, err := sender.NewSender(senderChan, outputChan, destinations, sender.Config{Mode: mode})
+	if err != nil {
+		return nil, err
 	}
 
 	var encoder processor.Encoder
@@ -66,7 +68,7 @@ func NewPipeline(outputChan chan *message.Message, processingRules []*config.Pro
 		InputChan: inputChan,
 		processor: processor,
 		sender:    newSender,
-	}
+	}, nil
 }
 
 // Start launches the pipeline
@@ -13,6 +13,7 @@ import (
 	"github.com/DataDog/datadog-agent/pkg/logs/config"
 	"github.com/DataDog/datadog-agent/pkg/logs/message"
 	"github.com/DataDog/datadog-agent/pkg/logs/restart"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
 )
 
 // Provider provides message channels
@@ -53,7 +54,11 @@ func (p *provider) Start() {
 	p.outputChan = p.auditor.Channel()
 
 	for i := 0; i < p.numberOfPipelines; i++ {
-		pipeline := NewPipeline(p.outputChan, p.processingRules, p.endpoints, p.destinationsContext)
+		pipeline, err := NewPipeline(p.outputChan, p.processingRules, p.endpoints, p.destinationsContext)
+		if err != nil {
+			log.Errorf("Could not create pipeline: %v", err)
+			continue
+		}
 		pipeline.Start()
 		p.pipelines = append(p.pipelines, pipeline)
 	}
@@ -0,0 +1,114 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package processor
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/logs/message"
+)
+
+// A Deduplicator collapses consecutive messages with identical contents from an inputChan
+// into a single message annotated with the number of repeats and pushes it in an outputChan.
+// It compares the raw contents so it must run before the messages are encoded.
+type Deduplicator struct {
+	inputChan  chan *message.Message
+	outputChan chan *message.Message
+	window     time.Duration
+	maxCount   int
+	pending    *message.Message
+	count      int
+	done       chan struct{}
+}
+
+// NewDeduplicator returns an initialized Deduplicator holding a message for at most window
+// while waiting for repeats, and collapsing at most maxCount messages together,
+// zero meaning no limit.
+func NewDeduplicator(inputChan, outputChan chan *message.Message, window time.Duration, maxCount int) *Deduplicator {
+	return &Deduplicator{
+		inputChan:  inputChan,
+		outputChan: outputChan,
+		window:     window,
+		maxCount:   maxCount,
+		done:       make(chan struct{}),
+	}
+}
+
+// Start starts the Deduplicator.
+func (d *Deduplicator) Start() {
+	go d.run()
+}
+
+// Stop stops the Deduplicator,
+// this call blocks until inputChan is flushed
+func (d *Deduplicator) Stop() {
+	close(d.inputChan)
+	<-d.done
+}
+
+// run collapses the repeats of the inputChan.
+func (d *Deduplicator) run() {
+	flushTimer := time.NewTimer(d.window)
+	defer func() {
+		flushTimer.Stop()
+		d.done <- struct{}{}
+	}()
+
+	for {
+		select {
+		case msg, isOpen := <-d.inputChan:
+			if !isOpen {
+				// inputChan has been closed, no more messages are expected
+				d.flush()
+				return
+			}
+			if d.isRepeat(msg) {
+				// keep the last repeat so that its origin is the one reported once sent
+				d.pending = msg
+				d.count++
+				continue
+			}
+			d.flush()
+			d.pending = msg
+			d.count = 1
+			// the window starts with the first message of the repeats
+			if !flushTimer.Stop() {
+				<-flushTimer.C
+			}
+			flushTimer.Reset(d.window)
+		case <-flushTimer.C:
+			// the window expired, stop waiting for repeats
+			d.flush()
+			flushTimer.Reset(d.window)
+		}
+	}
+}
+
+// isRepeat returns true if the message can be collapsed with the pending one.
+func (d *Deduplicator) isRepeat(msg *message.Message) bool {
+	if d.pending == nil || (d.maxCount > 0 && d.count >= d.maxCount) {
+		return false
+	}
+	return bytes.Equal(d.pending.Content, msg.Content)
+}
+
+// flush pushes the pending message annotated with its number of repeats in outputChan.
+func (d *Deduplicator) flush() {
+	if d.pending == nil {
+		return
+	}
+	msg := d.pending
+	if d.count > 1 {
+		content := make([]byte, 0, len(msg.Content)+32)
+		content = append(content, msg.Content...)
+		msg.Content = append(content, fmt.Sprintf(" ...and %d more", d.count-1)...)
+	}
+	d.outputChan <- msg
+	d.pending = nil
+	d.count = 0
+}
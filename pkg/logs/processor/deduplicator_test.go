@@ -0,0 +1,89 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package processor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/logs/config"
+	"github.com/DataDog/datadog-agent/pkg/logs/message"
+	"github.com/stretchr/testify/assert"
+)
+
+func collectContents(outputChan chan *message.Message) []string {
+	var contents []string
+	for len(outputChan) > 0 {
+		contents = append(contents, string((<-outputChan).Content))
+	}
+	return contents
+}
+
+func TestDeduplicatorCollapsesRepeats(t *testing.T) {
+	inputChan := make(chan *message.Message, 10)
+	outputChan := make(chan *message.Message, 10)
+	d := NewDeduplicator(inputChan, outputChan, time.Hour, 0)
+	d.Start()
+
+	source := config.LogSource{Config: &config.LogsConfig{}}
+	for _, content := range []string{"a", "a", "a", "b", "a", "c", "c"} {
+		inputChan <- newMessage([]byte(content), &source, "")
+	}
+
+	d.Stop()
+	assert.Equal(t, []string{"a ...and 2 more", "b", "a", "c ...and 1 more"}, collectContents(outputChan))
+}
+
+func TestDeduplicatorKeepsTheLastRepeat(t *testing.T) {
+	inputChan := make(chan *message.Message, 10)
+	outputChan := make(chan *message.Message, 10)
+	d := NewDeduplicator(inputChan, outputChan, time.Hour, 0)
+	d.Start()
+
+	source := config.LogSource{Config: &config.LogsConfig{}}
+	first := newMessage([]byte("a"), &source, "")
+	last := newMessage([]byte("a"), &source, "")
+	inputChan <- first
+	inputChan <- last
+
+	d.Stop()
+	msg := <-outputChan
+	assert.True(t, msg == last)
+	assert.Equal(t, "a", string(first.Content))
+}
+
+func TestDeduplicatorMaxCount(t *testing.T) {
+	inputChan := make(chan *message.Message, 10)
+	outputChan := make(chan *message.Message, 10)
+	d := NewDeduplicator(inputChan, outputChan, time.Hour, 3)
+	d.Start()
+
+	source := config.LogSource{Config: &config.LogsConfig{}}
+	for i := 0; i < 5; i++ {
+		inputChan <- newMessage([]byte("a"), &source, "")
+	}
+
+	d.Stop()
+	assert.Equal(t, []string{"a ...and 2 more", "a ...and 1 more"}, collectContents(outputChan))
+}
+
+func TestDeduplicatorFlushesWhenTheWindowExpires(t *testing.T) {
+	inputChan := make(chan *message.Message, 10)
+	outputChan := make(chan *message.Message, 10)
+	d := NewDeduplicator(inputChan, outputChan, 10*time.Millisecond, 0)
+	d.Start()
+
+	source := config.LogSource{Config: &config.LogsConfig{}}
+	inputChan <- newMessage([]byte("a"), &source, "")
+	inputChan <- newMessage([]byte("a"), &source, "")
+	assert.Equal(t, "a ...and 1 more", string((<-outputChan).Content))
+
+	inputChan <- newMessage([]byte("a"), &source, "")
+	assert.Equal(t, "a", string((<-outputChan).Content))
+
+	d.Stop()
+	assert.Len(t, outputChan, 0)
+}
@@ -0,0 +1,62 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package sender
+
+import (
+	"time"
+)
+
+// adaptiveTimeoutWeight is the weight of the last inter-arrival time in the moving average,
+// the average mostly reflects the last ten messages.
+const adaptiveTimeoutWeight = 0.2
+
+// adaptiveTimeout adapts the batch timeout to the arrival rate of the messages.
+// It keeps an exponentially weighted moving average of the time between two messages,
+// and the timeout is the time expected to fill a batch at that rate, bounded by min and max:
+// the timeout gets shorter when the messages flow and longer when they trickle in.
+type adaptiveTimeout struct {
+	min       time.Duration
+	max       time.Duration
+	batchSize int
+	average   float64
+	last      time.Time
+}
+
+// newAdaptiveTimeout returns a new adaptiveTimeout starting at initial.
+func newAdaptiveTimeout(min, max, initial time.Duration, batchSize int) *adaptiveTimeout {
+	return &adaptiveTimeout{
+		min:       min,
+		max:       max,
+		batchSize: batchSize,
+		average:   float64(initial) / float64(batchSize),
+	}
+}
+
+// observe records the arrival of a message.
+func (a *adaptiveTimeout) observe(arrival time.Time) {
+	if !a.last.IsZero() {
+		interval := float64(arrival.Sub(a.last))
+		if interval < 0 {
+			interval = 0
+		}
+		a.average = adaptiveTimeoutWeight*interval + (1-adaptiveTimeoutWeight)*a.average
+	}
+	a.last = arrival
+}
+
+// timeout returns the time expected to fill a batch, bounded by min and max.
+func (a *adaptiveTimeout) timeout() time.Duration {
+	// compare as float to not overflow on long intervals
+	expected := a.average * float64(a.batchSize)
+	switch {
+	case expected < float64(a.min):
+		return a.min
+	case expected > float64(a.max):
+		return a.max
+	default:
+		return time.Duration(expected)
+	}
+}
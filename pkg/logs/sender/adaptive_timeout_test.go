@@ -0,0 +1,67 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package sender
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DataDog/datadog-agent/pkg/logs/client"
+)
+
+func TestAdaptiveTimeout(t *testing.T) {
+	a := newAdaptiveTimeout(time.Second, 20*time.Second, 5*time.Second, 10)
+	assert.Equal(t, 5*time.Second, a.timeout())
+
+	now := time.Now()
+	arrive := func(n int, interval time.Duration) {
+		for i := 0; i < n; i++ {
+			now = now.Add(interval)
+			a.observe(now)
+		}
+	}
+
+	// the messages flow, the timeout gets shorter down to the lower bound
+	arrive(1, 0)
+	arrive(3, 10*time.Millisecond)
+	busy := a.timeout()
+	assert.True(t, busy < 5*time.Second)
+	assert.True(t, busy > time.Second)
+	arrive(50, 10*time.Millisecond)
+	assert.Equal(t, time.Second, a.timeout())
+
+	// the messages trickle in, the timeout gets longer up to the upper bound
+	arrive(1, 5*time.Second)
+	idle := a.timeout()
+	assert.True(t, idle > time.Second)
+	assert.True(t, idle < 20*time.Second)
+	arrive(50, 5*time.Second)
+	assert.Equal(t, 20*time.Second, a.timeout())
+
+	// a steady rate fills a batch in ten intervals
+	arrive(100, 500*time.Millisecond)
+	assert.InDelta(t, float64(5*time.Second), float64(a.timeout()), float64(time.Millisecond))
+}
+
+func TestAdaptiveTimeoutStartsWithinBounds(t *testing.T) {
+	assert.Equal(t, 10*time.Second, newAdaptiveTimeout(10*time.Second, time.Minute, 5*time.Second, 20).timeout())
+	assert.Equal(t, 2*time.Second, newAdaptiveTimeout(time.Second, 2*time.Second, 5*time.Second, 20).timeout())
+}
+
+func TestAdaptiveTimeoutIsDisabledByDefault(t *testing.T) {
+	sender := NewBatchSender(nil, nil, client.NewDestinations(newMockDestination(nil), nil), BatchConfig{})
+	assert.Nil(t, sender.adaptive)
+	assert.Equal(t, defaultBatchTimeout, sender.flushTimeout())
+
+	config := BatchConfig{MinBatchTimeout: time.Minute, MaxBatchTimeout: time.Second}.withDefaults()
+	assert.Equal(t, time.Duration(0), config.MaxBatchTimeout)
+
+	sender = NewBatchSender(nil, nil, client.NewDestinations(newMockDestination(nil), nil), BatchConfig{MinBatchTimeout: time.Second, MaxBatchTimeout: time.Minute})
+	assert.NotNil(t, sender.adaptive)
+	assert.Equal(t, defaultBatchTimeout, sender.flushTimeout())
+}
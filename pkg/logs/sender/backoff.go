@@ -0,0 +1,44 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package sender
+
+import (
+	"math"
+	"time"
+)
+
+// maxBackoffDelay caps the delay between two attempts.
+const maxBackoffDelay = 1 * time.Minute
+
+// backoffPolicy computes the delays between the attempts to send a payload.
+type backoffPolicy struct {
+	base        time.Duration
+	factor      float64
+	maxAttempts int
+	maxElapsed  time.Duration
+}
+
+// delay returns the time to wait after the given failed attempt,
+// attempts start at 1.
+func (p backoffPolicy) delay(attempt int) time.Duration {
+	delay := float64(p.base) * math.Pow(p.factor, float64(attempt-1))
+	if delay > float64(maxBackoffDelay) {
+		return maxBackoffDelay
+	}
+	return time.Duration(delay)
+}
+
+// canRetry returns true if a new attempt can be made after the given failed attempt,
+// elapsed is the time spent sending the payload including the next delay.
+func (p backoffPolicy) canRetry(attempt int, elapsed time.Duration) bool {
+	if p.maxAttempts > 0 && attempt >= p.maxAttempts {
+		return false
+	}
+	if p.maxElapsed > 0 && elapsed > p.maxElapsed {
+		return false
+	}
+	return true
+}
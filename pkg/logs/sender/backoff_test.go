@@ -0,0 +1,34 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package sender
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	policy := backoffPolicy{base: time.Second, factor: 2}
+	assert.Equal(t, time.Second, policy.delay(1))
+	assert.Equal(t, 2*time.Second, policy.delay(2))
+	assert.Equal(t, 4*time.Second, policy.delay(3))
+	assert.Equal(t, maxBackoffDelay, policy.delay(100))
+}
+
+func TestBackoffCanRetry(t *testing.T) {
+	policy := backoffPolicy{base: time.Second, factor: 2}
+	assert.True(t, policy.canRetry(1000, time.Hour))
+
+	policy = backoffPolicy{base: time.Second, factor: 2, maxAttempts: 3}
+	assert.True(t, policy.canRetry(2, 0))
+	assert.False(t, policy.canRetry(3, 0))
+
+	policy = backoffPolicy{base: time.Second, factor: 2, maxElapsed: time.Minute}
+	assert.True(t, policy.canRetry(10, time.Minute))
+	assert.False(t, policy.canRetry(10, time.Minute+time.Second))
+}
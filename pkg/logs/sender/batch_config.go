@@ -0,0 +1,264 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package sender
+
+import (
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+
+	"github.com/DataDog/datadog-agent/pkg/logs/client"
+	"github.com/DataDog/datadog-agent/pkg/logs/message"
+)
+
+const (
+	defaultBatchTimeout           = 5 * time.Second
+	defaultMaxBatchSize           = 20
+	defaultMaxContentSize         = 1000000
+	defaultBackoffFactor          = 2
+	defaultCircuitBreakerCooldown = 30 * time.Second
+	defaultFinalFlushTimeout      = 1 * time.Second
+)
+
+// PendingPolicy tells what happens to the messages read from inputChan once MaxPendingMessages is reached.
+type PendingPolicy int
+
+const (
+	// PendingBlock stops reading inputChan until pending messages have been sent.
+	PendingBlock PendingPolicy = iota
+	// PendingDropNewest drops the messages read until pending messages have been sent.
+	PendingDropNewest
+)
+
+// BatchConfig holds the limits used to build batches,
+// zero values fall back to the defaults.
+type BatchConfig struct {
+	// MaxBatchSize is the maximum number of messages in a batch.
+	MaxBatchSize int
+	// MaxContentSize is the maximum size in bytes of a batch payload.
+	MaxContentSize int
+	// BatchTimeout is the maximum time to wait before sending a non-full batch.
+	BatchTimeout time.Duration
+	// MaxMessageSize is the maximum size in bytes of a message content, zero means no limit.
+	// Larger messages are dropped before being buffered instead of being truncated.
+	MaxMessageSize int
+	// FlushBytesThreshold is the payload size in bytes from which a batch is sent without waiting
+	// for more messages, zero disables it. Unlike MaxContentSize, it does not limit the size of the payloads.
+	FlushBytesThreshold int
+	// BatchTimeoutJitter is the fraction of BatchTimeout randomly added to or removed from
+	// every timeout so that senders started together do not flush at the same time,
+	// zero means no jitter.
+	BatchTimeoutJitter float64
+	// MinBatchTimeout and MaxBatchTimeout bound the batch timeout adapted to the arrival rate of the messages,
+	// the batch timeout is fixed unless MaxBatchTimeout is set. Starting from BatchTimeout, the timeout becomes
+	// the time expected to fill a batch at the recent rate: shorter when the messages flow and longer when
+	// they trickle in. The jitter is applied to the adapted timeout.
+	MinBatchTimeout time.Duration
+	MaxBatchTimeout time.Duration
+	// Formatter frames the messages into payloads, nil means JSON arrays.
+	Formatter *Formatter
+	// Compressor compresses the payloads, nil means no compression.
+	// It is ignored when the destinations are CompressingDestinations, the compressor of the main
+	// destination is then used instead so that the payloads are signed once compressed.
+	Compressor Compressor
+	// Encryptor encrypts the payloads once compressed and before they are signed, nil means no encryption.
+	// The heartbeats are encrypted as well, and the content encoding sent along with a payload
+	// is the one of the payload once decrypted.
+	Encryptor *Encryptor
+	// SigningKey is the key used to sign the payloads sent to the main destination with HMAC-SHA256,
+	// empty means the payloads are not signed. The main destination must be a client.SignedDestination.
+	SigningKey []byte
+	// Transport sends the payloads along with their signature, sequence number and content encoding
+	// instead of the main destination, nil means they are sent to the main destination.
+	// Bare send functions can be used through client.SendFunc.
+	Transport client.Transport
+	// MaxSendAttempts is the maximum number of attempts to send a payload
+	// on retryable errors, zero means no limit.
+	MaxSendAttempts int
+	// BackoffBase is the delay to wait before the first retry, zero means the payloads are retried right away.
+	BackoffBase time.Duration
+	// BackoffFactor is the multiplier applied to the delay after each retry.
+	BackoffFactor float64
+	// BackoffMaxElapsedTime is the maximum time spent retrying a payload, zero means no limit.
+	BackoffMaxElapsedTime time.Duration
+	// CircuitBreakerThreshold is the number of consecutive retryable send failures after which
+	// the sends are stopped for CircuitBreakerCooldown, zero disables the circuit breaker.
+	// While the circuit is open, the sender stops reading inputChan.
+	CircuitBreakerThreshold int
+	// CircuitBreakerCooldown is the time to wait before sending again once the circuit is open.
+	CircuitBreakerCooldown time.Duration
+	// RateLimiter caps the number of payloads sent per second, nil means no limit.
+	// When the limit is reached, messages keep being buffered until the buffer is full,
+	// then the sender stops reading inputChan until a payload can be sent.
+	RateLimiter RateLimiter
+	// DropPolicy tells what happens when a message does not fit in the batch: with DropNewest, the default,
+	// the batch is sent first, waiting for the rate limiter, with DropOldest the batch is sent only if the
+	// rate limiter allows it right away, otherwise the oldest buffered messages are evicted to make room for
+	// the new one. The messages evicted are not forwarded to outputChan.
+	DropPolicy DropPolicy
+	// OnDrop is called with every message evicted from the batch, nil means they are only counted.
+	OnDrop func(m *message.Message)
+	// DeadLetterChan receives the payloads that can not be sent, nil means they are dropped.
+	// The messages of a dead-lettered payload are not forwarded to outputChan.
+	DeadLetterChan chan *FailedPayload
+	// MaxConcurrentSends is the maximum number of payloads being sent at the same time,
+	// zero or one means the payloads are sent one after the other.
+	// When sending concurrently, the order of the payloads and of the messages
+	// forwarded to outputChan is not preserved.
+	MaxConcurrentSends int
+	// MaxInFlightBytes is the maximum number of bytes held by the payloads being sent, zero means no limit.
+	// When the limit is reached, the sender stops reading inputChan until a payload has been sent.
+	// A payload larger than the limit is sent once no other payload is in flight.
+	MaxInFlightBytes int64
+	// MaxPendingMessages is the maximum number of messages read from inputChan and not sent yet,
+	// either buffered or in flight, zero means no limit. Unlike MaxBatchSize, it bounds all the
+	// messages held by the sender.
+	MaxPendingMessages int
+	// PendingPolicy tells what happens once MaxPendingMessages is reached: with PendingBlock, the default,
+	// the buffered messages are sent and the sender stops reading inputChan until enough messages have
+	// been sent, with PendingDropNewest the messages read are dropped.
+	PendingPolicy PendingPolicy
+	// Sampler drops a fraction of the messages before they are buffered, nil means all the messages are kept.
+	// The messages dropped are not forwarded to outputChan.
+	Sampler Sampler
+	// OutputBufferSize is the number of messages buffered when outputChan is full, zero means
+	// the sends block until outputChan has room. When the buffer overflows, the oldest messages
+	// are dropped without being forwarded.
+	OutputBufferSize int
+	// FinalFlushTimeout is the maximum time spent sending the buffered messages
+	// once the context passed to StartWithContext is cancelled.
+	FinalFlushTimeout time.Duration
+	// DrainTimeout is the maximum time spent sending the buffered messages and forwarding them
+	// to outputChan once inputChan is closed, zero means no limit.
+	DrainTimeout time.Duration
+	// Heartbeat returns the payload sent when the batch timeout expires while the buffer is empty,
+	// nil means nothing is sent. It lets the intake know the sender is alive when no logs flow.
+	Heartbeat func() []byte
+	// FlushObserver is called with the description of every batch once it has been sent, or given up on.
+	// It is called from the concurrent senders when MaxConcurrentSends is greater than one.
+	FlushObserver func(info FlushInfo)
+	// WALPath is the path of the write-ahead log where the buffered messages are written,
+	// empty means the messages are only buffered in memory. The log is truncated every time
+	// a batch has been sent or dead-lettered, and the messages it holds when the sender starts
+	// are buffered again, so that the messages buffered when the agent stopped are not lost.
+	// The payloads are sent one after the other when it is set.
+	WALPath string
+	// WALMaxSize is the maximum size in bytes of the write-ahead log, zero means no limit.
+	// The messages which do not fit are still buffered and sent, without being written to the log.
+	WALMaxSize int64
+	// BisectDepth is the number of times a payload rejected by the main destination is split in two
+	// to isolate the messages rejected from the ones which can be sent, zero disables it.
+	// Only the payloads failing with a non-retryable error are split, and every half is sent
+	// as a new payload. The messages still rejected once the depth is reached are dead-lettered.
+	BisectDepth int
+	// ErrorHandler is called with the last error and the description of every payload that could not be sent,
+	// nil means the error is logged. It is not called when the destination context is cancelled.
+	ErrorHandler ErrorHandler
+}
+
+// FailedPayload holds a payload that could not be sent
+// and the messages it was built from.
+type FailedPayload struct {
+	Payload  []byte
+	Messages []*message.Message
+	// Sequence is the sequence number of the payload,
+	// it must be reused when the payload is sent again.
+	Sequence uint64
+}
+
+// withDefaults returns a copy of the config where all unset or invalid values
+// are replaced by the defaults.
+func (c BatchConfig) withDefaults() BatchConfig {
+	if c.MaxBatchSize <= 0 {
+		if c.MaxBatchSize < 0 {
+			log.Warnf("Invalid batch size %d, using default %d", c.MaxBatchSize, defaultMaxBatchSize)
+		}
+		c.MaxBatchSize = defaultMaxBatchSize
+	}
+	if c.MaxContentSize <= 0 {
+		if c.MaxContentSize < 0 {
+			log.Warnf("Invalid batch content size %d, using default %d", c.MaxContentSize, defaultMaxContentSize)
+		}
+		c.MaxContentSize = defaultMaxContentSize
+	}
+	if c.Formatter == nil {
+		c.Formatter = NewJSONArrayFormatter()
+	}
+	if c.BatchTimeout <= 0 {
+		if c.BatchTimeout < 0 {
+			log.Warnf("Invalid batch timeout %v, using default %v", c.BatchTimeout, defaultBatchTimeout)
+		}
+		c.BatchTimeout = defaultBatchTimeout
+	}
+	if c.MaxMessageSize < 0 {
+		log.Warnf("Invalid max message size %d, disabling it", c.MaxMessageSize)
+		c.MaxMessageSize = 0
+	}
+	if c.FlushBytesThreshold < 0 {
+		log.Warnf("Invalid flush bytes threshold %d, disabling it", c.FlushBytesThreshold)
+		c.FlushBytesThreshold = 0
+	}
+	if c.BatchTimeoutJitter < 0 || c.BatchTimeoutJitter >= 1 {
+		log.Warnf("Invalid batch timeout jitter %v, disabling it", c.BatchTimeoutJitter)
+		c.BatchTimeoutJitter = 0
+	}
+	if c.MaxBatchTimeout < 0 || c.MinBatchTimeout < 0 || c.MinBatchTimeout > c.MaxBatchTimeout {
+		log.Warnf("Invalid batch timeout bounds [%v, %v], using a fixed batch timeout", c.MinBatchTimeout, c.MaxBatchTimeout)
+		c.MinBatchTimeout = 0
+		c.MaxBatchTimeout = 0
+	}
+	if c.DropPolicy != DropNewest && c.DropPolicy != DropOldest {
+		log.Warnf("Invalid drop policy %d, dropping the newest messages", c.DropPolicy)
+		c.DropPolicy = DropNewest
+	}
+	if c.MaxSendAttempts < 0 {
+		log.Warnf("Invalid max send attempts %d, retrying indefinitely", c.MaxSendAttempts)
+		c.MaxSendAttempts = 0
+	}
+	if c.BackoffBase < 0 {
+		log.Warnf("Invalid backoff base %v, retrying right away", c.BackoffBase)
+		c.BackoffBase = 0
+	}
+	if c.BackoffFactor < 1 {
+		if c.BackoffFactor != 0 {
+			log.Warnf("Invalid backoff factor %v, using default %v", c.BackoffFactor, defaultBackoffFactor)
+		}
+		c.BackoffFactor = defaultBackoffFactor
+	}
+	if c.BackoffMaxElapsedTime < 0 {
+		c.BackoffMaxElapsedTime = 0
+	}
+	if c.CircuitBreakerThreshold < 0 {
+		log.Warnf("Invalid circuit breaker threshold %d, disabling it", c.CircuitBreakerThreshold)
+		c.CircuitBreakerThreshold = 0
+	}
+	if c.CircuitBreakerCooldown <= 0 {
+		c.CircuitBreakerCooldown = defaultCircuitBreakerCooldown
+	}
+	if c.DrainTimeout < 0 {
+		c.DrainTimeout = 0
+	}
+	if c.FinalFlushTimeout <= 0 {
+		c.FinalFlushTimeout = defaultFinalFlushTimeout
+	}
+	if c.MaxInFlightBytes < 0 {
+		log.Warnf("Invalid max in-flight bytes %d, disabling the limit", c.MaxInFlightBytes)
+		c.MaxInFlightBytes = 0
+	}
+	if c.OutputBufferSize < 0 {
+		log.Warnf("Invalid output buffer size %d, disabling it", c.OutputBufferSize)
+		c.OutputBufferSize = 0
+	}
+	if c.MaxPendingMessages < 0 {
+		log.Warnf("Invalid max pending messages %d, disabling the limit", c.MaxPendingMessages)
+		c.MaxPendingMessages = 0
+	}
+	if c.MaxConcurrentSends < 0 {
+		log.Warnf("Invalid max concurrent sends %d, sending payloads one after the other", c.MaxConcurrentSends)
+		c.MaxConcurrentSends = 0
+	}
+	return c
+}
@@ -0,0 +1,79 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package sender
+
+import (
+	"sync/atomic"
+
+	"github.com/DataDog/datadog-agent/pkg/logs/message"
+)
+
+// sealFailed reports the error of a payload that could not be sealed, its messages are dropped.
+func (b *BatchSender) sealFailed(pending batch, err error) {
+	atomic.AddInt64(&b.counters.droppedMessages, int64(len(pending.messages)))
+	b.sendFailed(pending, err, 0, "Could not encrypt payload")
+}
+
+// bisect splits the messages of a rejected batch in two halves and sends them as new batches,
+// it returns false when one of them was dropped because of a cancellation.
+func (b *BatchSender) bisect(pending batch) bool {
+	atomic.AddInt64(&b.counters.bisections, 1)
+	half := len(pending.messages) / 2
+	handled := true
+	for _, messages := range [][]*message.Message{pending.messages[:half], pending.messages[half:]} {
+		// the messages were already escaped and truncated to fit in a batch,
+		// so they all fit in a buffer of the same size.
+		buffer := newMessageBuffer(len(messages), b.messageBuffer.maxRequestSize, b.messageBuffer.formatter, DropNewest, nil)
+		for _, m := range messages {
+			buffer.TryAddMessage(m)
+		}
+		sealed, err := seal(b.sealStages, buffer.GetPayload())
+		if err != nil {
+			b.sealFailed(batch{payload: buffer.GetPayload(), messages: messages, reason: pending.reason}, err)
+			continue
+		}
+		sealed.sequence = b.nextSequence()
+		sealed.messages = messages
+		sealed.reason = pending.reason
+		sealed.depth = pending.depth + 1
+		if !b.send(sealed) {
+			handled = false
+		}
+	}
+	return handled
+}
+
+// giveUp reports the error of a payload that could not be sent then dead-letters it,
+// the messages are not forwarded to the next stage so that they are not considered as sent.
+func (b *BatchSender) giveUp(pending batch, err error, attempts int, description string) {
+	b.sendFailed(pending, err, attempts, description)
+	b.deadLetter(pending)
+}
+
+// sendFailed reports the error of a payload that could not be sent.
+func (b *BatchSender) sendFailed(pending batch, err error, attempts int, description string) {
+	b.errorHandler.report(err, description, SendFailure{
+		PayloadSize:  len(pending.payload),
+		MessageCount: len(pending.messages),
+		FlushReason:  pending.reason.String(),
+		Sequence:     pending.sequence,
+		Attempts:     attempts,
+	})
+}
+
+// deadLetter hands the payload and its messages over to the dead-letter channel,
+// the payload is dropped when there is none.
+func (b *BatchSender) deadLetter(pending batch) {
+	if b.deadLetterChan == nil {
+		return
+	}
+	// the buffers may be reused for the next batch, copy them
+	b.deadLetterChan <- &FailedPayload{
+		Payload:  append([]byte(nil), pending.payload...),
+		Messages: append([]*message.Message(nil), pending.messages...),
+		Sequence: pending.sequence,
+	}
+}
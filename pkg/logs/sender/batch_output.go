@@ -0,0 +1,63 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package sender
+
+import (
+	"sync/atomic"
+
+	"github.com/DataDog/datadog-agent/pkg/logs/message"
+	"github.com/DataDog/datadog-agent/pkg/logs/metrics"
+)
+
+// sent sends the payload delivered to the main destination to the additional destinations only once,
+// then forwards its messages to outputChan.
+func (b *BatchSender) sent(pending batch) {
+	if b.destinations != nil {
+		// a sender built with a transport only has no additional destinations
+		for _, destination := range b.destinations.Additionals {
+			// send to a queue then send asynchronously for additional endpoints,
+			// it will drop messages if the queue is full
+			destination.SendAsync(pending.payload)
+		}
+	}
+
+	if pending.heartbeat {
+		atomic.AddInt64(&b.counters.heartbeatsSent, 1)
+		return
+	}
+
+	metrics.LogsSent.Add(1)
+	atomic.AddInt64(&b.counters.batchesSent, 1)
+	atomic.AddInt64(&b.counters.messagesSent, int64(len(pending.messages)))
+	atomic.AddInt64(&b.counters.bytesSent, int64(len(pending.payload)))
+
+	for _, m := range pending.messages {
+		b.forward(m)
+	}
+}
+
+// forward forwards the message to outputChan, through the output buffer if any.
+func (b *BatchSender) forward(m *message.Message) {
+	if b.outputRing == nil {
+		b.outputChan <- m
+		return
+	}
+	if b.outputRing.push(m) {
+		atomic.AddInt64(&b.counters.outputDroppedMessages, 1)
+	}
+}
+
+// forwardMessages forwards the messages of the output buffer to outputChan until it is closed.
+func (b *BatchSender) forwardMessages() {
+	defer close(b.forwarded)
+	for {
+		m, ok := b.outputRing.pop()
+		if !ok {
+			return
+		}
+		b.outputChan <- m
+	}
+}
@@ -7,137 +7,728 @@ package sender
 
 import (
 	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/DataDog/datadog-agent/pkg/util/log"
 
 	"github.com/DataDog/datadog-agent/pkg/logs/client"
+	"github.com/DataDog/datadog-agent/pkg/logs/client/file"
 	"github.com/DataDog/datadog-agent/pkg/logs/message"
-	"github.com/DataDog/datadog-agent/pkg/logs/metrics"
-)
-
-const (
-	batchTimeout   = 5 * time.Second
-	maxBatchSize   = 20
-	maxContentSize = 1000000
 )
 
 // BatchSender is responsible for sending a batch of logs to different destinations.
 type BatchSender struct {
-	inputChan     chan *message.Message
-	outputChan    chan *message.Message
-	destinations  *client.Destinations
-	done          chan struct{}
-	batchTimeout  time.Duration
-	messageBuffer *MessageBuffer
+	inputChan      chan *message.Message
+	outputChan     chan *message.Message
+	destinations   *client.Destinations
+	done           chan struct{}
+	ctx            context.Context
+	flushChan      chan chan struct{}
+	batchTimeout   time.Duration
+	flushBytes     int
+	maxMessageSize int
+	jitter         float64
+	adaptive       *adaptiveTimeout
+	random         func() float64
+	messageBuffer  *MessageBuffer
+	compressor     Compressor
+	sealStages     []sealStage
+	heartbeatSeals []sealStage
+	delivery       *delivery
+	rateLimiter    RateLimiter
+	dropPolicy     DropPolicy
+	onDrop         func(m *message.Message)
+	deadLetterChan chan *FailedPayload
+	senders        int
+	batchChan      chan batch
+	inFlight       sync.WaitGroup
+	inFlightBytes  *byteLimiter
+	pending        *byteLimiter
+	pendingPolicy  PendingPolicy
+	flushObserver  func(info FlushInfo)
+	errorHandler   ErrorHandler
+	bisectDepth    int
+	wal            *file.WAL
+	walFull        bool
+	recovered      []*message.Message
+	sampler        Sampler
+	heartbeat      func() []byte
+	outputRing     *messageRing
+	forwarded      chan struct{}
+	finalFlush     time.Duration
+	drainTimeout   time.Duration
+	enqueueTimes   []time.Time
+	clock          clock
+	counters       batchCounters
+}
+
+// batch is a payload ready to be sent along with the messages it was built from.
+type batch struct {
+	payload   []byte
+	signature []byte
+	sequence  uint64
+	messages  []*message.Message
+	// contentEncoding is the encoding of the compressed payloads, empty when the payload is not compressed
+	contentEncoding string
+	// encryption is the cipher of the encrypted payloads, empty when the payload is not encrypted
+	encryption string
+	reason     FlushReason
+	heartbeat  bool
+	// depth is the number of times the messages were split from a rejected batch
+	depth int
+	// info describes the batch to the flush observer, nil when there is none
+	info *FlushInfo
+	// compressDuration is the time spent compressing the payload
+	compressDuration time.Duration
 }
 
 // NewBatchSender returns an new BatchSender.
-func NewBatchSender(inputChan, outputChan chan *message.Message, destinations *client.Destinations) *BatchSender {
-	return &BatchSender{
-		inputChan:     inputChan,
-		outputChan:    outputChan,
-		destinations:  destinations,
-		done:          make(chan struct{}),
-		batchTimeout:  batchTimeout,
-		messageBuffer: NewMessageBuffer(maxBatchSize, maxContentSize),
+func NewBatchSender(inputChan, outputChan chan *message.Message, destinations *client.Destinations, config BatchConfig) *BatchSender {
+	config = config.withDefaults()
+	if config.Compressor != nil && config.Transport == nil && compressesPayloads(destinations) {
+		log.Warnf("The destinations already compress the payloads, the payloads will not be compressed twice")
+		config.Compressor = nil
+	}
+	transport := config.Transport
+	var main client.Destination
+	if transport == nil && destinations != nil {
+		main = destinations.Main
+		if compressing, ok := main.(*CompressingDestination); ok {
+			// compress the payloads before they are signed and checksummed rather than in the destination,
+			// so that the signature and the checksum cover the payloads as sent
+			config.Compressor = compressing.compressor
+			main = compressing.destination
+		}
+		transport = client.NewDestinationTransport(main)
+	}
+	signingKey := config.SigningKey
+	if len(signingKey) > 0 && main != nil {
+		_, signed := main.(client.SignedDestination)
+		_, enveloped := main.(client.EnvelopeDestination)
+		if !signed && !enveloped {
+			log.Warnf("The main destination does not support signed payloads, the payloads will not be signed")
+			signingKey = nil
+		}
 	}
+	var outputRing *messageRing
+	if config.OutputBufferSize > 0 {
+		outputRing = newMessageRing(config.OutputBufferSize)
+	}
+	var wal *file.WAL
+	var recovered []*message.Message
+	if config.WALPath != "" {
+		wal, recovered = openWAL(config.WALPath, config.WALMaxSize)
+		if wal != nil && config.MaxConcurrentSends > 1 {
+			log.Warnf("The payloads are sent one after the other when a write-ahead log is used")
+			config.MaxConcurrentSends = 0
+		}
+	}
+	var adaptive *adaptiveTimeout
+	if config.MaxBatchTimeout > 0 {
+		adaptive = newAdaptiveTimeout(config.MinBatchTimeout, config.MaxBatchTimeout, config.BatchTimeout, config.MaxBatchSize)
+	}
+	b := &BatchSender{
+		inputChan:      inputChan,
+		outputChan:     outputChan,
+		destinations:   destinations,
+		done:           make(chan struct{}),
+		ctx:            context.Background(),
+		flushChan:      make(chan chan struct{}),
+		batchTimeout:   config.BatchTimeout,
+		flushBytes:     config.FlushBytesThreshold,
+		maxMessageSize: config.MaxMessageSize,
+		jitter:         config.BatchTimeoutJitter,
+		adaptive:       adaptive,
+		random:         rand.Float64,
+		compressor:     config.Compressor,
+		rateLimiter:    config.RateLimiter,
+		dropPolicy:     config.DropPolicy,
+		onDrop:         config.OnDrop,
+		deadLetterChan: config.DeadLetterChan,
+		senders:        config.MaxConcurrentSends,
+		batchChan:      make(chan batch),
+		inFlightBytes:  newByteLimiter(config.MaxInFlightBytes),
+		pending:        newByteLimiter(int64(config.MaxPendingMessages)),
+		pendingPolicy:  config.PendingPolicy,
+		flushObserver:  config.FlushObserver,
+		errorHandler:   config.ErrorHandler,
+		bisectDepth:    config.BisectDepth,
+		wal:            wal,
+		recovered:      recovered,
+		sampler:        config.Sampler,
+		heartbeat:      config.Heartbeat,
+		outputRing:     outputRing,
+		forwarded:      make(chan struct{}),
+		finalFlush:     config.FinalFlushTimeout,
+		drainTimeout:   config.DrainTimeout,
+		enqueueTimes:   make([]time.Time, 0, config.MaxBatchSize),
+		clock:          realClock{},
+	}
+	b.messageBuffer = newMessageBuffer(config.MaxBatchSize, config.MaxContentSize, config.Formatter, config.DropPolicy, b.evicted)
+	// the stages read the clock of the sender on every call so that it can be replaced
+	b.sealStages = newSealStages(config.Compressor, config.Encryptor, signingKey, senderClock{b})
+	// the heartbeats are tiny, they are not compressed
+	b.heartbeatSeals = newSealStages(nil, config.Encryptor, signingKey, senderClock{b})
+	b.delivery = &delivery{
+		transport: transport,
+		backoff: backoffPolicy{
+			base:        config.BackoffBase,
+			factor:      config.BackoffFactor,
+			maxAttempts: config.MaxSendAttempts,
+			maxElapsed:  config.BackoffMaxElapsedTime,
+		},
+		clock:    senderClock{b},
+		counters: &b.counters,
+	}
+	if config.CircuitBreakerThreshold > 0 {
+		b.delivery.circuitBreaker = newCircuitBreaker(config.CircuitBreakerThreshold, config.CircuitBreakerCooldown, func() time.Time {
+			return b.clock.Now()
+		})
+	}
+	return b
+}
+
+// Stats returns the current counters of the BatchSender,
+// it is safe to call while the BatchSender is running.
+func (b *BatchSender) Stats() BatchStats {
+	stats := b.counters.snapshot()
+	stats.InFlightBytes = b.inFlightBytes.held()
+	stats.PendingMessages = b.pending.held()
+	if b.delivery.circuitBreaker != nil {
+		stats.CircuitState = b.delivery.circuitBreaker.getState()
+	}
+	return stats
+}
+
+// BufferedCount returns the number of messages in the batch being built,
+// it can be called from any goroutine while the BatchSender is running.
+func (b *BatchSender) BufferedCount() int {
+	return int(atomic.LoadInt64(&b.counters.bufferedMessages))
+}
+
+// BufferedBytes returns the size in bytes of the payload of the batch being built, zero when it is empty,
+// it can be called from any goroutine while the BatchSender is running.
+func (b *BatchSender) BufferedBytes() int {
+	return int(atomic.LoadInt64(&b.counters.bufferedBytes))
+}
+
+// updateOccupancy publishes the occupancy of the buffer, it must be called every time the buffer changes.
+func (b *BatchSender) updateOccupancy() {
+	var messages, bytes int
+	if !b.messageBuffer.IsEmpty() {
+		messages = len(b.messageBuffer.GetMessages())
+		bytes = b.messageBuffer.ContentSize()
+	}
+	atomic.StoreInt64(&b.counters.bufferedMessages, int64(messages))
+	atomic.StoreInt64(&b.counters.bufferedBytes, int64(bytes))
+}
+
+// ContentEncoding returns the content encoding of the compressed payloads,
+// it is empty when compression is disabled.
+func (b *BatchSender) ContentEncoding() string {
+	if b.compressor == nil {
+		return ""
+	}
+	return b.compressor.ContentEncoding()
 }
 
 // Start starts the BatchSender
 func (b *BatchSender) Start() {
+	b.StartWithContext(context.Background())
+}
+
+// StartWithContext starts the BatchSender which stops when the context is cancelled,
+// the messages buffered are then sent within FinalFlushTimeout and the messages
+// left in inputChan are not read.
+func (b *BatchSender) StartWithContext(ctx context.Context) {
+	b.ctx = ctx
+	if b.outputRing != nil {
+		go b.forwardMessages()
+	}
+	if b.senders > 1 {
+		for i := 0; i < b.senders; i++ {
+			go b.sendBatches()
+		}
+	}
 	go b.run()
 }
 
 // Stop stops the BatchSender,
-// this call blocks until inputChan is flushed
+// this call blocks until inputChan is flushed or the drain timeout expires, or returns right away
+// when the BatchSender was already stopped by its context.
 func (b *BatchSender) Stop() {
 	close(b.inputChan)
 	<-b.done
 }
 
+// Flush sends the messages currently buffered without waiting for the batch timeout,
+// this call blocks until the buffer and all the payloads in flight have been sent and must only be made
+// while the BatchSender is running.
+func (b *BatchSender) Flush() {
+	flushed := make(chan struct{})
+	b.flushChan <- flushed
+	<-flushed
+}
+
 // run lets the BatchSender send messages.
 func (b *BatchSender) run() {
-	flushTimer := time.NewTimer(b.batchTimeout)
+	flushTimer := b.clock.NewTimer(b.flushTimeout())
 	defer func() {
 		flushTimer.Stop()
-		b.done <- struct{}{}
+		close(b.done)
 	}()
 
+	if len(b.recovered) > 0 {
+		// the recovered messages are written again to the log as they are buffered
+		b.truncateWAL()
+		for _, m := range b.recovered {
+			b.buffer(m, flushTimer)
+		}
+		b.recovered = nil
+	}
+
 	for {
 		select {
+		case <-b.ctx.Done():
+			// the context has been cancelled, send what was buffered
+			// without waiting longer than the final flush timeout
+			b.drain(b.finalFlush)
+			return
 		case payload, isOpen := <-b.inputChan:
 			if !isOpen {
 				// inputChan has been closed, no more payload are expected
-				b.sendBuffer()
+				b.waitRateLimit()
+				b.drain(b.drainTimeout)
 				return
 			}
-			success := b.messageBuffer.TryAddMessage(payload)
-			if !success || b.messageBuffer.IsFull() {
-				// message buffer is full, either reaching maxBatchCount of maxRequestSize
-				// send request now. reset the timer
-				if !flushTimer.Stop() {
-					<-flushTimer.C
-				}
-				b.sendBuffer()
-				flushTimer.Reset(b.batchTimeout)
+			if b.maxMessageSize > 0 && len(payload.Content) > b.maxMessageSize {
+				b.dropOversized(payload)
+				continue
 			}
-			if !success {
-				// it's possible we didn't append last try because maxRequestSize is reached
-				// append it again after the sendbuffer is flushed
-				b.messageBuffer.TryAddMessage(payload)
+			if b.sampler != nil && !b.sampler.Sample() {
+				atomic.AddInt64(&b.counters.sampledOutMessages, 1)
+				continue
 			}
-		case <-flushTimer.C:
+			b.buffer(payload, flushTimer)
+		case flushed := <-b.flushChan:
+			// a flush was requested, send the buffer now and reset the timer
+			if !flushTimer.Stop() {
+				<-flushTimer.C()
+			}
+			b.waitRateLimit()
+			b.sendBuffer(FlushReasonRequested)
+			b.inFlight.Wait()
+			flushTimer.Reset(b.flushTimeout())
+			close(flushed)
+		case <-flushTimer.C():
 			// the timout expired, the content is ready to be sent
-			b.sendBuffer()
-			flushTimer.Reset(b.batchTimeout)
+			// unless the rate limit is reached, then keep buffering.
+			if b.messageBuffer.IsEmpty() && b.heartbeat != nil {
+				b.sendHeartbeat()
+			} else if b.allowRateLimit() {
+				b.sendBuffer(FlushReasonTimeout)
+			}
+			flushTimer.Reset(b.flushTimeout())
+		}
+	}
+}
+
+// buffer adds the message to the buffer, sending the buffer first when the message does not fit
+// or once it is full.
+func (b *BatchSender) buffer(payload *message.Message, flushTimer timer) {
+	if !b.admit(flushTimer) {
+		atomic.AddInt64(&b.counters.pendingDropped, 1)
+		return
+	}
+	received := b.clock.Now()
+	if b.adaptive != nil {
+		b.adaptive.observe(received)
+	}
+	if !b.messageBuffer.Fits(payload) {
+		// the message would never fit in the buffer, truncate it instead of dropping it
+		b.truncate(payload)
+	}
+	if b.dropPolicy == DropOldest {
+		b.bufferDroppingOldest(payload, received, flushTimer)
+		return
+	}
+	success := b.messageBuffer.TryAddMessage(payload)
+	if success {
+		b.enqueued(payload, received)
+	}
+	if !success || b.messageBuffer.IsFull() || b.reachedFlushBytes() {
+		// message buffer is full, either reaching maxBatchCount of maxRequestSize,
+		// or holds enough bytes, send request now. reset the timer
+		if !flushTimer.Stop() {
+			<-flushTimer.C()
+		}
+		reason := FlushReasonBufferFull
+		if !success {
+			reason = FlushReasonContentSizeExceeded
+		} else if !b.messageBuffer.IsFull() {
+			reason = FlushReasonBytesThreshold
+		}
+		b.waitRateLimit()
+		b.sendBuffer(reason)
+		flushTimer.Reset(b.flushTimeout())
+	}
+	if !success {
+		// it's possible we didn't append last try because maxRequestSize is reached
+		// append it again after the sendbuffer is flushed
+		if b.messageBuffer.TryAddMessage(payload) {
+			b.enqueued(payload, received)
+		} else {
+			log.Warnf("Could not add message of %d bytes to an empty batch, dropping it", len(payload.Content))
+			atomic.AddInt64(&b.counters.droppedMessages, 1)
+			b.pending.release(1)
 		}
 	}
 }
 
-// send keeps trying to send the message to the main destination until it succeeds
-// and try to send the message to the additional destinations only once.
-func (b *BatchSender) sendBuffer() {
+// bufferDroppingOldest adds the message to the buffer like buffer, except that the buffer is only sent when the
+// rate limiter allows it right away: otherwise the oldest messages are evicted to make room for the new one.
+func (b *BatchSender) bufferDroppingOldest(payload *message.Message, received time.Time, flushTimer timer) {
+	if !b.messageBuffer.hasSpaceFor(payload) {
+		b.sendBufferIfAllowed(flushTimer, FlushReasonContentSizeExceeded)
+	}
+	if !b.messageBuffer.TryAddMessage(payload) {
+		log.Warnf("Could not add message of %d bytes to an empty batch, dropping it", len(payload.Content))
+		atomic.AddInt64(&b.counters.droppedMessages, 1)
+		b.pending.release(1)
+		return
+	}
+	b.enqueued(payload, received)
+	if b.messageBuffer.IsFull() {
+		b.sendBufferIfAllowed(flushTimer, FlushReasonBufferFull)
+	} else if b.reachedFlushBytes() {
+		b.sendBufferIfAllowed(flushTimer, FlushReasonBytesThreshold)
+	}
+}
+
+// sendBufferIfAllowed sends the buffer and resets the timer when the rate limiter allows it.
+func (b *BatchSender) sendBufferIfAllowed(flushTimer timer, reason FlushReason) {
+	if !b.allowRateLimit() {
+		return
+	}
+	if !flushTimer.Stop() {
+		<-flushTimer.C()
+	}
+	b.sendBuffer(reason)
+	flushTimer.Reset(b.flushTimeout())
+}
+
+// evicted accounts for a message evicted from the buffer to make room for a new one.
+func (b *BatchSender) evicted(m *message.Message) {
+	atomic.AddInt64(&b.counters.evictedMessages, 1)
+	copy(b.enqueueTimes, b.enqueueTimes[1:])
+	b.enqueueTimes = b.enqueueTimes[:len(b.enqueueTimes)-1]
+	b.pending.release(1)
+	if b.onDrop != nil {
+		b.onDrop(m)
+	}
+}
+
+// admit counts a new pending message, it returns false when the message must be dropped
+// because the pending messages limit is reached. With PendingBlock, it sends the buffered
+// messages then blocks until enough pending messages have been sent.
+func (b *BatchSender) admit(flushTimer timer) bool {
+	if b.pending.tryAcquire(1) {
+		return true
+	}
+	if b.pendingPolicy == PendingDropNewest {
+		return false
+	}
+	if !b.messageBuffer.IsEmpty() {
+		// the buffered messages are pending too, they must be sent for the limit to be released
+		if !flushTimer.Stop() {
+			<-flushTimer.C()
+		}
+		b.waitRateLimit()
+		b.sendBuffer(FlushReasonPendingLimit)
+		flushTimer.Reset(b.flushTimeout())
+	}
+	// this call blocks until enough pending messages have been sent
+	b.pending.acquire(1)
+	return true
+}
+
+// enqueued records the message added to the buffer.
+func (b *BatchSender) enqueued(m *message.Message, received time.Time) {
+	b.enqueueTimes = append(b.enqueueTimes, received)
+	b.updateOccupancy()
+	if b.wal == nil {
+		return
+	}
+	err := b.wal.Append(encodeWALEntry(m))
+	if err == file.ErrWALFull {
+		if !b.walFull {
+			log.Warnf("The write-ahead log is full, the messages are only buffered in memory until the next batch is sent")
+			b.walFull = true
+		}
+	} else if err != nil {
+		log.Warnf("Could not write message to the write-ahead log: %v", err)
+	}
+}
+
+// truncateWAL removes the messages from the write-ahead log.
+func (b *BatchSender) truncateWAL() {
+	if err := b.wal.Truncate(); err != nil {
+		log.Warnf("Could not truncate the write-ahead log: %v", err)
+	}
+	b.walFull = false
+}
+
+// shutdown waits for the payloads in flight to be sent and stops the senders.
+func (b *BatchSender) shutdown() {
+	b.inFlight.Wait()
+	close(b.batchChan)
+	if b.wal != nil {
+		b.wal.Close()
+	}
+	if b.outputRing != nil {
+		// let the buffered messages be forwarded
+		b.outputRing.close()
+		<-b.forwarded
+	}
+}
+
+// drain sends the buffer and shuts the senders down, giving up after the timeout when a send
+// or outputChan hangs, the shutdown then completes in the background. Zero means no timeout.
+func (b *BatchSender) drain(timeout time.Duration) {
+	if timeout == 0 {
+		b.sendBuffer(FlushReasonShutdown)
+		b.shutdown()
+		return
+	}
+	flushed := make(chan struct{})
+	go func() {
+		b.sendBuffer(FlushReasonShutdown)
+		b.shutdown()
+		close(flushed)
+	}()
+	timer := b.clock.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-flushed:
+	case <-timer.C():
+		log.Warnf("Could not send the buffered messages within %v, stopping without waiting for them", timeout)
+	}
+}
+
+// reachedFlushBytes returns true if the buffered payload reached the flush bytes threshold.
+func (b *BatchSender) reachedFlushBytes() bool {
+	return b.flushBytes > 0 && b.messageBuffer.ContentSize() >= b.flushBytes
+}
+
+// flushTimeout returns the batch timeout, adapted to the arrival rate when enabled, with a random jitter applied.
+func (b *BatchSender) flushTimeout() time.Duration {
+	timeout := b.batchTimeout
+	if b.adaptive != nil {
+		timeout = b.adaptive.timeout()
+	}
+	if b.jitter == 0 {
+		return timeout
+	}
+	// random returns a number in [0, 1), scale it to [-jitter, jitter)
+	delta := (2*b.random() - 1) * b.jitter
+	return time.Duration(float64(timeout) * (1 + delta))
+}
+
+// waitRateLimit blocks until the buffer can be sent according to the rate limiter.
+func (b *BatchSender) waitRateLimit() {
+	if b.rateLimiter != nil && !b.messageBuffer.IsEmpty() {
+		b.rateLimiter.Wait()
+	}
+}
+
+// allowRateLimit returns true if the buffer can be sent now according to the rate limiter.
+func (b *BatchSender) allowRateLimit() bool {
+	return b.rateLimiter == nil || b.messageBuffer.IsEmpty() || b.rateLimiter.Allow()
+}
+
+// dropOversized counts the message as dropped for exceeding the max message size,
+// a warning is logged for the first drops then once every 100 drops.
+func (b *BatchSender) dropOversized(m *message.Message) {
+	count := atomic.AddInt64(&b.counters.oversizedDropped, 1)
+	if count <= 5 || count%100 == 0 {
+		log.Warnf("Dropped message of %d bytes larger than the max message size of %d bytes (%d dropped so far)", len(m.Content), b.maxMessageSize, count)
+	}
+}
+
+// truncate shortens the content of the message with the formatter so that it fits in an empty buffer
+// and flags it as truncated, the message is left as is when it can not be shortened.
+func (b *BatchSender) truncate(m *message.Message) {
+	maxContentSize := b.messageBuffer.MaxContentSize()
+	content, ok := b.messageBuffer.formatter.truncate(m.Content, maxContentSize)
+	if !ok {
+		return
+	}
+	log.Debugf("Truncating message of %d bytes to %d bytes", len(m.Content), len(content))
+	m.Content = content
+	atomic.AddInt64(&b.counters.truncatedMessages, 1)
+}
+
+// sendBuffer sends the buffered messages, either right away or through one of the concurrent senders.
+func (b *BatchSender) sendBuffer(reason FlushReason) {
 	if b.messageBuffer.IsEmpty() {
 		return
 	}
 
-	batchedContent := b.messageBuffer.GetPayload()
-	defer b.messageBuffer.Clear()
+	b.counters.countFlush(reason)
 
-	for {
-		// this call is blocking until payload is sent (or the connection destination context cancelled)
-		err := b.destinations.Main.Send(batchedContent)
-		if err != nil {
-			metrics.DestinationErrors.Add(1)
-			if err == context.Canceled {
-				// the context was cancelled, agent is stopping non-gracefully.
-				// drop the message
-				return
-			}
+	payload := b.messageBuffer.GetPayload()
+	defer func() {
+		b.messageBuffer.Reset()
+		b.enqueueTimes = b.enqueueTimes[:0]
+		b.updateOccupancy()
+	}()
 
-			switch err.(type) {
-			case *client.RetryableError:
-				// could not send the payload because of a transport issue,
-				// let's retry.
-				continue
-			}
+	var info *FlushInfo
+	if b.flushObserver != nil {
+		flushInfo := b.flushInfo(len(payload), reason)
+		info = &flushInfo
+	}
 
-			log.Warnf("Could not send payload, dropping it: %v", err)
-			break
+	sealed, err := seal(b.sealStages, payload)
+	if err != nil {
+		messages := b.messageBuffer.GetMessages()
+		b.sealFailed(batch{payload: payload, messages: messages, reason: reason}, err)
+		b.pending.release(int64(len(messages)))
+		if b.wal != nil {
+			b.truncateWAL()
 		}
+		return
+	}
+	payload = sealed.payload
+	if info != nil {
+		info.CompressedBytes = len(payload)
+		info.CompressDuration = sealed.compressDuration
+	}
+
+	// the sequence number is assigned once so that retries keep it
+	sealed.sequence = b.nextSequence()
+	sealed.reason = reason
+	sealed.info = info
+
+	// this call blocks until enough payloads in flight have been sent
+	b.inFlightBytes.acquire(int64(len(payload)))
+
+	if b.senders <= 1 {
+		messages := b.messageBuffer.GetMessages()
+		sealed.messages = messages
+		handled := b.sendObserved(sealed)
+		b.inFlightBytes.release(int64(len(payload)))
+		b.pending.release(int64(len(messages)))
+		if b.wal != nil && handled {
+			// the messages are not needed anymore, keep them when the send was cancelled
+			// so that they are sent once the agent restarts.
+			b.truncateWAL()
+		}
+		return
+	}
+
+	// the buffers are reused for the next batch, copy them,
+	// this call blocks until a sender is available.
+	b.inFlight.Add(1)
+	sealed.payload = append([]byte(nil), payload...)
+	sealed.messages = append([]*message.Message(nil), b.messageBuffer.GetMessages()...)
+	b.batchChan <- sealed
+}
+
+// sendHeartbeat sends the heartbeat payload right away.
+func (b *BatchSender) sendHeartbeat() {
+	sealed, err := seal(b.heartbeatSeals, b.heartbeat())
+	if err != nil {
+		log.Warnf("Could not encrypt heartbeat: %v", err)
+		return
+	}
+	sealed.sequence = b.nextSequence()
+	sealed.reason = FlushReasonTimeout
+	sealed.heartbeat = true
+	b.send(sealed)
+}
+
+// nextSequence returns the sequence number of the next payload.
+func (b *BatchSender) nextSequence() uint64 {
+	return atomic.AddUint64(&b.counters.sequence, 1)
+}
 
-		for _, destination := range b.destinations.Additionals {
-			// send to a queue then send asynchronously for additional endpoints,
-			// it will drop messages if the queue is full
-			destination.SendAsync(batchedContent)
+// flushInfo describes the buffered batch, the ages of the messages are computed
+// from the times they were received.
+func (b *BatchSender) flushInfo(bytes int, reason FlushReason) FlushInfo {
+	info := FlushInfo{
+		Bytes:  bytes,
+		Count:  len(b.messageBuffer.GetMessages()),
+		Reason: reason,
+	}
+	if len(b.enqueueTimes) == 0 {
+		return info
+	}
+	now := b.clock.Now()
+	var total time.Duration
+	for _, enqueueTime := range b.enqueueTimes {
+		age := now.Sub(enqueueTime)
+		if age > info.MaxAge {
+			info.MaxAge = age
 		}
+		total += age
+	}
+	info.MeanAge = total / time.Duration(len(b.enqueueTimes))
+	return info
+}
 
-		metrics.LogsSent.Add(1)
-		break
+// sendBatches sends the batches received on batchChan until it is closed.
+func (b *BatchSender) sendBatches() {
+	for pending := range b.batchChan {
+		b.sendObserved(pending)
+		b.inFlightBytes.release(int64(len(pending.payload)))
+		b.pending.release(int64(len(pending.messages)))
+		b.inFlight.Done()
 	}
+}
 
-	for _, m := range b.messageBuffer.GetMessages() {
-		b.outputChan <- m
+// sendObserved sends the batch then reports it to the flush observer along with the time spent sending it.
+func (b *BatchSender) sendObserved(pending batch) bool {
+	start := b.clock.Now()
+	handled := b.send(pending)
+	if pending.info != nil {
+		info := *pending.info
+		info.SendDuration = b.clock.Now().Sub(start)
+		b.flushObserver(info)
+	}
+	return handled
+}
+
+// send delivers the batch to the main destination, then hands it over to the output stage once sent
+// or to the failure stages once given up on: the rejected batches are bisected when possible,
+// the others are dead-lettered. It returns false when the payload was dropped because the sender
+// or the destination was cancelled.
+func (b *BatchSender) send(pending batch) bool {
+	outcome, attempts, err := b.delivery.deliver(b.ctx, pending)
+	switch outcome {
+	case delivered:
+		b.sent(pending)
+		return true
+	case cancelled:
+		// drop the message
+		return false
+	case rejected:
+		if pending.depth < b.bisectDepth && len(pending.messages) > 1 {
+			// the payload may be rejected because of some of its messages only,
+			// try to send the others.
+			return b.bisect(pending)
+		}
+		b.giveUp(pending, err, attempts, "Could not send payload")
+		return true
+	case exhausted:
+		b.giveUp(pending, err, attempts, fmt.Sprintf("Could not send payload after %d attempts", attempts))
+		return true
+	default:
+		b.giveUp(pending, err, attempts, "Could not send payload before the sender was cancelled")
+		return false
 	}
 }
@@ -0,0 +1,2042 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package sender
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/DataDog/datadog-agent/pkg/logs/client"
+	"github.com/DataDog/datadog-agent/pkg/logs/config"
+	"github.com/DataDog/datadog-agent/pkg/logs/decoder"
+	"github.com/DataDog/datadog-agent/pkg/logs/message"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+func TestBatchConfigDefaults(t *testing.T) {
+	batchConfig := BatchConfig{}.withDefaults()
+	assert.Equal(t, defaultMaxBatchSize, batchConfig.MaxBatchSize)
+	assert.Equal(t, defaultMaxContentSize, batchConfig.MaxContentSize)
+	assert.Equal(t, defaultBatchTimeout, batchConfig.BatchTimeout)
+
+	batchConfig = BatchConfig{MaxBatchSize: -1, MaxContentSize: -1, BatchTimeout: -time.Second, MaxConcurrentSends: -1}.withDefaults()
+	assert.Equal(t, defaultMaxBatchSize, batchConfig.MaxBatchSize)
+	assert.Equal(t, defaultMaxContentSize, batchConfig.MaxContentSize)
+	assert.Equal(t, defaultBatchTimeout, batchConfig.BatchTimeout)
+	assert.Equal(t, 0, batchConfig.MaxConcurrentSends)
+	assert.Equal(t, defaultFinalFlushTimeout, batchConfig.FinalFlushTimeout)
+
+	batchConfig = BatchConfig{MaxBatchSize: 5, MaxContentSize: 100, BatchTimeout: time.Second}.withDefaults()
+	assert.Equal(t, 5, batchConfig.MaxBatchSize)
+	assert.Equal(t, 100, batchConfig.MaxContentSize)
+	assert.Equal(t, time.Second, batchConfig.BatchTimeout)
+}
+
+func TestNewBatchSenderUsesConfig(t *testing.T) {
+	sender := NewBatchSender(nil, nil, nil, BatchConfig{MaxBatchSize: 3, MaxContentSize: 100, BatchTimeout: time.Second})
+	assert.Equal(t, time.Second, sender.batchTimeout)
+	assert.Equal(t, 3, cap(sender.messageBuffer.GetMessages()))
+	assert.Equal(t, 100, cap(sender.messageBuffer.GetPayload()))
+}
+
+// mockDestination records the payloads it receives, it returns errs in order
+// on the first calls to Send, then err.
+type mockDestination struct {
+	mu       sync.Mutex
+	payloads chan []byte
+	errs     []error
+	err      error
+	attempts int
+}
+
+func newMockDestination(err error, errs ...error) *mockDestination {
+	return &mockDestination{
+		payloads: make(chan []byte, 10),
+		errs:     errs,
+		err:      err,
+	}
+}
+
+func (d *mockDestination) Send(payload []byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.attempts++
+	if len(d.errs) > 0 {
+		err := d.errs[0]
+		d.errs = d.errs[1:]
+		return err
+	}
+	if d.err != nil {
+		return d.err
+	}
+	d.payloads <- append([]byte(nil), payload...)
+	return nil
+}
+
+func (d *mockDestination) getAttempts() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.attempts
+}
+
+func (d *mockDestination) SendAsync(payload []byte) {}
+
+func TestBatchSenderForwardsSentMessages(t *testing.T) {
+	input := make(chan *message.Message, 1)
+	output := make(chan *message.Message, 1)
+	destination := newMockDestination(nil)
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{MaxBatchSize: 1})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	expectedMessage := newMessage([]byte("fake line"), source, "")
+	input <- expectedMessage
+
+	assert.Equal(t, "[fake line]", string(<-destination.payloads))
+	assert.Equal(t, expectedMessage, <-output)
+
+	sender.Stop()
+}
+
+func TestBatchSenderDoesNotForwardMessagesOnSendFailure(t *testing.T) {
+	input := make(chan *message.Message, 1)
+	output := make(chan *message.Message, 1)
+	destination := newMockDestination(errors.New("client error"))
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{MaxBatchSize: 1})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	input <- newMessage([]byte("fake line"), source, "")
+
+	sender.Stop()
+	assert.Len(t, output, 0)
+}
+
+func TestBatchSenderCompressesPayloads(t *testing.T) {
+	input := make(chan *message.Message, 1)
+	output := make(chan *message.Message, 2)
+	destination := newMockDestination(nil)
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{MaxBatchSize: 2, MaxContentSize: 1100, Compressor: NewGzipCompressor(gzip.DefaultCompression)})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	content := bytes.Repeat([]byte("a"), 500)
+	input <- newMessage(content, source, "")
+	input <- newMessage(content, source, "")
+
+	payload := <-destination.payloads
+	assert.True(t, len(payload) < 1000)
+	assert.Equal(t, fmt.Sprintf("[%s,%s]", content, content), string(gunzip(t, payload)))
+	assert.Equal(t, "gzip", sender.ContentEncoding())
+
+	sender.Stop()
+}
+
+func TestBatchSenderRetriesWithBackoff(t *testing.T) {
+	input := make(chan *message.Message, 1)
+	output := make(chan *message.Message, 1)
+	retryableErr := client.NewRetryableError(errors.New("server error"))
+	destination := newMockDestination(nil, retryableErr, retryableErr)
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{MaxBatchSize: 1, BackoffBase: time.Millisecond})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	expectedMessage := newMessage([]byte("fake line"), source, "")
+	input <- expectedMessage
+
+	assert.Equal(t, "[fake line]", string(<-destination.payloads))
+	assert.Equal(t, expectedMessage, <-output)
+	assert.Equal(t, 3, destination.getAttempts())
+
+	sender.Stop()
+}
+
+func TestBatchSenderRetriesRightAwayByDefault(t *testing.T) {
+	input := make(chan *message.Message, 1)
+	output := make(chan *message.Message, 1)
+	retryableErr := client.NewRetryableError(errors.New("server error"))
+	destination := newMockDestination(nil, retryableErr, retryableErr)
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{MaxBatchSize: 1})
+	clock := newFakeClock()
+	sender.clock = clock
+	sender.Start()
+
+	// the clock never moves, the retries do not wait for it
+	source := config.NewLogSource("", &config.LogsConfig{})
+	input <- newMessage([]byte("a"), source, "")
+	assert.Equal(t, "[a]", string(<-destination.payloads))
+	assert.Equal(t, 3, destination.getAttempts())
+
+	sender.Stop()
+}
+
+func TestBatchSenderDropsPayloadWhenRetriesAreExhausted(t *testing.T) {
+	input := make(chan *message.Message, 1)
+	output := make(chan *message.Message, 1)
+	destination := newMockDestination(client.NewRetryableError(errors.New("server error")))
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{MaxBatchSize: 1, MaxSendAttempts: 3, BackoffBase: time.Millisecond})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	input <- newMessage([]byte("fake line"), source, "")
+
+	sender.Stop()
+	assert.Equal(t, 3, destination.getAttempts())
+	assert.Len(t, output, 0)
+}
+
+func TestBatchSenderDeadLettersPayloadWhenRetriesAreExhausted(t *testing.T) {
+	input := make(chan *message.Message, 1)
+	output := make(chan *message.Message, 1)
+	deadLetter := make(chan *FailedPayload, 2)
+	destination := newMockDestination(client.NewRetryableError(errors.New("server error")))
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{MaxBatchSize: 1, MaxSendAttempts: 3, BackoffBase: time.Millisecond, DeadLetterChan: deadLetter})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	expectedMessage := newMessage([]byte("fake line"), source, "")
+	input <- expectedMessage
+
+	sender.Stop()
+	assert.Equal(t, 3, destination.getAttempts())
+	assert.Len(t, output, 0)
+	assert.Len(t, deadLetter, 1)
+
+	failed := <-deadLetter
+	assert.Equal(t, "[fake line]", string(failed.Payload))
+	assert.Equal(t, []*message.Message{expectedMessage}, failed.Messages)
+}
+
+func TestBatchSenderDeadLettersPayloadOnSendFailure(t *testing.T) {
+	input := make(chan *message.Message, 1)
+	output := make(chan *message.Message, 1)
+	deadLetter := make(chan *FailedPayload, 2)
+	destination := newMockDestination(errors.New("client error"))
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{MaxBatchSize: 1, DeadLetterChan: deadLetter})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	input <- newMessage([]byte("fake line"), source, "")
+
+	sender.Stop()
+	assert.Equal(t, 1, destination.getAttempts())
+	assert.Len(t, output, 0)
+	assert.Len(t, deadLetter, 1)
+}
+
+func TestBatchSenderDoesNotRetryWhenContextIsCancelled(t *testing.T) {
+	input := make(chan *message.Message, 1)
+	output := make(chan *message.Message, 1)
+	destination := newMockDestination(context.Canceled)
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{MaxBatchSize: 1, BackoffBase: time.Millisecond})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	input <- newMessage([]byte("fake line"), source, "")
+
+	sender.Stop()
+	assert.Equal(t, 1, destination.getAttempts())
+	assert.Len(t, output, 0)
+}
+
+// waitForRead waits until all the messages of input have been read.
+func waitForRead(input chan *message.Message) {
+	for len(input) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBatchSenderFlushesWhenCancelled(t *testing.T) {
+	input := make(chan *message.Message, 2)
+	output := make(chan *message.Message, 2)
+	destination := newMockDestination(nil)
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{MaxBatchSize: 10, BatchTimeout: time.Hour})
+	ctx, cancel := context.WithCancel(context.Background())
+	sender.StartWithContext(ctx)
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	input <- newMessage([]byte("a"), source, "")
+	input <- newMessage([]byte("b"), source, "")
+	waitForRead(input)
+
+	cancel()
+	assert.Equal(t, "[a,b]", string(<-destination.payloads))
+
+	sender.Stop()
+	assert.Len(t, output, 2)
+	assert.Equal(t, int64(1), sender.Stats().BatchesSent)
+}
+
+// hangingDestination blocks on Send until it is released.
+type hangingDestination struct {
+	release chan struct{}
+}
+
+func (d *hangingDestination) Send(payload []byte) error {
+	<-d.release
+	return nil
+}
+
+func (d *hangingDestination) SendAsync(payload []byte) {}
+
+func TestBatchSenderDoesNotWaitForHangingSendWhenCancelled(t *testing.T) {
+	input := make(chan *message.Message, 1)
+	output := make(chan *message.Message, 1)
+	destination := &hangingDestination{release: make(chan struct{})}
+	defer close(destination.release)
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{BatchTimeout: time.Hour, FinalFlushTimeout: 10 * time.Millisecond})
+	ctx, cancel := context.WithCancel(context.Background())
+	sender.StartWithContext(ctx)
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	input <- newMessage([]byte("a"), source, "")
+	waitForRead(input)
+
+	cancel()
+	stopped := make(chan struct{})
+	go func() {
+		sender.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		assert.Fail(t, "the sender did not stop after the final flush timeout")
+	}
+}
+
+func TestBatchSenderLimitsInFlightBytes(t *testing.T) {
+	input := make(chan *message.Message, 3)
+	output := make(chan *message.Message, 3)
+	destination := &hangingDestination{release: make(chan struct{})}
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{MaxBatchSize: 1, MaxConcurrentSends: 2, MaxInFlightBytes: 5})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	input <- newMessage([]byte("a"), source, "")
+	input <- newMessage([]byte("b"), source, "")
+	input <- newMessage([]byte("c"), source, "")
+
+	// the first payload is being sent and there is no room for the second one,
+	// the third message is not read
+	for sender.Stats().InFlightBytes == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, int64(3), sender.Stats().InFlightBytes)
+	assert.Len(t, input, 1)
+
+	// once the first payload has been sent, the ingestion resumes
+	destination.release <- struct{}{}
+	waitForRead(input)
+	assert.Equal(t, int64(1), sender.Stats().BatchesSent)
+
+	close(destination.release)
+	sender.Stop()
+	assert.Equal(t, int64(0), sender.Stats().InFlightBytes)
+	assert.Len(t, output, 3)
+}
+
+func TestBatchSenderDrainTimeout(t *testing.T) {
+	input := make(chan *message.Message, 1)
+	// nobody reads outputChan
+	output := make(chan *message.Message)
+	destination := newMockDestination(nil)
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{BatchTimeout: time.Hour, DrainTimeout: 10 * time.Millisecond})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	input <- newMessage([]byte("a"), source, "")
+
+	stopped := make(chan struct{})
+	go func() {
+		sender.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		assert.Fail(t, "the sender did not stop after the drain timeout")
+	}
+	assert.Equal(t, "[a]", string(<-destination.payloads))
+
+	// let the send complete in the background
+	<-output
+}
+
+func TestBatchSenderStopsRetryingWhenCancelled(t *testing.T) {
+	input := make(chan *message.Message, 1)
+	output := make(chan *message.Message, 1)
+	deadLetters := make(chan *FailedPayload, 1)
+	destination := newMockDestination(client.NewRetryableError(errors.New("connection refused")))
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{MaxBatchSize: 1, BackoffBase: time.Hour, DeadLetterChan: deadLetters})
+	ctx, cancel := context.WithCancel(context.Background())
+	sender.StartWithContext(ctx)
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	input <- newMessage([]byte("a"), source, "")
+	waitForRead(input)
+
+	cancel()
+	sender.Stop()
+	assert.Len(t, deadLetters, 1)
+	assert.Len(t, output, 0)
+}
+
+// slowDestination takes delay to send a payload and records the maximum number of concurrent sends.
+type slowDestination struct {
+	mu         sync.Mutex
+	delay      time.Duration
+	sending    int
+	maxSending int
+}
+
+func (d *slowDestination) Send(payload []byte) error {
+	d.mu.Lock()
+	d.sending++
+	if d.sending > d.maxSending {
+		d.maxSending = d.sending
+	}
+	d.mu.Unlock()
+
+	time.Sleep(d.delay)
+
+	d.mu.Lock()
+	d.sending--
+	d.mu.Unlock()
+	return nil
+}
+
+func (d *slowDestination) SendAsync(payload []byte) {}
+
+func (d *slowDestination) getMaxSending() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.maxSending
+}
+
+func TestBatchSenderSendsConcurrently(t *testing.T) {
+	input := make(chan *message.Message, 4)
+	output := make(chan *message.Message, 4)
+	destination := &slowDestination{delay: 100 * time.Millisecond}
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{MaxBatchSize: 1, MaxConcurrentSends: 4})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	for i := 0; i < 4; i++ {
+		input <- newMessage([]byte("fake line"), source, "")
+	}
+
+	// stopping waits for the payloads in flight
+	sender.Stop()
+	assert.Len(t, output, 4)
+	assert.True(t, destination.getMaxSending() > 1)
+	assert.Equal(t, int64(4), sender.Stats().BatchesSent)
+}
+
+func TestBatchSenderSendsSequentiallyByDefault(t *testing.T) {
+	input := make(chan *message.Message, 4)
+	output := make(chan *message.Message, 4)
+	destination := &slowDestination{delay: 10 * time.Millisecond}
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{MaxBatchSize: 1})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	for i := 0; i < 4; i++ {
+		input <- newMessage([]byte("fake line"), source, "")
+	}
+
+	sender.Stop()
+	assert.Len(t, output, 4)
+	assert.Equal(t, 1, destination.getMaxSending())
+}
+
+func TestBatchSenderFlushWaitsForConcurrentSends(t *testing.T) {
+	input := make(chan *message.Message)
+	output := make(chan *message.Message, 2)
+	destination := &slowDestination{delay: 50 * time.Millisecond}
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{MaxBatchSize: 2, MaxConcurrentSends: 2, BatchTimeout: time.Hour})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	input <- newMessage([]byte("fake line"), source, "")
+
+	sender.Flush()
+	assert.Len(t, output, 1)
+
+	sender.Stop()
+}
+
+func TestBatchSenderStats(t *testing.T) {
+	input := make(chan *message.Message, 1)
+	output := make(chan *message.Message, 3)
+	destination := newMockDestination(nil, client.NewRetryableError(errors.New("server error")))
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{MaxBatchSize: 2, BatchTimeout: 200 * time.Millisecond, BackoffBase: time.Millisecond})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	input <- newMessage([]byte("a"), source, "")
+	input <- newMessage([]byte("b"), source, "")
+	assert.Equal(t, "[a,b]", string(<-destination.payloads))
+	<-output
+	<-output
+
+	input <- newMessage([]byte("c"), source, "")
+	assert.Equal(t, "[c]", string(<-destination.payloads))
+	<-output
+
+	stats := sender.Stats()
+	assert.Equal(t, int64(2), stats.BatchesSent)
+	assert.Equal(t, int64(3), stats.MessagesSent)
+	assert.Equal(t, int64(8), stats.BytesSent)
+	assert.Equal(t, int64(1), stats.SendFailures)
+	assert.Equal(t, int64(1), stats.FullFlushes)
+	assert.Equal(t, int64(1), stats.TimeoutFlushes)
+
+	sender.Stop()
+}
+
+func TestBatchSenderFlush(t *testing.T) {
+	// inputChan is not buffered so that each message is handled by the sender
+	// before the next call is made.
+	input := make(chan *message.Message)
+	output := make(chan *message.Message, 3)
+	destination := newMockDestination(nil)
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{BatchTimeout: time.Hour})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	input <- newMessage([]byte("a"), source, "")
+	input <- newMessage([]byte("b"), source, "")
+
+	sender.Flush()
+	assert.Equal(t, "[a,b]", string(<-destination.payloads))
+	assert.Len(t, output, 2)
+
+	// the sender keeps running after a flush
+	input <- newMessage([]byte("c"), source, "")
+	sender.Flush()
+	assert.Equal(t, "[c]", string(<-destination.payloads))
+	assert.Len(t, output, 3)
+	assert.Equal(t, int64(0), sender.Stats().TimeoutFlushes)
+
+	sender.Stop()
+}
+
+func TestBatchSenderTruncatesOversizedMessages(t *testing.T) {
+	input := make(chan *message.Message, 2)
+	output := make(chan *message.Message, 2)
+	destination := newMockDestination(nil)
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{BatchTimeout: time.Hour})
+	sender.Start()
+
+	// the messages are JSON objects built by the processor
+	source := config.NewLogSource("", &config.LogsConfig{})
+	text := strings.Repeat("é", defaultMaxContentSize)
+	content, err := json.Marshal(map[string]string{"message": text, "status": "info"})
+	require.NoError(t, err)
+	input <- newMessage(content, source, "")
+	input <- newMessage([]byte(`{"message":"b"}`), source, "")
+
+	// the truncated message fills the buffer on its own and the batch is still valid JSON
+	payload := <-destination.payloads
+	assert.True(t, len(payload) < defaultMaxContentSize)
+	var batch []map[string]string
+	require.NoError(t, json.Unmarshal(payload, &batch))
+	require.Len(t, batch, 1)
+	assert.Equal(t, "info", batch[0]["status"])
+	truncated := batch[0]["message"]
+	assert.True(t, strings.HasSuffix(truncated, string(decoder.TRUNCATED)))
+	assert.True(t, strings.HasPrefix(text, strings.TrimSuffix(truncated, string(decoder.TRUNCATED))))
+	assert.True(t, utf8.ValidString(truncated))
+	assert.Equal(t, int64(1), sender.Stats().TruncatedMessages)
+
+	sender.Stop()
+	assert.Equal(t, `[{"message":"b"}]`, string(<-destination.payloads))
+	assert.Len(t, output, 2)
+}
+
+func TestBatchSenderDropsOversizedMessagesItCanNotTruncate(t *testing.T) {
+	input := make(chan *message.Message, 2)
+	output := make(chan *message.Message, 2)
+	destination := newMockDestination(nil)
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{BatchTimeout: time.Hour})
+	sender.Start()
+
+	// cutting a content which is not a JSON object with a message would make the batch invalid
+	source := config.NewLogSource("", &config.LogsConfig{})
+	input <- newMessage(bytes.Repeat([]byte("a"), 2*defaultMaxContentSize), source, "")
+	input <- newMessage([]byte("b"), source, "")
+
+	sender.Stop()
+	assert.Equal(t, "[b]", string(<-destination.payloads))
+	assert.Len(t, output, 1)
+	assert.Equal(t, int64(0), sender.Stats().TruncatedMessages)
+	assert.Equal(t, int64(1), sender.Stats().DroppedMessages)
+}
+
+func TestBatchSenderCountsMessagesThatCanNotBeBuffered(t *testing.T) {
+	input := make(chan *message.Message, 1)
+	output := make(chan *message.Message, 1)
+	destination := newMockDestination(nil)
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{})
+	// a buffer that can not hold any message, adding a message fails even after a flush
+	sender.messageBuffer = NewMessageBuffer(0, defaultMaxContentSize, DropNewest, nil)
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	input <- newMessage([]byte("a"), source, "")
+
+	sender.Stop()
+	assert.Equal(t, int64(1), sender.Stats().DroppedMessages)
+	assert.Equal(t, int64(0), sender.Stats().BatchesSent)
+	assert.Len(t, output, 0)
+}
+
+// mockRateLimiter allows payloads to be sent only when tokens are available,
+// Wait blocks until a token is added.
+type mockRateLimiter struct {
+	tokens chan struct{}
+}
+
+func (l *mockRateLimiter) Allow() bool {
+	select {
+	case <-l.tokens:
+		return true
+	default:
+		return false
+	}
+}
+
+func (l *mockRateLimiter) Wait() {
+	<-l.tokens
+}
+
+func TestBatchSenderSendsHeartbeatsWhenIdle(t *testing.T) {
+	input := make(chan *message.Message, 1)
+	output := make(chan *message.Message, 1)
+	destination := newMockDestination(nil)
+
+	heartbeat := func() []byte {
+		return []byte("[]")
+	}
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{BatchTimeout: 10 * time.Millisecond, Heartbeat: heartbeat})
+	sender.Start()
+
+	// the buffer is empty
+	assert.Equal(t, "[]", string(<-destination.payloads))
+
+	// the buffer is not empty, the messages are sent instead of a heartbeat
+	source := config.NewLogSource("", &config.LogsConfig{})
+	input <- newMessage([]byte("a"), source, "")
+	for payload := range destination.payloads {
+		if string(payload) == "[a]" {
+			break
+		}
+		assert.Equal(t, "[]", string(payload))
+	}
+	<-output
+
+	sender.Stop()
+	stats := sender.Stats()
+	assert.Equal(t, int64(1), stats.BatchesSent)
+	assert.True(t, stats.HeartbeatsSent >= 1)
+}
+
+func TestBatchSenderDoesNotSendHeartbeatsByDefault(t *testing.T) {
+	input := make(chan *message.Message)
+	output := make(chan *message.Message)
+	destination := newMockDestination(nil)
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{BatchTimeout: time.Millisecond})
+	sender.Start()
+	time.Sleep(20 * time.Millisecond)
+	sender.Stop()
+
+	assert.Len(t, destination.payloads, 0)
+	assert.Equal(t, int64(0), sender.Stats().HeartbeatsSent)
+}
+
+func TestBatchSenderDropsOversizedMessages(t *testing.T) {
+	input := make(chan *message.Message, 3)
+	output := make(chan *message.Message, 3)
+	destination := newMockDestination(nil)
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{MaxBatchSize: 2, MaxMessageSize: 5})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	input <- newMessage([]byte("a"), source, "")
+	input <- newMessage([]byte("oversized"), source, "")
+	input <- newMessage([]byte("b"), source, "")
+
+	assert.Equal(t, "[a,b]", string(<-destination.payloads))
+	sender.Stop()
+
+	assert.Len(t, output, 2)
+	assert.Equal(t, int64(1), sender.Stats().OversizedDropped)
+	assert.Equal(t, int64(0), sender.Stats().TruncatedMessages)
+}
+
+func TestBatchSenderRateLimit(t *testing.T) {
+	input := make(chan *message.Message)
+	output := make(chan *message.Message, 3)
+	destination := newMockDestination(nil)
+	rateLimiter := &mockRateLimiter{tokens: make(chan struct{}, 1)}
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{MaxBatchSize: 2, BatchTimeout: 10 * time.Millisecond, RateLimiter: rateLimiter})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	input <- newMessage([]byte("a"), source, "")
+
+	// the timeout does not flush the buffer while the rate limit is reached
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(t, destination.payloads, 0)
+
+	// the buffer is full, the sender blocks until a token is available
+	input <- newMessage([]byte("b"), source, "")
+	select {
+	case input <- newMessage([]byte("c"), source, ""):
+		assert.Fail(t, "the sender should apply backpressure")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	rateLimiter.tokens <- struct{}{}
+	assert.Equal(t, "[a,b]", string(<-destination.payloads))
+	input <- newMessage([]byte("c"), source, "")
+
+	rateLimiter.tokens <- struct{}{}
+	assert.Equal(t, "[c]", string(<-destination.payloads))
+
+	sender.Stop()
+	assert.Len(t, output, 3)
+}
+
+func TestBatchSenderDropOldest(t *testing.T) {
+	input := make(chan *message.Message)
+	output := make(chan *message.Message, 3)
+	destination := newMockDestination(nil)
+	rateLimiter := &mockRateLimiter{tokens: make(chan struct{}, 1)}
+	evicted := make(chan string, 2)
+	onDrop := func(m *message.Message) {
+		evicted <- string(m.Content)
+	}
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{MaxBatchSize: 2, BatchTimeout: time.Hour, RateLimiter: rateLimiter, DropPolicy: DropOldest, OnDrop: onDrop})
+	sender.Start()
+
+	// the buffer is full and the rate limit is reached, the oldest messages are evicted instead of blocking
+	source := config.NewLogSource("", &config.LogsConfig{})
+	input <- newMessage([]byte("a"), source, "")
+	input <- newMessage([]byte("b"), source, "")
+	input <- newMessage([]byte("c"), source, "")
+	input <- newMessage([]byte("d"), source, "")
+	assert.Equal(t, "a", <-evicted)
+	assert.Equal(t, "b", <-evicted)
+	assert.Len(t, destination.payloads, 0)
+
+	rateLimiter.tokens <- struct{}{}
+	input <- newMessage([]byte("e"), source, "")
+	assert.Equal(t, "[c,d]", string(<-destination.payloads))
+
+	rateLimiter.tokens <- struct{}{}
+	sender.Stop()
+	assert.Equal(t, "[e]", string(<-destination.payloads))
+
+	assert.Len(t, output, 3)
+	assert.Equal(t, int64(2), sender.Stats().EvictedMessages)
+	assert.Equal(t, int64(0), sender.Stats().PendingMessages)
+}
+
+func TestBatchSenderWithNDJSONFormatter(t *testing.T) {
+	input := make(chan *message.Message, 1)
+	output := make(chan *message.Message, 2)
+	destination := newMockDestination(nil)
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{MaxBatchSize: 2, Formatter: NewNDJSONFormatter()})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	input <- newMessage([]byte(`{"message":"a"}`), source, "")
+	input <- newMessage([]byte(`{"message":"b"}`), source, "")
+
+	assert.Equal(t, "{\"message\":\"a\"}\n{\"message\":\"b\"}", string(<-destination.payloads))
+
+	sender.Stop()
+}
+
+func TestBatchSenderFlushObserver(t *testing.T) {
+	input := make(chan *message.Message)
+	output := make(chan *message.Message, 10)
+	destination := newMockDestination(nil)
+
+	observed := make(chan string, 10)
+	observer := func(info FlushInfo) {
+		observed <- fmt.Sprintf("%s:%d:%d", info.Reason, info.Bytes, info.Count)
+	}
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{MaxBatchSize: 2, MaxContentSize: 10, BatchTimeout: time.Hour, FlushObserver: observer})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+
+	input <- newMessage([]byte("aaaa"), source, "")
+	input <- newMessage([]byte("bbbb"), source, "")
+	assert.Equal(t, "content_size_exceeded:6:1", <-observed)
+
+	input <- newMessage([]byte("c"), source, "")
+	assert.Equal(t, "buffer_full:8:2", <-observed)
+
+	input <- newMessage([]byte("d"), source, "")
+	sender.Flush()
+	assert.Equal(t, "requested:3:1", <-observed)
+
+	input <- newMessage([]byte("e"), source, "")
+	sender.Stop()
+	assert.Equal(t, "shutdown:3:1", <-observed)
+	assert.Len(t, observed, 0)
+}
+
+func TestBatchSenderFlushBytesThreshold(t *testing.T) {
+	input := make(chan *message.Message)
+	output := make(chan *message.Message, 10)
+	destination := newMockDestination(nil)
+
+	observed := make(chan string, 10)
+	observer := func(info FlushInfo) {
+		observed <- fmt.Sprintf("%s:%d:%d", info.Reason, info.Bytes, info.Count)
+	}
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{MaxBatchSize: 10, BatchTimeout: time.Hour, FlushBytesThreshold: 8, FlushObserver: observer})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+
+	// the payload is sent as soon as it crosses the threshold, without waiting for the timeout
+	input <- newMessage([]byte("aaa"), source, "")
+	input <- newMessage([]byte("bbb"), source, "")
+	assert.Equal(t, "bytes_threshold:9:2", <-observed)
+	assert.Equal(t, "[aaa,bbb]", string(<-destination.payloads))
+
+	input <- newMessage([]byte("c"), source, "")
+	sender.Stop()
+	assert.Equal(t, "shutdown:3:1", <-observed)
+	assert.Equal(t, int64(0), sender.Stats().FullFlushes)
+}
+
+func TestBatchSenderFlushObserverOnTimeout(t *testing.T) {
+	input := make(chan *message.Message, 1)
+	output := make(chan *message.Message, 1)
+	destination := newMockDestination(nil)
+
+	observed := make(chan FlushReason, 1)
+	observer := func(info FlushInfo) {
+		observed <- info.Reason
+	}
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{BatchTimeout: 10 * time.Millisecond, FlushObserver: observer})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	input <- newMessage([]byte("fake line"), source, "")
+
+	assert.Equal(t, FlushReasonTimeout, <-observed)
+
+	sender.Stop()
+}
+
+// funcClock is a real clock whose current time is returned by now.
+type funcClock struct {
+	realClock
+	now func() time.Time
+}
+
+func (c *funcClock) Now() time.Time {
+	return c.now()
+}
+
+func TestBatchSenderFlushesOnTimeoutWithFakeClock(t *testing.T) {
+	input := make(chan *message.Message, 1)
+	output := make(chan *message.Message, 1)
+	destination := newMockDestination(nil)
+
+	clock := newFakeClock()
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{BatchTimeout: time.Minute})
+	sender.clock = clock
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	input <- newMessage([]byte("a"), source, "")
+	waitForRead(input)
+	assert.Equal(t, 1, clock.timerCount())
+
+	clock.Advance(59 * time.Second)
+	assert.Len(t, destination.payloads, 0)
+
+	clock.Advance(time.Second)
+	assert.Equal(t, "[a]", string(<-destination.payloads))
+	<-output
+
+	sender.Stop()
+	assert.Equal(t, int64(1), sender.Stats().TimeoutFlushes)
+}
+
+func TestBatchSenderRetriesWithFakeClock(t *testing.T) {
+	input := make(chan *message.Message, 1)
+	output := make(chan *message.Message, 1)
+	destination := newMockDestination(nil, client.NewRetryableError(errors.New("server error")))
+
+	clock := newFakeClock()
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{
+		MaxBatchSize:            1,
+		BackoffBase:             time.Hour,
+		CircuitBreakerThreshold: 1,
+		CircuitBreakerCooldown:  2 * time.Hour,
+	})
+	sender.clock = clock
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	input <- newMessage([]byte("a"), source, "")
+
+	// the flush timer then the backoff delay
+	for clock.timerCount() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(time.Hour)
+	// the circuit opened on the failure stays open until the end of its cooldown
+	for clock.timerCount() < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Len(t, destination.payloads, 0)
+	clock.Advance(time.Hour)
+
+	assert.Equal(t, "[a]", string(<-destination.payloads))
+	<-output
+	assert.Equal(t, 2, destination.getAttempts())
+
+	sender.Stop()
+}
+
+func TestBatchSenderFlushObserverReportsMessageAges(t *testing.T) {
+	input := make(chan *message.Message)
+	output := make(chan *message.Message, 2)
+	destination := newMockDestination(nil)
+
+	observed := make(chan FlushInfo, 1)
+	observer := func(info FlushInfo) {
+		observed <- info
+	}
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{MaxBatchSize: 3, BatchTimeout: time.Hour, FlushObserver: observer})
+
+	// the clock returns the time each message is received, the time of the flush,
+	// then the times the send starts, the first attempt starts and the send ends
+	start := time.Now()
+	clock := make(chan time.Time, 6)
+	clock <- start
+	clock <- start.Add(time.Second)
+	clock <- start.Add(3 * time.Second)
+	clock <- start.Add(3 * time.Second)
+	clock <- start.Add(3 * time.Second)
+	clock <- start.Add(5 * time.Second)
+	sender.clock = &funcClock{now: func() time.Time {
+		return <-clock
+	}}
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	input <- newMessage([]byte("a"), source, "")
+	input <- newMessage([]byte("b"), source, "")
+	sender.Flush()
+
+	info := <-observed
+	assert.Equal(t, 2, info.Count)
+	assert.Equal(t, 3*time.Second, info.MaxAge)
+	assert.Equal(t, 2500*time.Millisecond, info.MeanAge)
+	assert.Equal(t, 2*time.Second, info.SendDuration)
+
+	sender.Stop()
+}
+
+// alternateSampler keeps every other message, starting with the first one.
+type alternateSampler struct {
+	count int
+}
+
+func (s *alternateSampler) Sample() bool {
+	s.count++
+	return s.count%2 == 1
+}
+
+func TestBatchSenderSamplesMessages(t *testing.T) {
+	input := make(chan *message.Message, 4)
+	output := make(chan *message.Message, 4)
+	destination := newMockDestination(nil)
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{Sampler: &alternateSampler{}})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	for _, content := range []string{"a", "b", "c", "d"} {
+		input <- newMessage([]byte(content), source, "")
+	}
+
+	sender.Stop()
+	assert.Equal(t, "[a,c]", string(<-destination.payloads))
+	assert.Len(t, output, 2)
+	assert.Equal(t, int64(2), sender.Stats().SampledOutMessages)
+}
+
+func TestBatchSenderSamplerDropsAll(t *testing.T) {
+	input := make(chan *message.Message, 2)
+	output := make(chan *message.Message, 2)
+	destination := newMockDestination(nil)
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{Sampler: NewRandomSampler(0, 42)})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	input <- newMessage([]byte("a"), source, "")
+	input <- newMessage([]byte("b"), source, "")
+
+	sender.Stop()
+	assert.Len(t, destination.payloads, 0)
+	assert.Len(t, output, 0)
+	assert.Equal(t, int64(2), sender.Stats().SampledOutMessages)
+}
+
+func TestBatchSenderFlushTimeoutJitter(t *testing.T) {
+	sender := NewBatchSender(nil, nil, nil, BatchConfig{BatchTimeout: 10 * time.Second, BatchTimeoutJitter: 0.2})
+
+	sender.random = func() float64 { return 0 }
+	assert.Equal(t, 8*time.Second, sender.flushTimeout())
+	sender.random = func() float64 { return 0.5 }
+	assert.Equal(t, 10*time.Second, sender.flushTimeout())
+	sender.random = func() float64 { return 0.75 }
+	assert.Equal(t, 11*time.Second, sender.flushTimeout())
+}
+
+func TestBatchSenderFlushTimeoutWithoutJitter(t *testing.T) {
+	sender := NewBatchSender(nil, nil, nil, BatchConfig{BatchTimeout: 10 * time.Second})
+	sender.random = func() float64 {
+		assert.Fail(t, "random should not be called without jitter")
+		return 0
+	}
+	assert.Equal(t, 10*time.Second, sender.flushTimeout())
+
+	for _, jitter := range []float64{-0.1, 1, 2} {
+		assert.Equal(t, float64(0), BatchConfig{BatchTimeoutJitter: jitter}.withDefaults().BatchTimeoutJitter)
+	}
+}
+
+func TestBatchSenderAppliesJitterToEveryTimeout(t *testing.T) {
+	input := make(chan *message.Message, 1)
+	output := make(chan *message.Message, 1)
+	destination := newMockDestination(nil)
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{BatchTimeout: 10 * time.Millisecond, BatchTimeoutJitter: 0.5})
+	// the timeouts are drawn when the timer is created, then every time it is reset
+	draws := make(chan struct{}, 100)
+	sender.random = func() float64 {
+		draws <- struct{}{}
+		return 0.5
+	}
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	input <- newMessage([]byte("fake line"), source, "")
+	<-output
+
+	sender.Stop()
+	assert.True(t, len(draws) >= 2)
+}
+
+func TestBatchSenderCircuitBreaker(t *testing.T) {
+	input := make(chan *message.Message, 1)
+	output := make(chan *message.Message, 1)
+	retryableErr := client.NewRetryableError(errors.New("server error"))
+	destination := newMockDestination(nil, retryableErr, retryableErr)
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{
+		MaxBatchSize:            1,
+		BackoffBase:             time.Millisecond,
+		CircuitBreakerThreshold: 2,
+		CircuitBreakerCooldown:  50 * time.Millisecond,
+	})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	input <- newMessage([]byte("fake line"), source, "")
+
+	// the circuit opens after the second failure, then the third attempt probes the destination
+	start := time.Now()
+	assert.Equal(t, "[fake line]", string(<-destination.payloads))
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+	<-output
+	assert.Equal(t, 3, destination.getAttempts())
+	assert.Equal(t, CircuitClosed, sender.Stats().CircuitState)
+
+	sender.Stop()
+}
+
+// signedDestination records the payloads it receives along with their signatures.
+type signedDestination struct {
+	*mockDestination
+	signatures chan []byte
+}
+
+func (d *signedDestination) SendSigned(payload []byte, signature []byte) error {
+	d.signatures <- signature
+	return d.Send(payload)
+}
+
+func TestBatchSenderSignsPayloads(t *testing.T) {
+	input := make(chan *message.Message, 1)
+	output := make(chan *message.Message, 1)
+	destination := &signedDestination{
+		mockDestination: newMockDestination(nil),
+		signatures:      make(chan []byte, 1),
+	}
+	key := []byte("secret")
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{MaxBatchSize: 1, SigningKey: key, Compressor: NewGzipCompressor(gzip.DefaultCompression)})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	input <- newMessage(bytes.Repeat([]byte("a"), 500), source, "")
+
+	// the signature covers the compressed payload as sent
+	payload := <-destination.payloads
+	signature := <-destination.signatures
+	assert.Equal(t, fmt.Sprintf("[%s]", bytes.Repeat([]byte("a"), 500)), string(gunzip(t, payload)))
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+	assert.True(t, hmac.Equal(mac.Sum(nil), signature))
+
+	mac = hmac.New(sha256.New, []byte("other"))
+	mac.Write(payload)
+	assert.False(t, hmac.Equal(mac.Sum(nil), signature))
+
+	sender.Stop()
+}
+
+func TestBatchSenderEncryptsCompressedPayloadsBeforeSigning(t *testing.T) {
+	input := make(chan *message.Message, 1)
+	output := make(chan *message.Message, 1)
+	destination := &signedDestination{
+		mockDestination: newMockDestination(nil),
+		signatures:      make(chan []byte, 1),
+	}
+	key := []byte("secret")
+	encryptor, err := NewEncryptor(encryptionKey, nil)
+	require.NoError(t, err)
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{
+		MaxBatchSize: 1,
+		SigningKey:   key,
+		Compressor:   NewGzipCompressor(gzip.DefaultCompression),
+		Encryptor:    encryptor,
+	})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	content := bytes.Repeat([]byte("a"), 500)
+	input <- newMessage(content, source, "")
+
+	// the signature covers the encrypted payload as sent
+	payload := <-destination.payloads
+	assert.Equal(t, sign(key, payload), <-destination.signatures)
+	decrypted, err := encryptor.Decrypt(payload)
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("[%s]", content), string(gunzip(t, decrypted)))
+	<-output
+
+	sender.Stop()
+}
+
+func TestBatchSenderSendsTheEncryptionApartFromTheContentEncoding(t *testing.T) {
+	input := make(chan *message.Message, 1)
+	output := make(chan *message.Message, 1)
+	envelopes := make(chan client.Envelope, 1)
+	encryptor, err := NewEncryptor(encryptionKey, nil)
+	require.NoError(t, err)
+
+	sender := NewBatchSender(input, output, client.NewDestinations(newMockDestination(nil), nil), BatchConfig{
+		MaxBatchSize: 1,
+		Compressor:   NewGzipCompressor(gzip.DefaultCompression),
+		Encryptor:    encryptor,
+		Transport: transportFunc(func(ctx context.Context, envelope client.Envelope) error {
+			envelopes <- envelope
+			return nil
+		}),
+	})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	input <- newMessage(bytes.Repeat([]byte("a"), 500), source, "")
+	envelope := <-envelopes
+	<-output
+	sender.Stop()
+
+	assert.Equal(t, "aes-gcm", envelope.Encryption)
+	assert.Equal(t, "gzip", envelope.ContentEncoding)
+}
+
+func TestBatchSenderDropsPayloadsThatCanNotBeEncrypted(t *testing.T) {
+	input := make(chan *message.Message, 1)
+	output := make(chan *message.Message, 1)
+	errs := make(chan sendError, 1)
+	destination := newMockDestination(nil)
+	// the nonces can not be read
+	encryptor, err := NewEncryptor(encryptionKey, bytes.NewReader(nil))
+	require.NoError(t, err)
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{
+		MaxBatchSize: 1,
+		Encryptor:    encryptor,
+		ErrorHandler: func(err error, failure SendFailure) {
+			errs <- sendError{err, failure}
+		},
+	})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	input <- newMessage([]byte("a"), source, "")
+	failure := <-errs
+	sender.Stop()
+
+	assert.Error(t, failure.err)
+	assert.Equal(t, 1, failure.failure.MessageCount)
+	assert.Equal(t, 0, destination.getAttempts())
+	assert.Len(t, output, 0)
+	assert.Equal(t, int64(1), sender.Stats().DroppedMessages)
+}
+
+func TestBatchSenderDoesNotSignWithoutSignedDestination(t *testing.T) {
+	input := make(chan *message.Message, 1)
+	output := make(chan *message.Message, 1)
+	destination := newMockDestination(nil)
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{MaxBatchSize: 1, SigningKey: []byte("secret")})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	input <- newMessage([]byte("fake line"), source, "")
+
+	assert.Equal(t, "[fake line]", string(<-destination.payloads))
+	<-output
+
+	sender.Stop()
+}
+
+func TestBatchSenderOutputBuffer(t *testing.T) {
+	input := make(chan *message.Message, 4)
+	output := make(chan *message.Message)
+	destination := newMockDestination(nil)
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{MaxBatchSize: 1, OutputBufferSize: 2})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	var messages []*message.Message
+	for i := 0; i < 4; i++ {
+		m := newMessage([]byte(fmt.Sprintf("%d", i)), source, "")
+		messages = append(messages, m)
+		input <- m
+	}
+
+	// the sends are not blocked by outputChan
+	for i := 0; i < 4; i++ {
+		<-destination.payloads
+	}
+	sender.Flush()
+	assert.Equal(t, int64(4), sender.Stats().BatchesSent)
+
+	// one message is held by the forwarder and two by the buffer,
+	// the others were dropped to make room for the most recent ones
+	dropped := sender.Stats().OutputDroppedMessages
+	assert.True(t, dropped >= 1)
+
+	stopped := make(chan struct{})
+	go func() {
+		sender.Stop()
+		close(stopped)
+	}()
+
+	var received []*message.Message
+	for m := range output {
+		received = append(received, m)
+		if int64(len(received))+dropped == 4 {
+			break
+		}
+	}
+	<-stopped
+	assert.Equal(t, messages[3], received[len(received)-1])
+}
+
+// envelopeDestination records the sequence numbers of the envelopes it receives.
+type envelopeDestination struct {
+	*mockDestination
+	sequences chan uint64
+}
+
+func (d *envelopeDestination) SendEnvelope(envelope client.Envelope) error {
+	d.sequences <- envelope.Sequence
+	return d.Send(envelope.Payload)
+}
+
+func TestBatchSenderRetriesKeepTheSequenceNumber(t *testing.T) {
+	input := make(chan *message.Message, 1)
+	output := make(chan *message.Message, 2)
+	retryableErr := client.NewRetryableError(errors.New("server error"))
+	destination := &envelopeDestination{
+		mockDestination: newMockDestination(nil, retryableErr, retryableErr),
+		sequences:       make(chan uint64, 10),
+	}
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{MaxBatchSize: 1, BackoffBase: time.Millisecond})
+	assert.Equal(t, uint64(0), sender.Stats().Sequence)
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	input <- newMessage([]byte("a"), source, "")
+	assert.Equal(t, "[a]", string(<-destination.payloads))
+	<-output
+	input <- newMessage([]byte("b"), source, "")
+	assert.Equal(t, "[b]", string(<-destination.payloads))
+	<-output
+
+	sender.Stop()
+
+	// the first payload was sent three times
+	assert.Equal(t, uint64(1), <-destination.sequences)
+	assert.Equal(t, uint64(1), <-destination.sequences)
+	assert.Equal(t, uint64(1), <-destination.sequences)
+	assert.Equal(t, uint64(2), <-destination.sequences)
+	assert.Len(t, destination.sequences, 0)
+	assert.Equal(t, uint64(2), sender.Stats().Sequence)
+}
+
+func TestBatchSenderDeadLettersTheSequenceNumber(t *testing.T) {
+	input := make(chan *message.Message, 1)
+	output := make(chan *message.Message, 1)
+	deadLetters := make(chan *FailedPayload, 1)
+	destination := newMockDestination(errors.New("client error"))
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{MaxBatchSize: 1, DeadLetterChan: deadLetters})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	input <- newMessage([]byte("a"), source, "")
+	assert.Equal(t, uint64(1), (<-deadLetters).Sequence)
+
+	sender.Stop()
+}
+
+// transportFunc records the envelopes sent through a transport.
+type transportFunc func(ctx context.Context, envelope client.Envelope) error
+
+func (f transportFunc) Send(ctx context.Context, envelope client.Envelope) error {
+	return f(ctx, envelope)
+}
+
+func TestBatchSenderSendsThroughTheTransport(t *testing.T) {
+	input := make(chan *message.Message, 1)
+	output := make(chan *message.Message, 1)
+	envelopes := make(chan client.Envelope, 1)
+	destination := newMockDestination(nil)
+	key := []byte("secret")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{
+		MaxBatchSize: 1,
+		SigningKey:   key,
+		Compressor:   NewGzipCompressor(gzip.DefaultCompression),
+		Transport: transportFunc(func(sendCtx context.Context, envelope client.Envelope) error {
+			assert.Equal(t, ctx, sendCtx)
+			envelopes <- envelope
+			return nil
+		}),
+	})
+	sender.StartWithContext(ctx)
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	input <- newMessage(bytes.Repeat([]byte("a"), 100), source, "")
+	envelope := <-envelopes
+	<-output
+	sender.Stop()
+
+	assert.Equal(t, uint64(1), envelope.Sequence)
+	assert.Equal(t, "gzip", envelope.ContentEncoding)
+	assert.Equal(t, sign(key, envelope.Payload), envelope.Signature)
+	assert.Equal(t, checksum(envelope.Payload), envelope.Checksum)
+	assert.Len(t, destination.payloads, 0)
+}
+
+func TestBatchSenderSendsThroughATransportWithoutDestinations(t *testing.T) {
+	input := make(chan *message.Message, 1)
+	output := make(chan *message.Message, 1)
+	envelopes := make(chan client.Envelope, 1)
+
+	sender := NewBatchSender(input, output, nil, BatchConfig{
+		MaxBatchSize: 1,
+		Transport: transportFunc(func(ctx context.Context, envelope client.Envelope) error {
+			envelopes <- envelope
+			return nil
+		}),
+	})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	m := newMessage([]byte("a"), source, "")
+	input <- m
+	assert.Equal(t, "[a]", string((<-envelopes).Payload))
+	assert.Equal(t, m, <-output)
+	sender.Stop()
+}
+
+func TestBatchSenderSendsPayloadsThatDoNotShrinkWithoutContentEncoding(t *testing.T) {
+	input := make(chan *message.Message, 1)
+	output := make(chan *message.Message, 1)
+	envelopes := make(chan client.Envelope, 1)
+
+	sender := NewBatchSender(input, output, client.NewDestinations(newMockDestination(nil), nil), BatchConfig{
+		MaxBatchSize: 1,
+		Compressor:   NewGzipCompressor(gzip.DefaultCompression),
+		Transport: transportFunc(func(ctx context.Context, envelope client.Envelope) error {
+			envelopes <- envelope
+			return nil
+		}),
+	})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	input <- newMessage([]byte("a"), source, "")
+	envelope := <-envelopes
+	<-output
+	sender.Stop()
+
+	// the payload is sent as is, it must not be labelled as compressed
+	assert.Equal(t, []byte("[a]"), envelope.Payload)
+	assert.Equal(t, "", envelope.ContentEncoding)
+}
+
+func TestBatchSenderSendsThroughASendFunc(t *testing.T) {
+	input := make(chan *message.Message, 1)
+	output := make(chan *message.Message, 1)
+	payloads := make(chan []byte, 1)
+	destination := newMockDestination(nil)
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{
+		MaxBatchSize: 1,
+		Transport: client.SendFunc(func(payload []byte) error {
+			payloads <- payload
+			return nil
+		}),
+	})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	input <- newMessage([]byte("a"), source, "")
+	assert.Equal(t, "[a]", string(<-payloads))
+	<-output
+	sender.Stop()
+
+	assert.Len(t, destination.payloads, 0)
+}
+
+func TestBatchSenderReleasesFlushedMessages(t *testing.T) {
+	input := make(chan *message.Message, 1)
+	output := make(chan *message.Message, 2)
+	destination := newMockDestination(nil)
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{MaxBatchSize: 2})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	input <- newMessage([]byte("a"), source, "")
+	input <- newMessage([]byte("b"), source, "")
+	assert.Equal(t, "[a,b]", string(<-destination.payloads))
+	<-output
+	<-output
+
+	sender.Stop()
+
+	// the buffer does not reference the sent messages anymore
+	messages := sender.messageBuffer.GetMessages()
+	assert.Equal(t, []*message.Message{nil, nil}, messages[:cap(messages)])
+}
+
+// sendError describes a call to the error handler.
+type sendError struct {
+	err     error
+	failure SendFailure
+}
+
+func TestBatchSenderReportsSendErrors(t *testing.T) {
+	input := make(chan *message.Message, 2)
+	output := make(chan *message.Message, 2)
+	errs := make(chan sendError, 1)
+	clientErr := errors.New("client error")
+	destination := newMockDestination(clientErr)
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{
+		MaxBatchSize: 2,
+		ErrorHandler: func(err error, failure SendFailure) {
+			errs <- sendError{err, failure}
+		},
+	})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	input <- newMessage([]byte("a"), source, "")
+	input <- newMessage([]byte("b"), source, "")
+	assert.Equal(t, sendError{clientErr, SendFailure{PayloadSize: len("[a,b]"), MessageCount: 2, FlushReason: "buffer_full", Sequence: 1, Attempts: 1}}, <-errs)
+
+	sender.Stop()
+	assert.Len(t, output, 0)
+}
+
+func TestBatchSenderDoesNotReportCancellation(t *testing.T) {
+	input := make(chan *message.Message, 1)
+	output := make(chan *message.Message, 1)
+	errs := make(chan sendError, 1)
+	destination := newMockDestination(context.Canceled)
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{
+		MaxBatchSize: 1,
+		ErrorHandler: func(err error, failure SendFailure) {
+			errs <- sendError{err, failure}
+		},
+	})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	input <- newMessage([]byte("a"), source, "")
+	sender.Stop()
+
+	assert.Equal(t, 1, destination.getAttempts())
+	assert.Len(t, errs, 0)
+}
+
+// warning is a message logged along with its context.
+type warning struct {
+	message string
+	context []interface{}
+}
+
+// captureWarnings replaces the logger of the send failures until the returned function is called.
+func captureWarnings(warnings chan warning) func() {
+	warnc = func(message string, context ...interface{}) error {
+		warnings <- warning{message, context}
+		return nil
+	}
+	return func() { warnc = log.Warnc }
+}
+
+func TestBatchSenderLogsSendErrorsWithTheirContext(t *testing.T) {
+	warnings := make(chan warning, 1)
+	defer captureWarnings(warnings)()
+
+	input := make(chan *message.Message, 1)
+	output := make(chan *message.Message, 1)
+	destination := newMockDestination(client.NewRetryableError(errors.New("server error")))
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{MaxBatchSize: 1, MaxSendAttempts: 3, BackoffBase: time.Millisecond})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	input <- newMessage([]byte("a"), source, "")
+	assert.Equal(t, warning{"Could not send payload after 3 attempts", []interface{}{
+		"error", client.NewRetryableError(errors.New("server error")),
+		"payload_size", 3,
+		"message_count", 1,
+		"flush_reason", "buffer_full",
+		"sequence", uint64(1),
+		"attempts", 3,
+	}}, <-warnings)
+
+	sender.Stop()
+}
+
+func TestSendFailureContextSkipsTheUnknownFields(t *testing.T) {
+	err := errors.New("error")
+	assert.Equal(t, []interface{}{"error", err, "payload_size", 10, "message_count", 1}, SendFailure{PayloadSize: 10, MessageCount: 1}.context(err))
+	assert.Equal(t, []interface{}{"error", err, "payload_size", 10, "message_count", 2, "flush_reason", "timeout", "sequence", uint64(4), "attempts", 1}, SendFailure{
+		PayloadSize:  10,
+		MessageCount: 2,
+		FlushReason:  FlushReasonTimeout.String(),
+		Sequence:     4,
+		Attempts:     1,
+	}.context(err))
+}
+
+// poisonDestination rejects the payloads containing poison.
+type poisonDestination struct {
+	*mockDestination
+	rejected chan []byte
+}
+
+func (d *poisonDestination) Send(payload []byte) error {
+	if bytes.Contains(payload, []byte("poison")) {
+		d.rejected <- append([]byte(nil), payload...)
+		return errors.New("client error")
+	}
+	return d.mockDestination.Send(payload)
+}
+
+func TestBatchSenderBisectsRejectedBatches(t *testing.T) {
+	input := make(chan *message.Message, 4)
+	output := make(chan *message.Message, 4)
+	deadLetters := make(chan *FailedPayload, 1)
+	destination := &poisonDestination{
+		mockDestination: newMockDestination(nil),
+		rejected:        make(chan []byte, 10),
+	}
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{MaxBatchSize: 4, BisectDepth: 2, DeadLetterChan: deadLetters})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	a := newMessage([]byte("a"), source, "")
+	b := newMessage([]byte("b"), source, "")
+	poison := newMessage([]byte("poison"), source, "")
+	c := newMessage([]byte("c"), source, "")
+	input <- a
+	input <- b
+	input <- poison
+	input <- c
+
+	assert.Equal(t, "[a,b]", string(<-destination.payloads))
+	assert.Equal(t, "[c]", string(<-destination.payloads))
+	failed := <-deadLetters
+	assert.Equal(t, "[poison]", string(failed.Payload))
+	assert.Equal(t, []*message.Message{poison}, failed.Messages)
+
+	sender.Stop()
+
+	assert.Equal(t, "[a,b,poison,c]", string(<-destination.rejected))
+	assert.Equal(t, "[poison,c]", string(<-destination.rejected))
+	assert.Equal(t, "[poison]", string(<-destination.rejected))
+	assert.Equal(t, []*message.Message{a, b, c}, []*message.Message{<-output, <-output, <-output})
+	assert.Len(t, output, 0)
+
+	stats := sender.Stats()
+	assert.Equal(t, int64(2), stats.Bisections)
+	assert.Equal(t, int64(2), stats.BatchesSent)
+	assert.Equal(t, uint64(5), stats.Sequence)
+}
+
+func TestBatchSenderDoesNotBisectByDefault(t *testing.T) {
+	input := make(chan *message.Message, 2)
+	output := make(chan *message.Message, 2)
+	deadLetters := make(chan *FailedPayload, 1)
+	destination := &poisonDestination{
+		mockDestination: newMockDestination(nil),
+		rejected:        make(chan []byte, 10),
+	}
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{MaxBatchSize: 2, DeadLetterChan: deadLetters})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	input <- newMessage([]byte("a"), source, "")
+	input <- newMessage([]byte("poison"), source, "")
+	assert.Equal(t, "[a,poison]", string((<-deadLetters).Payload))
+
+	sender.Stop()
+	assert.Len(t, destination.rejected, 1)
+	assert.Equal(t, int64(0), sender.Stats().Bisections)
+}
+
+func TestBatchSenderRecoversMessagesFromTheWAL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logs-wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "wal")
+
+	input := make(chan *message.Message, 2)
+	output := make(chan *message.Message, 2)
+	destination := newMockDestination(context.Canceled)
+
+	// the agent stops before the payload could be sent
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{MaxBatchSize: 10, BatchTimeout: time.Hour, WALPath: path})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	a := newMessage([]byte("a"), source, message.StatusError)
+	a.Origin.Identifier = "file:/var/log/a.log"
+	a.Origin.Offset = "42"
+	input <- a
+	input <- newMessage([]byte("b"), source, "")
+	waitForRead(input)
+	sender.Stop()
+	assert.Len(t, output, 0)
+
+	// the agent restarts
+	destination = newMockDestination(nil)
+	sender = NewBatchSender(make(chan *message.Message), output, client.NewDestinations(destination, nil), BatchConfig{MaxBatchSize: 10, BatchTimeout: time.Hour, WALPath: path})
+	sender.Start()
+	sender.Stop()
+
+	assert.Equal(t, "[a,b]", string(<-destination.payloads))
+	recovered := <-output
+	assert.Equal(t, "a", string(recovered.Content))
+	assert.Equal(t, message.StatusError, recovered.GetStatus())
+	assert.Equal(t, "file:/var/log/a.log", recovered.Origin.Identifier)
+	assert.Equal(t, "42", recovered.Origin.Offset)
+	assert.Equal(t, "b", string((<-output).Content))
+
+	// the messages were sent, there is nothing left to recover
+	sender = NewBatchSender(make(chan *message.Message), output, client.NewDestinations(destination, nil), BatchConfig{MaxBatchSize: 10, BatchTimeout: time.Hour, WALPath: path})
+	sender.Start()
+	sender.Stop()
+	assert.Len(t, destination.payloads, 0)
+}
+
+func TestBatchSenderSendsSequentiallyWithAWAL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logs-wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	sender := NewBatchSender(nil, nil, client.NewDestinations(newMockDestination(nil), nil), BatchConfig{MaxConcurrentSends: 4, WALPath: filepath.Join(dir, "wal")})
+	assert.Equal(t, 0, sender.senders)
+}
+
+// waitForPendingDropped waits until the sender dropped n messages because of the pending messages limit.
+func waitForPendingDropped(sender *BatchSender, n int64) {
+	for sender.Stats().PendingDropped < n {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBatchSenderBlocksWhenPendingMessagesLimitIsReached(t *testing.T) {
+	input := make(chan *message.Message, 4)
+	output := make(chan *message.Message, 4)
+	destination := &hangingDestination{release: make(chan struct{})}
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{MaxBatchSize: 1, MaxConcurrentSends: 4, MaxPendingMessages: 2})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	for _, content := range []string{"a", "b", "c", "d"} {
+		input <- newMessage([]byte(content), source, "")
+	}
+
+	// a and b are being sent, c waits for one of them to be sent and d is not read
+	for sender.Stats().PendingMessages < 2 || len(input) > 1 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	assert.Len(t, input, 1)
+	assert.Equal(t, int64(2), sender.Stats().PendingMessages)
+
+	close(destination.release)
+	sender.Stop()
+	assert.Len(t, output, 4)
+	stats := sender.Stats()
+	assert.Equal(t, int64(0), stats.PendingDropped)
+	assert.Equal(t, int64(0), stats.PendingMessages)
+}
+
+func TestBatchSenderDropsWhenPendingMessagesLimitIsReached(t *testing.T) {
+	input := make(chan *message.Message, 4)
+	output := make(chan *message.Message, 4)
+	destination := &hangingDestination{release: make(chan struct{})}
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{
+		MaxBatchSize:       1,
+		MaxConcurrentSends: 4,
+		MaxPendingMessages: 2,
+		PendingPolicy:      PendingDropNewest,
+	})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	for _, content := range []string{"a", "b", "c", "d"} {
+		input <- newMessage([]byte(content), source, "")
+	}
+
+	waitForPendingDropped(sender, 2)
+	assert.Equal(t, int64(2), sender.Stats().PendingMessages)
+
+	close(destination.release)
+	sender.Stop()
+	// the sends are concurrent, the order is not preserved
+	assert.ElementsMatch(t, []string{"a", "b"}, []string{string((<-output).Content), string((<-output).Content)})
+	assert.Len(t, output, 0)
+	stats := sender.Stats()
+	assert.Equal(t, int64(2), stats.PendingDropped)
+	assert.Equal(t, int64(0), stats.PendingMessages)
+}
+
+func TestBatchSenderSendsTheBufferWhenPendingMessagesLimitIsReached(t *testing.T) {
+	input := make(chan *message.Message, 3)
+	output := make(chan *message.Message, 3)
+	destination := newMockDestination(nil)
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{MaxBatchSize: 10, BatchTimeout: time.Hour, MaxPendingMessages: 2})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	for _, content := range []string{"a", "b", "c"} {
+		input <- newMessage([]byte(content), source, "")
+	}
+	assert.Equal(t, "[a,b]", string(<-destination.payloads))
+
+	sender.Stop()
+	assert.Equal(t, "[c]", string(<-destination.payloads))
+	assert.Len(t, output, 3)
+	assert.Equal(t, int64(0), sender.Stats().PendingMessages)
+}
+
+func TestBatchSenderFlushObserverReportsCompression(t *testing.T) {
+	input := make(chan *message.Message, 1)
+	output := make(chan *message.Message, 1)
+	destination := newMockDestination(nil)
+
+	observed := make(chan FlushInfo, 1)
+	observer := func(info FlushInfo) {
+		observed <- info
+	}
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{MaxBatchSize: 1, Compressor: NewGzipCompressor(gzip.BestCompression), FlushObserver: observer})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	input <- newMessage(bytes.Repeat([]byte("a"), 1000), source, "")
+
+	payload := <-destination.payloads
+	info := <-observed
+	assert.Equal(t, 1002, info.Bytes)
+	assert.Equal(t, len(payload), info.CompressedBytes)
+	assert.True(t, info.CompressionRatio() > 1)
+	assert.True(t, info.CompressDuration > 0)
+
+	sender.Stop()
+}
+
+func TestBatchSenderFlushObserverWithoutCompression(t *testing.T) {
+	input := make(chan *message.Message, 1)
+	output := make(chan *message.Message, 1)
+	destination := newMockDestination(nil)
+
+	observed := make(chan FlushInfo, 1)
+	observer := func(info FlushInfo) {
+		observed <- info
+	}
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{MaxBatchSize: 1, FlushObserver: observer})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	input <- newMessage([]byte("fake line"), source, "")
+
+	info := <-observed
+	assert.Equal(t, 11, info.Bytes)
+	assert.Equal(t, 11, info.CompressedBytes)
+	assert.Equal(t, 1.0, info.CompressionRatio())
+	assert.Equal(t, time.Duration(0), info.CompressDuration)
+
+	sender.Stop()
+}
+
+func TestBatchSenderCountsFlushesByTrigger(t *testing.T) {
+	source := config.NewLogSource("", &config.LogsConfig{})
+	flushes := func(stats BatchStats) []int64 {
+		return []int64{
+			stats.BatchSizeFlushes, stats.ContentSizeFlushes, stats.TimeoutFlushes, stats.ShutdownFlushes,
+			stats.RequestedFlushes, stats.BytesThresholdFlushes, stats.PendingLimitFlushes,
+		}
+	}
+
+	for _, tc := range []struct {
+		name     string
+		config   BatchConfig
+		contents []string
+		timeout  bool
+		flush    bool
+		expected []int64
+	}{
+		{
+			name:     "batch size",
+			config:   BatchConfig{MaxBatchSize: 2},
+			contents: []string{"a", "b"},
+			expected: []int64{1, 0, 0, 0, 0, 0, 0},
+		},
+		{
+			name:     "content size",
+			config:   BatchConfig{MaxContentSize: 10},
+			contents: []string{"aaaa", "bbbb"},
+			expected: []int64{0, 1, 0, 1, 0, 0, 0},
+		},
+		{
+			name:     "timeout",
+			config:   BatchConfig{},
+			contents: []string{"a"},
+			timeout:  true,
+			expected: []int64{0, 0, 1, 0, 0, 0, 0},
+		},
+		{
+			name:     "shutdown",
+			config:   BatchConfig{},
+			contents: []string{"a"},
+			expected: []int64{0, 0, 0, 1, 0, 0, 0},
+		},
+		{
+			name:     "requested",
+			config:   BatchConfig{},
+			contents: []string{"a"},
+			flush:    true,
+			expected: []int64{0, 0, 0, 0, 1, 0, 0},
+		},
+		{
+			name:     "bytes threshold",
+			config:   BatchConfig{FlushBytesThreshold: 1},
+			contents: []string{"a"},
+			expected: []int64{0, 0, 0, 0, 0, 1, 0},
+		},
+		{
+			name:     "pending limit",
+			config:   BatchConfig{MaxPendingMessages: 1},
+			contents: []string{"a", "b"},
+			expected: []int64{0, 0, 0, 1, 0, 0, 1},
+		},
+	} {
+		input := make(chan *message.Message, len(tc.contents))
+		output := make(chan *message.Message, len(tc.contents))
+		destination := newMockDestination(nil)
+
+		tc.config.BatchTimeout = time.Minute
+		sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), tc.config)
+		clock := newFakeClock()
+		sender.clock = clock
+		sender.Start()
+
+		for _, content := range tc.contents {
+			input <- newMessage([]byte(content), source, "")
+		}
+		waitForRead(input)
+		if tc.timeout {
+			clock.Advance(time.Minute)
+			<-destination.payloads
+		}
+		if tc.flush {
+			sender.Flush()
+		}
+		sender.Stop()
+
+		stats := sender.Stats()
+		assert.Equal(t, tc.expected, flushes(stats), tc.name)
+		assert.Equal(t, tc.expected[0]+tc.expected[1], stats.FullFlushes, tc.name)
+	}
+}
+
+func TestBatchSenderBufferOccupancy(t *testing.T) {
+	input := make(chan *message.Message, 2)
+	output := make(chan *message.Message, 2)
+	destination := newMockDestination(nil)
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{BatchTimeout: time.Hour})
+	sender.Start()
+	assert.Equal(t, 0, sender.BufferedCount())
+	assert.Equal(t, 0, sender.BufferedBytes())
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	input <- newMessage([]byte("a"), source, "")
+	input <- newMessage([]byte("b"), source, "")
+	for sender.BufferedCount() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, len("[a,b]"), sender.BufferedBytes())
+	assert.Equal(t, int64(2), sender.Stats().BufferedMessages)
+
+	sender.Flush()
+	assert.Equal(t, 0, sender.BufferedCount())
+	assert.Equal(t, 0, sender.BufferedBytes())
+
+	sender.Stop()
+}
+
+func TestBatchSenderBufferOccupancyWhileMessagesFlow(t *testing.T) {
+	input := make(chan *message.Message)
+	output := make(chan *message.Message, 100)
+	destination := newMockDestination(nil)
+	destination.payloads = make(chan []byte, 100)
+
+	sender := NewBatchSender(input, output, client.NewDestinations(destination, nil), BatchConfig{MaxBatchSize: 3, MaxContentSize: 20, BatchTimeout: time.Millisecond})
+	sender.Start()
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			assert.True(t, sender.BufferedCount() <= 3)
+			assert.True(t, sender.BufferedBytes() <= 20)
+		}
+	}()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	for i := 0; i < 100; i++ {
+		input <- newMessage([]byte(fmt.Sprintf("message %d", i)), source, "")
+	}
+	close(done)
+	wg.Wait()
+
+	sender.Stop()
+	assert.Len(t, output, 100)
+	assert.Equal(t, 0, sender.BufferedCount())
+}
@@ -0,0 +1,150 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package sender
+
+import (
+	"sync/atomic"
+)
+
+// BatchStats holds the counters of a BatchSender.
+type BatchStats struct {
+	// BatchesSent is the number of payloads sent to the main destination.
+	BatchesSent int64
+	// MessagesSent is the number of messages contained in the payloads sent.
+	MessagesSent int64
+	// BytesSent is the number of bytes sent to the main destination.
+	BytesSent int64
+	// HeartbeatsSent is the number of heartbeat payloads sent to the main destination,
+	// they are not counted in BatchesSent.
+	HeartbeatsSent int64
+	// SendFailures is the number of failed attempts to send a payload.
+	SendFailures int64
+	// TimeoutFlushes is the number of flushes triggered by the batch timeout.
+	TimeoutFlushes int64
+	// FullFlushes is the number of flushes triggered by a full buffer,
+	// the sum of BatchSizeFlushes and ContentSizeFlushes.
+	FullFlushes int64
+	// BatchSizeFlushes is the number of flushes triggered by the batch reaching its maximum number of messages.
+	BatchSizeFlushes int64
+	// ContentSizeFlushes is the number of flushes triggered by a message not fitting in the batch content size.
+	ContentSizeFlushes int64
+	// ShutdownFlushes is the number of flushes triggered by the sender stopping.
+	ShutdownFlushes int64
+	// RequestedFlushes is the number of flushes requested with Flush.
+	RequestedFlushes int64
+	// BytesThresholdFlushes is the number of flushes triggered by the batch reaching the flush bytes threshold.
+	BytesThresholdFlushes int64
+	// PendingLimitFlushes is the number of flushes triggered by the pending messages limit.
+	PendingLimitFlushes int64
+	// TruncatedMessages is the number of messages truncated because they were too large for a batch.
+	TruncatedMessages int64
+	// DroppedMessages is the number of messages that could not be added to a batch.
+	DroppedMessages int64
+	// EvictedMessages is the number of buffered messages evicted to make room for new ones with DropOldest.
+	EvictedMessages int64
+	// OversizedDropped is the number of messages dropped because they exceeded the max message size.
+	OversizedDropped int64
+	// SampledOutMessages is the number of messages dropped by the sampler.
+	SampledOutMessages int64
+	// OutputDroppedMessages is the number of sent messages dropped from the output buffer
+	// because outputChan was full.
+	OutputDroppedMessages int64
+	// PendingDropped is the number of messages dropped because the pending messages limit was reached.
+	PendingDropped int64
+	// Bisections is the number of rejected payloads split in two to isolate the messages rejected.
+	Bisections int64
+	// Sequence is the sequence number of the last payload built, 0 when none was.
+	Sequence uint64
+	// BufferedMessages is the number of messages in the batch being built.
+	BufferedMessages int64
+	// BufferedBytes is the size in bytes of the payload of the batch being built.
+	BufferedBytes int64
+	// InFlightBytes is the number of bytes held by the payloads being sent.
+	InFlightBytes int64
+	// PendingMessages is the number of messages read from inputChan and not sent yet.
+	PendingMessages int64
+	// CircuitState is the state of the circuit breaker, always closed when it is disabled.
+	CircuitState CircuitState
+}
+
+// batchCounters holds the counters updated by the sender goroutine,
+// they must only be accessed atomically.
+type batchCounters struct {
+	batchesSent           int64
+	messagesSent          int64
+	bytesSent             int64
+	heartbeatsSent        int64
+	sendFailures          int64
+	timeoutFlushes        int64
+	batchSizeFlushes      int64
+	contentSizeFlushes    int64
+	shutdownFlushes       int64
+	requestedFlushes      int64
+	bytesThresholdFlushes int64
+	pendingLimitFlushes   int64
+	truncatedMessages     int64
+	droppedMessages       int64
+	evictedMessages       int64
+	oversizedDropped      int64
+	sampledOutMessages    int64
+	outputDroppedMessages int64
+	pendingDropped        int64
+	bisections            int64
+	sequence              uint64
+	bufferedMessages      int64
+	bufferedBytes         int64
+}
+
+// snapshot returns the current value of the counters.
+func (c *batchCounters) snapshot() BatchStats {
+	stats := BatchStats{
+		BatchesSent:           atomic.LoadInt64(&c.batchesSent),
+		MessagesSent:          atomic.LoadInt64(&c.messagesSent),
+		BytesSent:             atomic.LoadInt64(&c.bytesSent),
+		HeartbeatsSent:        atomic.LoadInt64(&c.heartbeatsSent),
+		SendFailures:          atomic.LoadInt64(&c.sendFailures),
+		TimeoutFlushes:        atomic.LoadInt64(&c.timeoutFlushes),
+		BatchSizeFlushes:      atomic.LoadInt64(&c.batchSizeFlushes),
+		ContentSizeFlushes:    atomic.LoadInt64(&c.contentSizeFlushes),
+		ShutdownFlushes:       atomic.LoadInt64(&c.shutdownFlushes),
+		RequestedFlushes:      atomic.LoadInt64(&c.requestedFlushes),
+		BytesThresholdFlushes: atomic.LoadInt64(&c.bytesThresholdFlushes),
+		PendingLimitFlushes:   atomic.LoadInt64(&c.pendingLimitFlushes),
+		TruncatedMessages:     atomic.LoadInt64(&c.truncatedMessages),
+		DroppedMessages:       atomic.LoadInt64(&c.droppedMessages),
+		EvictedMessages:       atomic.LoadInt64(&c.evictedMessages),
+		OversizedDropped:      atomic.LoadInt64(&c.oversizedDropped),
+		SampledOutMessages:    atomic.LoadInt64(&c.sampledOutMessages),
+		OutputDroppedMessages: atomic.LoadInt64(&c.outputDroppedMessages),
+		PendingDropped:        atomic.LoadInt64(&c.pendingDropped),
+		Bisections:            atomic.LoadInt64(&c.bisections),
+		Sequence:              atomic.LoadUint64(&c.sequence),
+		BufferedMessages:      atomic.LoadInt64(&c.bufferedMessages),
+		BufferedBytes:         atomic.LoadInt64(&c.bufferedBytes),
+	}
+	stats.FullFlushes = stats.BatchSizeFlushes + stats.ContentSizeFlushes
+	return stats
+}
+
+// countFlush counts a flush of a non-empty buffer triggered for the reason.
+func (c *batchCounters) countFlush(reason FlushReason) {
+	switch reason {
+	case FlushReasonTimeout:
+		atomic.AddInt64(&c.timeoutFlushes, 1)
+	case FlushReasonBufferFull:
+		atomic.AddInt64(&c.batchSizeFlushes, 1)
+	case FlushReasonContentSizeExceeded:
+		atomic.AddInt64(&c.contentSizeFlushes, 1)
+	case FlushReasonShutdown:
+		atomic.AddInt64(&c.shutdownFlushes, 1)
+	case FlushReasonRequested:
+		atomic.AddInt64(&c.requestedFlushes, 1)
+	case FlushReasonBytesThreshold:
+		atomic.AddInt64(&c.bytesThresholdFlushes, 1)
+	case FlushReasonPendingLimit:
+		atomic.AddInt64(&c.pendingLimitFlushes, 1)
+	}
+}
@@ -0,0 +1,64 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package sender
+
+import (
+	"sync"
+)
+
+// byteLimiter bounds the number of bytes held by the payloads being sent.
+type byteLimiter struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	max     int64
+	current int64
+}
+
+// newByteLimiter returns a new byteLimiter, a zero max means no limit.
+func newByteLimiter(max int64) *byteLimiter {
+	l := &byteLimiter{
+		max: max,
+	}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// acquire blocks until n bytes can be held without exceeding the limit,
+// a payload larger than the limit is let through once no other payload is held.
+func (l *byteLimiter) acquire(n int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.max > 0 && l.current > 0 && l.current+n > l.max {
+		l.cond.Wait()
+	}
+	l.current += n
+}
+
+// tryAcquire acquires n bytes when it does not block, it returns false otherwise.
+func (l *byteLimiter) tryAcquire(n int64) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.max > 0 && l.current > 0 && l.current+n > l.max {
+		return false
+	}
+	l.current += n
+	return true
+}
+
+// release releases n bytes previously acquired.
+func (l *byteLimiter) release(n int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.current -= n
+	l.cond.Broadcast()
+}
+
+// held returns the number of bytes currently held.
+func (l *byteLimiter) held() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.current
+}
@@ -0,0 +1,59 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package sender
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestByteLimiter(t *testing.T) {
+	l := newByteLimiter(10)
+
+	l.acquire(6)
+	assert.Equal(t, int64(6), l.held())
+
+	acquired := make(chan struct{})
+	go func() {
+		l.acquire(6)
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		assert.Fail(t, "the limit should have been reached")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	l.release(6)
+	<-acquired
+	assert.Equal(t, int64(6), l.held())
+}
+
+func TestByteLimiterLetsLargePayloadsThroughAlone(t *testing.T) {
+	l := newByteLimiter(10)
+	l.acquire(20)
+	assert.Equal(t, int64(20), l.held())
+	l.release(20)
+	assert.Equal(t, int64(0), l.held())
+}
+
+func TestByteLimiterWithoutLimit(t *testing.T) {
+	l := newByteLimiter(0)
+	l.acquire(100)
+	l.acquire(100)
+	assert.Equal(t, int64(200), l.held())
+}
+
+func TestByteLimiterTryAcquire(t *testing.T) {
+	l := newByteLimiter(10)
+	assert.True(t, l.tryAcquire(6))
+	assert.False(t, l.tryAcquire(6))
+	assert.True(t, l.tryAcquire(4))
+	assert.Equal(t, int64(10), l.held())
+}
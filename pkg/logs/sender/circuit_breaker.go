@@ -0,0 +1,110 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package sender
+
+import (
+	"sync"
+	"time"
+)
+
+// CircuitState is the state of a circuit breaker.
+type CircuitState int32
+
+const (
+	// CircuitClosed means the payloads are sent.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen means the payloads are not sent until the cooldown expires.
+	CircuitOpen
+	// CircuitHalfOpen means a payload is sent to probe whether the destination recovered.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// circuitBreaker stops the sends for a cooldown after a number of consecutive failures,
+// then lets a single send probe the destination before closing again.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+	state     CircuitState
+	failures  int
+	openedAt  time.Time
+	probing   bool
+}
+
+// newCircuitBreaker returns a new closed circuitBreaker relying on the given clock.
+func newCircuitBreaker(threshold int, cooldown time.Duration, now func() time.Time) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       now,
+	}
+}
+
+// wait returns how long to wait before sending, zero meaning the send can be attempted now.
+// When the cooldown expired, the circuit is half-opened and a single send is allowed.
+func (cb *circuitBreaker) wait() time.Duration {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	switch cb.state {
+	case CircuitOpen:
+		remaining := cb.cooldown - cb.now().Sub(cb.openedAt)
+		if remaining > 0 {
+			return remaining
+		}
+		cb.state = CircuitHalfOpen
+		cb.probing = true
+		return 0
+	case CircuitHalfOpen:
+		if cb.probing {
+			// another send is probing the destination
+			return cb.cooldown
+		}
+		cb.probing = true
+		return 0
+	default:
+		return 0
+	}
+}
+
+// success closes the circuit.
+func (cb *circuitBreaker) success() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.state = CircuitClosed
+	cb.failures = 0
+	cb.probing = false
+}
+
+// failure opens the circuit when the threshold is reached or when the probe failed.
+func (cb *circuitBreaker) failure() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.failures++
+	if cb.state == CircuitHalfOpen || cb.failures >= cb.threshold {
+		cb.state = CircuitOpen
+		cb.openedAt = cb.now()
+		cb.probing = false
+	}
+}
+
+// getState returns the current state of the circuit.
+func (cb *circuitBreaker) getState() CircuitState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state
+}
@@ -0,0 +1,64 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package sender
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	now := time.Now()
+	clock := func() time.Time { return now }
+
+	cb := newCircuitBreaker(3, 10*time.Second, clock)
+	assert.Equal(t, CircuitClosed, cb.getState())
+
+	// a success resets the consecutive failures
+	cb.failure()
+	cb.failure()
+	cb.success()
+	cb.failure()
+	cb.failure()
+	assert.Equal(t, CircuitClosed, cb.getState())
+	assert.Equal(t, time.Duration(0), cb.wait())
+
+	// closed -> open
+	cb.failure()
+	assert.Equal(t, CircuitOpen, cb.getState())
+	assert.Equal(t, 10*time.Second, cb.wait())
+
+	now = now.Add(4 * time.Second)
+	assert.Equal(t, 6*time.Second, cb.wait())
+	assert.Equal(t, CircuitOpen, cb.getState())
+
+	// open -> half-open, a single probe is allowed
+	now = now.Add(6 * time.Second)
+	assert.Equal(t, time.Duration(0), cb.wait())
+	assert.Equal(t, CircuitHalfOpen, cb.getState())
+	assert.Equal(t, 10*time.Second, cb.wait())
+
+	// half-open -> open on a failed probe
+	cb.failure()
+	assert.Equal(t, CircuitOpen, cb.getState())
+	assert.Equal(t, 10*time.Second, cb.wait())
+
+	// half-open -> closed on a successful probe
+	now = now.Add(10 * time.Second)
+	assert.Equal(t, time.Duration(0), cb.wait())
+	assert.Equal(t, CircuitHalfOpen, cb.getState())
+	cb.success()
+	assert.Equal(t, CircuitClosed, cb.getState())
+	assert.Equal(t, time.Duration(0), cb.wait())
+}
+
+func TestCircuitStateString(t *testing.T) {
+	assert.Equal(t, "closed", CircuitClosed.String())
+	assert.Equal(t, "open", CircuitOpen.String())
+	assert.Equal(t, "half-open", CircuitHalfOpen.String())
+}
@@ -0,0 +1,82 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package sender
+
+import (
+	"time"
+)
+
+// clock provides the current time and the timers, so that tests can control them.
+type clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) timer
+	After(d time.Duration) <-chan time.Time
+}
+
+// timer is the subset of time.Timer used by the senders.
+type timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// realClock relies on the time package.
+type realClock struct{}
+
+// Now returns time.Now().
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// NewTimer returns a time.Timer.
+func (realClock) NewTimer(d time.Duration) timer {
+	return &realTimer{timer: time.NewTimer(d)}
+}
+
+// After returns time.After(d).
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// realTimer wraps a time.Timer.
+type realTimer struct {
+	timer *time.Timer
+}
+
+// C returns the channel of the timer.
+func (t *realTimer) C() <-chan time.Time {
+	return t.timer.C
+}
+
+// Stop stops the timer.
+func (t *realTimer) Stop() bool {
+	return t.timer.Stop()
+}
+
+// Reset changes the timer to expire after d.
+func (t *realTimer) Reset(d time.Duration) bool {
+	return t.timer.Reset(d)
+}
+
+// senderClock reads the clock of the sender on every call so that it can be replaced.
+type senderClock struct {
+	sender *BatchSender
+}
+
+// Now returns the current time of the sender clock.
+func (c senderClock) Now() time.Time {
+	return c.sender.clock.Now()
+}
+
+// NewTimer returns a timer of the sender clock.
+func (c senderClock) NewTimer(d time.Duration) timer {
+	return c.sender.clock.NewTimer(d)
+}
+
+// After waits on the sender clock.
+func (c senderClock) After(d time.Duration) <-chan time.Time {
+	return c.sender.clock.After(d)
+}
@@ -0,0 +1,88 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package sender
+
+import (
+	"context"
+
+	"github.com/DataDog/datadog-agent/pkg/logs/client"
+)
+
+// CompressingDestination compresses the payloads right before sending them to the destination it wraps.
+// Since every sender sends its payloads through destinations, wrapping them adds compression
+// to any sender, unlike BatchConfig.Compressor which only applies to a BatchSender.
+// The envelopes it sends keep their sequence number, their checksum is computed again on the payload
+// once compressed and their content encoding is set to the one of the compressor. Since the signature
+// can not be computed again, signed envelopes are sent uncompressed: a BatchSender sending to a
+// CompressingDestination compresses the payloads itself before signing them instead.
+type CompressingDestination struct {
+	destination client.Destination
+	transport   client.Transport
+	compressor  Compressor
+}
+
+// NewCompressingDestination returns a new CompressingDestination.
+func NewCompressingDestination(destination client.Destination, compressor Compressor) *CompressingDestination {
+	return &CompressingDestination{
+		destination: destination,
+		transport:   client.NewDestinationTransport(destination),
+		compressor:  compressor,
+	}
+}
+
+// NewCompressingDestinations returns destinations compressing the payloads
+// before sending them to the main and the additional destinations.
+func NewCompressingDestinations(destinations *client.Destinations, compressor Compressor) *client.Destinations {
+	additionals := make([]client.Destination, 0, len(destinations.Additionals))
+	for _, destination := range destinations.Additionals {
+		additionals = append(additionals, NewCompressingDestination(destination, compressor))
+	}
+	return client.NewDestinations(NewCompressingDestination(destinations.Main, compressor), additionals)
+}
+
+// Send compresses the payload and sends it, the errors of the destination are returned as is
+// so that retryable errors can still be retried.
+func (d *CompressingDestination) Send(payload []byte) error {
+	return d.SendEnvelope(client.Envelope{Payload: payload})
+}
+
+// SendEnvelope compresses the payload of the envelope and sends it along with its content encoding,
+// the errors of the destination are returned as is so that retryable errors can still be retried.
+// Payloads already compressed, encrypted or signed are sent as is, and so are the payloads that
+// can not be compressed or that compression does not make smaller.
+func (d *CompressingDestination) SendEnvelope(envelope client.Envelope) error {
+	if envelope.ContentEncoding == "" && envelope.Encryption == "" && envelope.Signature == nil {
+		envelope.Payload, envelope.ContentEncoding = compress(d.compressor, envelope.Payload)
+		if envelope.Checksum != nil {
+			envelope.Checksum = checksum(envelope.Payload)
+		}
+	}
+	return d.transport.Send(context.Background(), envelope)
+}
+
+// SendAsync sends the payload asynchronously, uncompressed since the content encoding
+// can not be sent along with it.
+func (d *CompressingDestination) SendAsync(payload []byte) {
+	d.destination.SendAsync(payload)
+}
+
+// ContentEncoding returns the content encoding of the payloads sent.
+func (d *CompressingDestination) ContentEncoding() string {
+	return d.compressor.ContentEncoding()
+}
+
+// compressesPayloads returns true when one of the destinations is a CompressingDestination.
+func compressesPayloads(destinations *client.Destinations) bool {
+	if destinations == nil {
+		return false
+	}
+	for _, destination := range append([]client.Destination{destinations.Main}, destinations.Additionals...) {
+		if _, ok := destination.(*CompressingDestination); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,193 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package sender
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DataDog/datadog-agent/pkg/logs/client"
+	"github.com/DataDog/datadog-agent/pkg/logs/config"
+	"github.com/DataDog/datadog-agent/pkg/logs/message"
+)
+
+func TestCompressingDestinationWithBatchSender(t *testing.T) {
+	input := make(chan *message.Message, 2)
+	output := make(chan *message.Message, 2)
+	destination := newMockDestination(nil, client.NewRetryableError(errors.New("server error")))
+	destinations := NewCompressingDestinations(client.NewDestinations(destination, nil), NewGzipCompressor(gzip.DefaultCompression))
+
+	sender := NewBatchSender(input, output, destinations, BatchConfig{MaxBatchSize: 2, BackoffBase: time.Millisecond})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	content := bytes.Repeat([]byte("a"), 500)
+	a := newMessage(content, source, "")
+	b := newMessage(content, source, "")
+	input <- a
+	input <- b
+
+	// the retryable error of the destination is retried
+	assert.Equal(t, fmt.Sprintf("[%s,%s]", content, content), string(gunzip(t, <-destination.payloads)))
+	assert.Equal(t, a, <-output)
+	assert.Equal(t, b, <-output)
+	assert.Equal(t, 2, destination.getAttempts())
+
+	sender.Stop()
+}
+
+func TestCompressingDestinationWithStreamSender(t *testing.T) {
+	input := make(chan *message.Message, 1)
+	output := make(chan *message.Message, 1)
+	destination := newMockDestination(nil)
+	destinations := NewCompressingDestinations(client.NewDestinations(destination, nil), NewGzipCompressor(gzip.DefaultCompression))
+
+	sender := NewStreamSender(input, output, destinations)
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	content := bytes.Repeat([]byte("a"), 500)
+	m := newMessage(content, source, "")
+	input <- m
+
+	assert.Equal(t, string(content), string(gunzip(t, <-destination.payloads)))
+	assert.Equal(t, m, <-output)
+
+	sender.Stop()
+}
+
+func TestCompressingDestinationCompressionError(t *testing.T) {
+	destination := newMockDestination(nil)
+	compressing := NewCompressingDestination(destination, &failingCompressor{})
+
+	// the payload is sent uncompressed
+	assert.Nil(t, compressing.Send([]byte("a")))
+	assert.Equal(t, "a", string(<-destination.payloads))
+	assert.Equal(t, "failing", compressing.ContentEncoding())
+}
+
+func TestCompressingDestinationKeepsThePayloadsCompressionDoesNotShrink(t *testing.T) {
+	destination := &recordingDestination{
+		mockDestination: newMockDestination(nil),
+		envelopes:       make(chan client.Envelope, 1),
+	}
+	compressing := NewCompressingDestination(destination, NewGzipCompressor(gzip.DefaultCompression))
+
+	assert.Nil(t, compressing.Send([]byte("a")))
+	envelope := <-destination.envelopes
+	assert.Equal(t, "a", string(envelope.Payload))
+	assert.Equal(t, "", envelope.ContentEncoding)
+}
+
+func TestCompressingDestinationComputesTheChecksumOfTheCompressedPayload(t *testing.T) {
+	destination := &recordingDestination{
+		mockDestination: newMockDestination(nil),
+		envelopes:       make(chan client.Envelope, 1),
+	}
+	compressing := NewCompressingDestination(destination, NewGzipCompressor(gzip.DefaultCompression))
+	payload := bytes.Repeat([]byte("a"), 500)
+
+	assert.Nil(t, compressing.SendEnvelope(client.Envelope{Payload: payload, Checksum: checksum(payload)}))
+	envelope := <-destination.envelopes
+	assert.Equal(t, string(payload), string(gunzip(t, envelope.Payload)))
+	assert.Equal(t, checksum(envelope.Payload), envelope.Checksum)
+}
+
+func TestCompressingDestinationSendsSignedEnvelopesUncompressed(t *testing.T) {
+	destination := &recordingDestination{
+		mockDestination: newMockDestination(nil),
+		envelopes:       make(chan client.Envelope, 1),
+	}
+	compressing := NewCompressingDestination(destination, NewGzipCompressor(gzip.DefaultCompression))
+	payload := bytes.Repeat([]byte("a"), 500)
+	key := []byte("secret")
+
+	assert.Nil(t, compressing.SendEnvelope(client.Envelope{Payload: payload, Signature: sign(key, payload)}))
+	envelope := <-destination.envelopes
+	assert.Equal(t, payload, envelope.Payload)
+	assert.Equal(t, "", envelope.ContentEncoding)
+	assert.Equal(t, sign(key, payload), envelope.Signature)
+}
+
+func TestCompressingDestinationSendsAsyncPayloadsUncompressed(t *testing.T) {
+	destination := &asyncDestination{mockDestination: newMockDestination(nil), async: make(chan []byte, 1)}
+	compressing := NewCompressingDestination(destination, NewGzipCompressor(gzip.DefaultCompression))
+	payload := bytes.Repeat([]byte("a"), 500)
+
+	// the content encoding can not be sent along with the payload
+	compressing.SendAsync(payload)
+	assert.Equal(t, payload, <-destination.async)
+}
+
+// recordingDestination records the envelopes it receives.
+type recordingDestination struct {
+	*mockDestination
+	envelopes chan client.Envelope
+}
+
+func (d *recordingDestination) SendEnvelope(envelope client.Envelope) error {
+	d.envelopes <- envelope
+	return d.Send(envelope.Payload)
+}
+
+func TestCompressingDestinationSendsTheEnvelope(t *testing.T) {
+	input := make(chan *message.Message, 1)
+	output := make(chan *message.Message, 1)
+	destination := &recordingDestination{
+		mockDestination: newMockDestination(nil),
+		envelopes:       make(chan client.Envelope, 1),
+	}
+	destinations := NewCompressingDestinations(client.NewDestinations(destination, nil), NewGzipCompressor(gzip.DefaultCompression))
+	key := []byte("secret")
+
+	sender := NewBatchSender(input, output, destinations, BatchConfig{MaxBatchSize: 1, SigningKey: key})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	content := bytes.Repeat([]byte("a"), 500)
+	input <- newMessage(content, source, "")
+	envelope := <-destination.envelopes
+	<-output
+	sender.Stop()
+
+	assert.Equal(t, fmt.Sprintf("[%s]", content), string(gunzip(t, envelope.Payload)))
+	assert.Equal(t, "gzip", envelope.ContentEncoding)
+	assert.Equal(t, uint64(1), envelope.Sequence)
+	// the signature and the checksum cover the payload as sent
+	assert.Equal(t, sign(key, envelope.Payload), envelope.Signature)
+	assert.Equal(t, checksum(envelope.Payload), envelope.Checksum)
+}
+
+func TestCompressingDestinationIsNotStackedWithTheBatchCompressor(t *testing.T) {
+	input := make(chan *message.Message, 1)
+	output := make(chan *message.Message, 1)
+	destination := &recordingDestination{
+		mockDestination: newMockDestination(nil),
+		envelopes:       make(chan client.Envelope, 1),
+	}
+	compressor := NewGzipCompressor(gzip.DefaultCompression)
+	destinations := NewCompressingDestinations(client.NewDestinations(destination, nil), compressor)
+
+	sender := NewBatchSender(input, output, destinations, BatchConfig{MaxBatchSize: 1, Compressor: compressor})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	content := bytes.Repeat([]byte("a"), 500)
+	input <- newMessage(content, source, "")
+	envelope := <-destination.envelopes
+	<-output
+	sender.Stop()
+
+	// the payload is compressed once
+	assert.Equal(t, fmt.Sprintf("[%s]", content), string(gunzip(t, envelope.Payload)))
+	assert.Equal(t, "gzip", envelope.ContentEncoding)
+}
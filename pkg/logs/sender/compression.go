@@ -0,0 +1,102 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package sender
+
+import (
+	"bytes"
+	"compress/gzip"
+
+	"github.com/DataDog/zstd"
+
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+// Compressor compresses payloads before they are sent.
+type Compressor interface {
+	// Compress returns the compressed payload.
+	Compress(payload []byte) ([]byte, error)
+	// ContentEncoding returns the HTTP content encoding of the compressed payloads.
+	ContentEncoding() string
+}
+
+// GzipCompressor compresses payloads with gzip.
+type GzipCompressor struct {
+	level int
+}
+
+// NewGzipCompressor returns a new GzipCompressor,
+// the default level is used when level is invalid.
+func NewGzipCompressor(level int) *GzipCompressor {
+	if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+		log.Warnf("Invalid gzip compression level %d, using default %d", level, gzip.DefaultCompression)
+		level = gzip.DefaultCompression
+	}
+	return &GzipCompressor{
+		level: level,
+	}
+}
+
+// Compress compresses the payload with gzip.
+func (c *GzipCompressor) Compress(payload []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer, err := gzip.NewWriterLevel(&buffer, c.level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = writer.Write(payload); err != nil {
+		return nil, err
+	}
+	if err = writer.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// ContentEncoding returns gzip.
+func (c *GzipCompressor) ContentEncoding() string {
+	return "gzip"
+}
+
+// ZstdCompressor compresses payloads with zstd.
+type ZstdCompressor struct {
+	level int
+}
+
+// NewZstdCompressor returns a new ZstdCompressor,
+// the default level is used when level is invalid.
+func NewZstdCompressor(level int) *ZstdCompressor {
+	if level < zstd.BestSpeed || level > zstd.BestCompression {
+		log.Warnf("Invalid zstd compression level %d, using default %d", level, zstd.DefaultCompression)
+		level = zstd.DefaultCompression
+	}
+	return &ZstdCompressor{
+		level: level,
+	}
+}
+
+// Compress compresses the payload with zstd.
+func (c *ZstdCompressor) Compress(payload []byte) ([]byte, error) {
+	return zstd.CompressLevel(nil, payload, c.level)
+}
+
+// ContentEncoding returns zstd.
+func (c *ZstdCompressor) ContentEncoding() string {
+	return "zstd"
+}
+
+// compress returns the payload compressed with the compressor along with its content encoding,
+// the original payload and an empty encoding are returned when compressing it fails or does not make it smaller.
+func compress(compressor Compressor, payload []byte) ([]byte, string) {
+	compressed, err := compressor.Compress(payload)
+	if err != nil {
+		log.Warnf("Could not compress payload: %v", err)
+		return payload, ""
+	}
+	if len(compressed) >= len(payload) {
+		return payload, ""
+	}
+	return compressed, compressor.ContentEncoding()
+}
@@ -0,0 +1,87 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package sender
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/DataDog/zstd"
+	"github.com/stretchr/testify/assert"
+)
+
+func gunzip(t *testing.T, payload []byte) []byte {
+	reader, err := gzip.NewReader(bytes.NewReader(payload))
+	assert.Nil(t, err)
+	content, err := ioutil.ReadAll(reader)
+	assert.Nil(t, err)
+	return content
+}
+
+func TestGzipCompressor(t *testing.T) {
+	payload := bytes.Repeat([]byte("a log line that compresses well,"), 100)
+	compressor := NewGzipCompressor(gzip.BestCompression)
+
+	compressed, err := compressor.Compress(payload)
+	assert.Nil(t, err)
+	assert.True(t, len(compressed) < len(payload))
+	assert.Equal(t, payload, gunzip(t, compressed))
+	assert.Equal(t, "gzip", compressor.ContentEncoding())
+}
+
+func TestZstdCompressor(t *testing.T) {
+	payload := bytes.Repeat([]byte("a log line that compresses well,"), 100)
+	compressor := NewZstdCompressor(zstd.DefaultCompression)
+
+	compressed, err := compressor.Compress(payload)
+	assert.Nil(t, err)
+	assert.True(t, len(compressed) < len(payload))
+	decompressed, err := zstd.Decompress(nil, compressed)
+	assert.Nil(t, err)
+	assert.Equal(t, payload, decompressed)
+	assert.Equal(t, "zstd", compressor.ContentEncoding())
+}
+
+func TestCompressorsFallBackToDefaultLevel(t *testing.T) {
+	assert.Equal(t, gzip.DefaultCompression, NewGzipCompressor(42).level)
+	assert.Equal(t, zstd.DefaultCompression, NewZstdCompressor(0).level)
+}
+
+type failingCompressor struct{}
+
+func (c *failingCompressor) Compress(payload []byte) ([]byte, error) {
+	return nil, errors.New("compression error")
+}
+
+func (c *failingCompressor) ContentEncoding() string {
+	return "failing"
+}
+
+func TestCompress(t *testing.T) {
+	payload := bytes.Repeat([]byte("a log line that compresses well,"), 100)
+	compressed, encoding := compress(NewGzipCompressor(gzip.DefaultCompression), payload)
+	assert.True(t, len(compressed) < len(payload))
+	assert.Equal(t, "gzip", encoding)
+
+	// payloads that do not shrink are not compressed
+	payload = []byte("[a]")
+	compressed, encoding = compress(NewGzipCompressor(gzip.DefaultCompression), payload)
+	assert.Equal(t, payload, compressed)
+	assert.Equal(t, "", encoding)
+
+	// payloads that can not be compressed are sent as is
+	compressed, encoding = compress(&failingCompressor{}, payload)
+	assert.Equal(t, payload, compressed)
+	assert.Equal(t, "", encoding)
+}
+
+func TestBatchSenderWithoutCompressor(t *testing.T) {
+	sender := NewBatchSender(nil, nil, nil, BatchConfig{})
+	assert.Equal(t, "", sender.ContentEncoding())
+}
@@ -0,0 +1,128 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package sender
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/logs/client"
+	"github.com/DataDog/datadog-agent/pkg/logs/metrics"
+)
+
+// deliveryOutcome tells how the delivery of a batch ended.
+type deliveryOutcome int
+
+const (
+	// delivered means the batch was sent to the main destination.
+	delivered deliveryOutcome = iota
+	// rejected means the main destination returned a non-retryable error.
+	rejected
+	// exhausted means the batch could not be sent before the retries were exhausted.
+	exhausted
+	// interrupted means the sender was cancelled while waiting to retry.
+	interrupted
+	// cancelled means the destination context was cancelled, the agent is stopping non-gracefully.
+	cancelled
+)
+
+// delivery sends the batches to the main destination, retrying on retryable errors
+// after the backoff delay and waiting while the circuit is open.
+type delivery struct {
+	transport      client.Transport
+	backoff        backoffPolicy
+	circuitBreaker *circuitBreaker
+	clock          clock
+	counters       *batchCounters
+}
+
+// deliver sends the batch until it is delivered or given up on, it returns how the delivery ended along with
+// the number of attempts made and the last error. ctx is the context of the sender.
+func (d *delivery) deliver(ctx context.Context, pending batch) (deliveryOutcome, int, error) {
+	start := d.clock.Now()
+	for attempt := 1; ; attempt++ {
+		d.waitCircuit(ctx)
+		// this call is blocking until payload is sent (or the connection destination context cancelled)
+		err := d.send(ctx, pending)
+		if err == nil {
+			if d.circuitBreaker != nil {
+				d.circuitBreaker.success()
+			}
+			return delivered, attempt, nil
+		}
+		metrics.DestinationErrors.Add(1)
+		atomic.AddInt64(&d.counters.sendFailures, 1)
+		if err == context.Canceled {
+			return cancelled, attempt, err
+		}
+		if _, ok := err.(*client.RetryableError); !ok {
+			if d.circuitBreaker != nil {
+				// the destination is reachable, only this payload is rejected
+				d.circuitBreaker.success()
+			}
+			return rejected, attempt, err
+		}
+		// could not send the payload because of a transport issue,
+		// let's retry after a delay.
+		if d.circuitBreaker != nil {
+			d.circuitBreaker.failure()
+		}
+		delay := d.backoff.delay(attempt)
+		if !d.backoff.canRetry(attempt, d.clock.Now().Sub(start)+delay) {
+			return exhausted, attempt, err
+		}
+		if !d.wait(ctx, delay) {
+			// the sender is stopping, do not retry
+			return interrupted, attempt, err
+		}
+	}
+}
+
+// wait waits for the delay before the next attempt, it returns false when the sender is cancelled meanwhile.
+func (d *delivery) wait(ctx context.Context, delay time.Duration) bool {
+	if delay <= 0 {
+		return ctx.Err() == nil
+	}
+	select {
+	case <-d.clock.After(delay):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// send sends the payload along with its metadata through the transport.
+func (d *delivery) send(ctx context.Context, pending batch) error {
+	if ctx.Err() != nil {
+		// the payloads sent once the sender is cancelled are the ones of the final flush,
+		// which is bounded by FinalFlushTimeout.
+		ctx = context.Background()
+	}
+	envelope := client.Envelope{
+		Payload:         pending.payload,
+		Signature:       pending.signature,
+		Sequence:        pending.sequence,
+		Checksum:        checksum(pending.payload),
+		ContentEncoding: pending.contentEncoding,
+		Encryption:      pending.encryption,
+	}
+	return d.transport.Send(ctx, envelope)
+}
+
+// waitCircuit blocks while the circuit is open, or until the sender is cancelled.
+func (d *delivery) waitCircuit(ctx context.Context) {
+	if d.circuitBreaker == nil {
+		return
+	}
+	for wait := d.circuitBreaker.wait(); wait > 0; wait = d.circuitBreaker.wait() {
+		select {
+		case <-d.clock.After(wait):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,105 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package sender
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DataDog/datadog-agent/pkg/logs/client"
+)
+
+func newTestDelivery(destination client.Destination, backoff backoffPolicy) *delivery {
+	return &delivery{
+		transport: client.NewDestinationTransport(destination),
+		backoff:   backoff,
+		clock:     newFakeClock(),
+		counters:  &batchCounters{},
+	}
+}
+
+func TestDeliveryOutcomes(t *testing.T) {
+	retryableErr := client.NewRetryableError(errors.New("server error"))
+	clientErr := errors.New("client error")
+	for name, c := range map[string]struct {
+		errs     []error
+		backoff  backoffPolicy
+		outcome  deliveryOutcome
+		attempts int
+		err      error
+	}{
+		"delivered":       {nil, backoffPolicy{}, delivered, 1, nil},
+		"retried":         {[]error{retryableErr, retryableErr}, backoffPolicy{}, delivered, 3, nil},
+		"rejected":        {[]error{clientErr}, backoffPolicy{}, rejected, 1, clientErr},
+		"exhausted":       {[]error{retryableErr, retryableErr}, backoffPolicy{maxAttempts: 2}, exhausted, 2, retryableErr},
+		"cancelled":       {[]error{context.Canceled}, backoffPolicy{}, cancelled, 1, context.Canceled},
+		"retryable first": {[]error{retryableErr, clientErr}, backoffPolicy{}, rejected, 2, clientErr},
+	} {
+		t.Run(name, func(t *testing.T) {
+			destination := newMockDestination(nil, c.errs...)
+			d := newTestDelivery(destination, c.backoff)
+
+			outcome, attempts, err := d.deliver(context.Background(), batch{payload: []byte("a")})
+			assert.Equal(t, c.outcome, outcome)
+			assert.Equal(t, c.attempts, attempts)
+			assert.Equal(t, c.err, err)
+			assert.Equal(t, int64(len(c.errs)), d.counters.sendFailures)
+		})
+	}
+}
+
+func TestDeliveryIsInterruptedWhileWaitingToRetry(t *testing.T) {
+	retryableErr := client.NewRetryableError(errors.New("server error"))
+	destination := newMockDestination(retryableErr)
+	d := newTestDelivery(destination, backoffPolicy{base: time.Hour, factor: 2})
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan deliveryOutcome)
+	go func() {
+		outcome, _, _ := d.deliver(ctx, batch{payload: []byte("a")})
+		done <- outcome
+	}()
+	cancel()
+	assert.Equal(t, interrupted, <-done)
+}
+
+// encodingDestination records the content encodings of the envelopes it receives.
+type encodingDestination struct {
+	*mockDestination
+	encodings chan string
+}
+
+func (d *encodingDestination) SendEnvelope(envelope client.Envelope) error {
+	d.encodings <- envelope.ContentEncoding
+	return d.Send(envelope.Payload)
+}
+
+func TestDeliverySendsTheContentEncoding(t *testing.T) {
+	destination := &encodingDestination{
+		mockDestination: newMockDestination(nil),
+		encodings:       make(chan string, 1),
+	}
+	d := newTestDelivery(destination, backoffPolicy{})
+
+	outcome, _, err := d.deliver(context.Background(), batch{payload: []byte("a"), contentEncoding: "gzip"})
+	assert.Equal(t, delivered, outcome)
+	assert.Nil(t, err)
+	assert.Equal(t, "gzip", <-destination.encodings)
+	assert.Equal(t, "a", string(<-destination.payloads))
+}
+
+func TestDeliverySendsBarePayloadsToTheOtherDestinations(t *testing.T) {
+	destination := newMockDestination(nil)
+	d := newTestDelivery(destination, backoffPolicy{})
+
+	outcome, _, _ := d.deliver(context.Background(), batch{payload: []byte("a"), contentEncoding: "gzip"})
+	assert.Equal(t, delivered, outcome)
+	assert.Equal(t, "a", string(<-destination.payloads))
+}
@@ -0,0 +1,72 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package sender
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"io"
+)
+
+// encryption is the name of the cipher of the Encryptor, sent along with the encrypted payloads.
+const encryption = "aes-gcm"
+
+// errEncryptedPayloadTooShort is returned when decrypting a payload shorter than a nonce.
+var errEncryptedPayloadTooShort = errors.New("encrypted payload too short")
+
+// Encryptor encrypts payloads with AES-GCM, the nonce used is prepended to every encrypted payload.
+type Encryptor struct {
+	aead   cipher.AEAD
+	nonces io.Reader
+}
+
+// NewEncryptor returns a new Encryptor using the AES key, which must be 16, 24 or 32 bytes long,
+// the nonces are read from nonces, nil means they are random.
+// A nonce must never be used twice with the same key.
+func NewEncryptor(key []byte, nonces io.Reader) (*Encryptor, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if nonces == nil {
+		nonces = rand.Reader
+	}
+	return &Encryptor{
+		aead:   aead,
+		nonces: nonces,
+	}, nil
+}
+
+// Encryption returns the name of the cipher the payloads are encrypted with.
+func (e *Encryptor) Encryption() string {
+	return encryption
+}
+
+// Encrypt returns the nonce followed by the encrypted and authenticated payload.
+func (e *Encryptor) Encrypt(payload []byte) ([]byte, error) {
+	nonceSize := e.aead.NonceSize()
+	encrypted := make([]byte, nonceSize, nonceSize+len(payload)+e.aead.Overhead())
+	if _, err := io.ReadFull(e.nonces, encrypted); err != nil {
+		return nil, err
+	}
+	return e.aead.Seal(encrypted, encrypted, payload, nil), nil
+}
+
+// Decrypt returns the payload encrypted by Encrypt, an error is returned when
+// the payload was not encrypted with the same key or was tampered with.
+func (e *Encryptor) Decrypt(encrypted []byte) ([]byte, error) {
+	nonceSize := e.aead.NonceSize()
+	if len(encrypted) < nonceSize {
+		return nil, errEncryptedPayloadTooShort
+	}
+	return e.aead.Open(nil, encrypted[:nonceSize], encrypted[nonceSize:], nil)
+}
@@ -0,0 +1,75 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package sender
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var encryptionKey = []byte("0123456789abcdef0123456789abcdef")
+
+func TestEncryptorRoundTrip(t *testing.T) {
+	nonce := bytes.Repeat([]byte{7}, 12)
+	encryptor, err := NewEncryptor(encryptionKey, bytes.NewReader(nonce))
+	require.NoError(t, err)
+
+	payload := []byte(`[{"message":"a"}]`)
+	encrypted, err := encryptor.Encrypt(payload)
+	require.NoError(t, err)
+	assert.Equal(t, nonce, encrypted[:12])
+	assert.False(t, bytes.Contains(encrypted, payload))
+
+	decrypted, err := encryptor.Decrypt(encrypted)
+	require.NoError(t, err)
+	assert.Equal(t, payload, decrypted)
+
+	// the nonce source is exhausted
+	_, err = encryptor.Encrypt(payload)
+	assert.Error(t, err)
+}
+
+func TestEncryptorDetectsTampering(t *testing.T) {
+	encryptor, err := NewEncryptor(encryptionKey, nil)
+	require.NoError(t, err)
+	encrypted, err := encryptor.Encrypt([]byte("payload"))
+	require.NoError(t, err)
+
+	for i := range encrypted {
+		tampered := append([]byte(nil), encrypted...)
+		tampered[i] ^= 1
+		_, err = encryptor.Decrypt(tampered)
+		assert.Error(t, err, "byte %d", i)
+	}
+
+	_, err = encryptor.Decrypt(encrypted[:len(encrypted)-1])
+	assert.Error(t, err)
+	_, err = encryptor.Decrypt(encrypted[:4])
+	assert.Equal(t, errEncryptedPayloadTooShort, err)
+
+	other, err := NewEncryptor(bytes.Repeat([]byte{1}, 32), nil)
+	require.NoError(t, err)
+	_, err = other.Decrypt(encrypted)
+	assert.Error(t, err)
+}
+
+func TestEncryptorUsesFreshNonces(t *testing.T) {
+	encryptor, err := NewEncryptor(encryptionKey, nil)
+	require.NoError(t, err)
+	a, err := encryptor.Encrypt([]byte("payload"))
+	require.NoError(t, err)
+	b, err := encryptor.Encrypt([]byte("payload"))
+	require.NoError(t, err)
+	assert.NotEqual(t, a, b)
+}
+
+func TestNewEncryptorRejectsInvalidKeys(t *testing.T) {
+	_, err := NewEncryptor([]byte("short"), nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,52 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package sender
+
+import (
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+// warnc logs the errors when there is no handler, it is replaced in tests.
+var warnc = log.Warnc
+
+// ErrorHandler is called with the last error of a payload that could not be sent along with its description.
+type ErrorHandler func(err error, failure SendFailure)
+
+// SendFailure describes a payload that could not be sent so that the failures can be correlated.
+type SendFailure struct {
+	PayloadSize  int
+	MessageCount int
+	// FlushReason is the reason the payload was flushed, empty when it is unknown.
+	FlushReason string
+	// Sequence is the sequence number of the payload, zero when it is unknown.
+	Sequence uint64
+	// Attempts is the number of attempts to send the payload, zero when it is unknown.
+	Attempts int
+}
+
+// context returns the error and the fields of the failure as alternating keys and values, skipping the unknown ones.
+func (f SendFailure) context(err error) []interface{} {
+	context := []interface{}{"error", err, "payload_size", f.PayloadSize, "message_count", f.MessageCount}
+	if f.FlushReason != "" {
+		context = append(context, "flush_reason", f.FlushReason)
+	}
+	if f.Sequence > 0 {
+		context = append(context, "sequence", f.Sequence)
+	}
+	if f.Attempts > 0 {
+		context = append(context, "attempts", f.Attempts)
+	}
+	return context
+}
+
+// report calls the handler, or logs the error along with the description and the failure when the handler is nil.
+func (h ErrorHandler) report(err error, description string, failure SendFailure) {
+	if h == nil {
+		warnc(description, failure.context(err)...)
+		return
+	}
+	h(err, failure)
+}
@@ -0,0 +1,43 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package sender
+
+import (
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+
+	"github.com/DataDog/datadog-agent/pkg/logs/client"
+	"github.com/DataDog/datadog-agent/pkg/logs/client/file"
+	"github.com/DataDog/datadog-agent/pkg/logs/message"
+)
+
+// FileSender batches the messages like a BatchSender and writes the payloads
+// to rotating files for a forwarder to pick them up.
+type FileSender struct {
+	*BatchSender
+	destination *file.Destination
+}
+
+// NewFileSender returns a new FileSender writing to dir,
+// see file.NewDestination for the rotation and the retention of the files.
+func NewFileSender(inputChan, outputChan chan *message.Message, dir string, maxFileSize int64, maxFiles int, config BatchConfig) (*FileSender, error) {
+	destination, err := file.NewDestination(dir, maxFileSize, maxFiles)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSender{
+		BatchSender: NewBatchSender(inputChan, outputChan, client.NewDestinations(destination, nil), config),
+		destination: destination,
+	}, nil
+}
+
+// Stop stops the FileSender,
+// this call blocks until inputChan is flushed and the current file is synced.
+func (s *FileSender) Stop() {
+	s.BatchSender.Stop()
+	if err := s.destination.Close(); err != nil {
+		log.Warnf("Could not close spool file: %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package sender
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/DataDog/datadog-agent/pkg/logs/client/file"
+	"github.com/DataDog/datadog-agent/pkg/logs/config"
+	"github.com/DataDog/datadog-agent/pkg/logs/message"
+)
+
+func TestFileSender(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logs-spool")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	input := make(chan *message.Message, 4)
+	output := make(chan *message.Message, 4)
+
+	// every payload goes to its own file and only the last two files are kept
+	sender, err := NewFileSender(input, output, dir, 1, 2, BatchConfig{MaxBatchSize: 2, Formatter: NewNDJSONFormatter()})
+	require.NoError(t, err)
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	for _, content := range []string{"a", "b", "c", "d"} {
+		input <- newMessage([]byte(content), source, "")
+	}
+	sender.Stop()
+	assert.Len(t, output, 4)
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+
+	var payloads []string
+	var sequences []uint64
+	for _, path := range files {
+		f, err := os.Open(path)
+		require.NoError(t, err)
+		envelopes, err := file.ReadEnvelopes(f)
+		f.Close()
+		require.NoError(t, err)
+		for _, envelope := range envelopes {
+			payloads = append(payloads, string(envelope.Payload))
+			sequences = append(sequences, envelope.Sequence)
+		}
+	}
+	assert.Equal(t, []string{"a\nb", "c\nd"}, payloads)
+	// the metadata of the payloads are spooled along with them
+	assert.Equal(t, []uint64{1, 2}, sequences)
+}
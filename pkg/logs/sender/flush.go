@@ -0,0 +1,79 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package sender
+
+import "time"
+
+// FlushInfo describes a batch once it has been sent.
+type FlushInfo struct {
+	// Bytes is the size in bytes of the uncompressed payload.
+	Bytes int
+	// CompressedBytes is the size in bytes of the payload as it is sent,
+	// it is equal to Bytes when the payload is not compressed.
+	CompressedBytes int
+	// CompressDuration is the time spent compressing the payload, zero when compression is disabled.
+	CompressDuration time.Duration
+	// SendDuration is the time spent sending the payload, retries included.
+	SendDuration time.Duration
+	// Count is the number of messages in the batch.
+	Count int
+	// Reason tells why the batch is sent.
+	Reason FlushReason
+	// MaxAge is the time the oldest message of the batch spent in the buffer.
+	MaxAge time.Duration
+	// MeanAge is the mean time the messages of the batch spent in the buffer.
+	MeanAge time.Duration
+}
+
+// CompressionRatio returns the ratio of the uncompressed size to the size of the payload as it is sent,
+// 1 when the payload is not compressed.
+func (i FlushInfo) CompressionRatio() float64 {
+	if i.CompressedBytes == 0 {
+		return 1
+	}
+	return float64(i.Bytes) / float64(i.CompressedBytes)
+}
+
+// FlushReason tells why a batch has been sent.
+type FlushReason uint8
+
+const (
+	// FlushReasonBufferFull means the batch reached its maximum number of messages.
+	FlushReasonBufferFull FlushReason = iota
+	// FlushReasonContentSizeExceeded means the next message did not fit in the batch.
+	FlushReasonContentSizeExceeded
+	// FlushReasonTimeout means the batch timeout expired.
+	FlushReasonTimeout
+	// FlushReasonRequested means a flush was requested with Flush.
+	FlushReasonRequested
+	// FlushReasonShutdown means the sender is stopping.
+	FlushReasonShutdown
+	// FlushReasonBytesThreshold means the batch reached the flush bytes threshold.
+	FlushReasonBytesThreshold
+	// FlushReasonPendingLimit means the pending messages limit was reached.
+	FlushReasonPendingLimit
+)
+
+func (r FlushReason) String() string {
+	switch r {
+	case FlushReasonBufferFull:
+		return "buffer_full"
+	case FlushReasonContentSizeExceeded:
+		return "content_size_exceeded"
+	case FlushReasonTimeout:
+		return "timeout"
+	case FlushReasonRequested:
+		return "requested"
+	case FlushReasonShutdown:
+		return "shutdown"
+	case FlushReasonBytesThreshold:
+		return "bytes_threshold"
+	case FlushReasonPendingLimit:
+		return "pending_limit"
+	default:
+		return "unknown"
+	}
+}
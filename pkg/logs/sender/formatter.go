@@ -0,0 +1,106 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package sender
+
+import (
+	"bytes"
+)
+
+// Formatter describes how the messages of a batch are framed into a payload.
+type Formatter struct {
+	// Prefix is written at the beginning of the payload.
+	Prefix []byte
+	// Separator is written between two messages.
+	Separator []byte
+	// Suffix is written at the end of a non-empty payload.
+	Suffix []byte
+	// Escape transforms the content of a message before it is written, optional.
+	Escape func(content []byte) []byte
+	// Truncate shortens a content so that it is at most max bytes long and still a valid message,
+	// it returns false when the content can not be shortened. Optional, the messages that do not fit
+	// in a batch are dropped without it.
+	Truncate func(content []byte, max int) ([]byte, bool)
+}
+
+// NewJSONArrayFormatter returns a formatter that frames messages into a JSON array.
+func NewJSONArrayFormatter() *Formatter {
+	return NewArrayFormatter([]byte("["), []byte(","), []byte("]"))
+}
+
+// NewArrayFormatter returns a formatter that frames messages with the given byte sequences,
+// e.g. to wrap the JSON array in an envelope like {"logs":[...]}.
+// The sizes of all the sequences are accounted in the size of the payloads.
+func NewArrayFormatter(prefix, separator, suffix []byte) *Formatter {
+	return &Formatter{
+		Prefix:    prefix,
+		Separator: separator,
+		Suffix:    suffix,
+		Truncate:  truncateJSONMessage,
+	}
+}
+
+// NewNDJSONFormatter returns a formatter that frames messages as newline-delimited JSON,
+// one message per line.
+func NewNDJSONFormatter() *Formatter {
+	return &Formatter{
+		Separator: []byte("\n"),
+		Escape:    escapeNewlines,
+		Truncate:  truncateJSONMessage,
+	}
+}
+
+// Frame returns the payload made of a single message.
+func (f *Formatter) Frame(content []byte) []byte {
+	content = f.escape(content)
+	payload := make([]byte, 0, len(f.Prefix)+len(content)+len(f.Suffix))
+	payload = append(payload, f.Prefix...)
+	payload = append(payload, content...)
+	return append(payload, f.Suffix...)
+}
+
+// escape returns the content as it is written.
+func (f *Formatter) escape(content []byte) []byte {
+	if f.Escape == nil {
+		return content
+	}
+	return f.Escape(content)
+}
+
+// truncate shortens the content with Truncate so that it is at most max bytes long once escaped,
+// it returns false when it can not.
+func (f *Formatter) truncate(content []byte, max int) ([]byte, bool) {
+	if f.Truncate == nil {
+		return nil, false
+	}
+	// escaping may make the content longer, remove the excess until it fits
+	for limit := max; limit > 0; {
+		truncated, ok := f.Truncate(content, limit)
+		if !ok {
+			return nil, false
+		}
+		size := len(f.escape(truncated))
+		if size <= max {
+			return truncated, true
+		}
+		limit -= size - max
+	}
+	return nil, false
+}
+
+var (
+	newline        = []byte("\n")
+	carriageReturn = []byte("\r")
+)
+
+// escapeNewlines replaces raw line breaks with their JSON escape sequence
+// so that a message never spans multiple lines.
+func escapeNewlines(content []byte) []byte {
+	if bytes.IndexAny(content, "\r\n") == -1 {
+		return content
+	}
+	content = bytes.Replace(content, newline, []byte(`\n`), -1)
+	return bytes.Replace(content, carriageReturn, []byte(`\r`), -1)
+}
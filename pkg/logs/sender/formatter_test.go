@@ -0,0 +1,131 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package sender
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DataDog/datadog-agent/pkg/logs/config"
+	"github.com/DataDog/datadog-agent/pkg/logs/decoder"
+)
+
+func TestNDJSONFormatter(t *testing.T) {
+	mb := NewFormattedMessageBuffer(3, 1000, NewNDJSONFormatter())
+	source := config.NewLogSource("", &config.LogsConfig{})
+
+	assert.True(t, mb.TryAddMessage(newMessage([]byte(`{"message":"first"}`), source, "")))
+	assert.True(t, mb.TryAddMessage(newMessage([]byte("{\"message\":\n\"second\"}"), source, "")))
+	assert.True(t, mb.TryAddMessage(newMessage([]byte("{\"message\":\"third\"}\r\n"), source, "")))
+
+	payload := mb.GetPayload()
+	lines := bytes.Split(payload, []byte("\n"))
+	assert.Len(t, lines, 3)
+	assert.Equal(t, `{"message":"first"}`, string(lines[0]))
+	assert.Equal(t, `{"message":\n"second"}`, string(lines[1]))
+	assert.Equal(t, `{"message":"third"}\r\n`, string(lines[2]))
+
+	// the content of the messages is left untouched
+	assert.Equal(t, "{\"message\":\n\"second\"}", string(mb.GetMessages()[1].Content))
+}
+
+func TestNDJSONFormatterEscapesNewlinesInStrings(t *testing.T) {
+	mb := NewFormattedMessageBuffer(1, 1000, NewNDJSONFormatter())
+	source := config.NewLogSource("", &config.LogsConfig{})
+
+	// a raw line break inside a string is escaped into a valid JSON escape sequence
+	assert.True(t, mb.TryAddMessage(newMessage([]byte("{\"message\":\"a\nb\"}"), source, "")))
+
+	var decoded map[string]string
+	assert.Nil(t, json.Unmarshal(mb.GetPayload(), &decoded))
+	assert.Equal(t, "a\nb", decoded["message"])
+}
+
+func TestNDJSONFormatterAccountsForEscaping(t *testing.T) {
+	mb := NewFormattedMessageBuffer(2, 9, NewNDJSONFormatter())
+	source := config.NewLogSource("", &config.LogsConfig{})
+
+	// 8 bytes once escaped, there is no room left for the separator
+	assert.False(t, mb.TryAddMessage(newMessage([]byte("\n\n\n\n"), source, "")))
+	assert.True(t, mb.TryAddMessage(newMessage([]byte("\n\n\n\n"[:3]), source, "")))
+}
+
+func TestArrayFormatter(t *testing.T) {
+	mb := NewFormattedMessageBuffer(3, 1000, NewArrayFormatter([]byte(`{"logs":[`), []byte(", "), []byte("]}")))
+	source := config.NewLogSource("", &config.LogsConfig{})
+
+	assert.True(t, mb.TryAddMessage(newMessage([]byte(`"first"`), source, "")))
+	assert.True(t, mb.TryAddMessage(newMessage([]byte(`"second"`), source, "")))
+	assert.Equal(t, `{"logs":["first", "second"]}`, string(mb.GetPayload()))
+}
+
+func TestArrayFormatterDoesNotExceedMaxContentSize(t *testing.T) {
+	maxContentSize := 40
+	mb := NewFormattedMessageBuffer(100, maxContentSize, NewArrayFormatter([]byte(`{"logs":[`), []byte(", "), []byte("]}")))
+	source := config.NewLogSource("", &config.LogsConfig{})
+
+	for mb.TryAddMessage(newMessage([]byte(`"log"`), source, "")) {
+		assert.True(t, len(mb.GetPayload()) <= maxContentSize)
+		assert.Equal(t, len(mb.GetPayload()), mb.ContentSize())
+	}
+	assert.Len(t, mb.GetMessages(), 4)
+
+	mb.Clear()
+	content := make([]byte, mb.MaxContentSize())
+	assert.True(t, mb.TryAddMessage(newMessage(content, source, "")))
+	assert.True(t, len(mb.GetPayload()) <= maxContentSize)
+}
+
+func TestFormatterFrame(t *testing.T) {
+	assert.Equal(t, "[a]", string(NewJSONArrayFormatter().Frame([]byte("a"))))
+	assert.Equal(t, `a\nb`, string(NewNDJSONFormatter().Frame([]byte("a\nb"))))
+}
+
+func TestFormatterTruncatesTheJSONMessage(t *testing.T) {
+	content := []byte(`{"message":"aébcdefghijklmnopqrstuvwxyzabcdefghijklmnopqrstuvwxyz","status":"info"}`)
+
+	for _, max := range []int{len(content) - 1, len(content) - 30, 45} {
+		truncated, ok := NewJSONArrayFormatter().truncate(content, max)
+		if assert.True(t, ok, "max %d", max) {
+			assert.True(t, len(truncated) <= max, "max %d", max)
+			var fields map[string]string
+			assert.NoError(t, json.Unmarshal(truncated, &fields))
+			assert.Equal(t, "info", fields["status"])
+			assert.True(t, utf8.ValidString(fields["message"]))
+			assert.True(t, strings.HasSuffix(fields["message"], string(decoder.TRUNCATED)))
+		}
+	}
+
+	// the message can not be emptied enough
+	_, ok := NewJSONArrayFormatter().truncate(content, 20)
+	assert.False(t, ok)
+	// the content is not a JSON object with a message
+	_, ok = NewJSONArrayFormatter().truncate([]byte(`aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa`), 40)
+	assert.False(t, ok)
+	_, ok = NewJSONArrayFormatter().truncate([]byte(`{"msg":"aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"}`), 40)
+	assert.False(t, ok)
+	// without Truncate, nothing is truncated
+	_, ok = (&Formatter{}).truncate(content, 40)
+	assert.False(t, ok)
+}
+
+func TestFormatterTruncatesWithinTheEscapedSize(t *testing.T) {
+	// every x doubles in size once escaped
+	formatter := &Formatter{
+		Escape:   func(content []byte) []byte { return bytes.Replace(content, []byte("x"), []byte("xx"), -1) },
+		Truncate: truncateJSONMessage,
+	}
+	content := []byte(`{"message":"xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx"}`)
+	truncated, ok := formatter.truncate(content, 60)
+	if assert.True(t, ok) {
+		assert.True(t, len(formatter.escape(truncated)) <= 60)
+	}
+}
@@ -9,26 +9,67 @@ import (
 	"github.com/DataDog/datadog-agent/pkg/logs/message"
 )
 
+// DropPolicy tells which messages are dropped when a new message does not fit in the buffer.
+type DropPolicy int
+
+const (
+	// DropNewest rejects the new message.
+	DropNewest DropPolicy = iota
+	// DropOldest evicts the oldest messages until the new message fits.
+	DropOldest
+)
+
 // MessageBuffer accumulates messages and the bytes for batch sending.
 type MessageBuffer struct {
-	messageBuffer []*message.Message
-	byteBuffer    []byte
+	messageBuffer  []*message.Message
+	messageSizes   []int
+	byteBuffer     []byte
+	maxRequestSize int
+	formatter      *Formatter
+	dropPolicy     DropPolicy
+	onDrop         func(m *message.Message)
+}
+
+// NewMessageBuffer returns a new MessageBuffer building JSON arrays
+// which drops messages according to the policy when full,
+// onDrop is called with every message evicted from the buffer and can be nil.
+func NewMessageBuffer(maxBatchCount, maxRequestSize int, policy DropPolicy, onDrop func(m *message.Message)) *MessageBuffer {
+	return newMessageBuffer(maxBatchCount, maxRequestSize, NewJSONArrayFormatter(), policy, onDrop)
 }
 
-// NewMessageBuffer returns a new MessageBuffer.
-func NewMessageBuffer(maxBatchCount, maxRequestSize int) *MessageBuffer {
+// NewFormattedMessageBuffer returns a new MessageBuffer building payloads with the formatter.
+func NewFormattedMessageBuffer(maxBatchCount, maxRequestSize int, formatter *Formatter) *MessageBuffer {
+	return newMessageBuffer(maxBatchCount, maxRequestSize, formatter, DropNewest, nil)
+}
+
+func newMessageBuffer(maxBatchCount, maxRequestSize int, formatter *Formatter, policy DropPolicy, onDrop func(m *message.Message)) *MessageBuffer {
+	byteBuffer := make([]byte, 0, maxRequestSize)
 	return &MessageBuffer{
-		messageBuffer: make([]*message.Message, 0, maxBatchCount),
-		byteBuffer:    make([]byte, 1, maxRequestSize),
+		messageBuffer:  make([]*message.Message, 0, maxBatchCount),
+		messageSizes:   make([]int, 0, maxBatchCount),
+		byteBuffer:     append(byteBuffer, formatter.Prefix...),
+		maxRequestSize: maxRequestSize,
+		formatter:      formatter,
+		dropPolicy:     policy,
+		onDrop:         onDrop,
 	}
 }
 
 // TryAddMessage attempts to add a new message,
 // returns false if it failed.
+// With the DropOldest policy, the oldest messages are evicted to make room for the new message,
+// which only fails when it would not fit in an empty buffer.
 func (mb *MessageBuffer) TryAddMessage(m *message.Message) bool {
-	if len(mb.messageBuffer) < cap(mb.messageBuffer) && mb.hasSpaceInByteBuffer(m.Content) {
+	content := mb.formatter.escape(m.Content)
+	if mb.dropPolicy == DropOldest && mb.fitsInEmptyBuffer(content) {
+		for !mb.hasSpace(content) {
+			mb.dropOldest()
+		}
+	}
+	if mb.hasSpace(content) {
 		mb.messageBuffer = append(mb.messageBuffer, m)
-		mb.appendByteBuffer(m.Content)
+		mb.messageSizes = append(mb.messageSizes, len(content)+len(mb.formatter.Separator))
+		mb.appendByteBuffer(content)
 		return true
 	}
 	return false
@@ -47,17 +88,41 @@ func (mb *MessageBuffer) IsFull() bool {
 // Clear removes all elements from the buffer.
 func (mb *MessageBuffer) Clear() {
 	mb.messageBuffer = mb.messageBuffer[:0]
-	mb.byteBuffer = mb.byteBuffer[:1] // keep the first byte, it's used for : '['
+	mb.messageSizes = mb.messageSizes[:0]
+	mb.byteBuffer = mb.byteBuffer[:len(mb.formatter.Prefix)] // keep the prefix
 }
 
-// GetPayload returns the concatanated messages in JSON encoded format.
+// Reset removes all elements from the buffer like Clear, and releases the references
+// to the messages so that they can be garbage collected, the capacity is retained.
+func (mb *MessageBuffer) Reset() {
+	for i := range mb.messageBuffer {
+		mb.messageBuffer[i] = nil
+	}
+	mb.Clear()
+}
+
+// MaxContentSize returns the maximum size of a message content
+// that can be added to an empty buffer.
+func (mb *MessageBuffer) MaxContentSize() int {
+	return mb.maxRequestSize - 1 - len(mb.formatter.Prefix) - mb.trailerSize()
+}
+
+// ContentSize returns the size in bytes of the payload built from the buffered messages.
+func (mb *MessageBuffer) ContentSize() int {
+	if len(mb.messageBuffer) == 0 {
+		return len(mb.byteBuffer)
+	}
+	return len(mb.byteBuffer) - len(mb.formatter.Separator) + len(mb.formatter.Suffix)
+}
+
+// GetPayload returns the concatenated messages framed by the formatter.
 func (mb *MessageBuffer) GetPayload() []byte {
-	// here we write the json '[' and ']'
-	mb.byteBuffer[0] = '['
-	if len(mb.messageBuffer) > 0 {
-		mb.byteBuffer[len(mb.byteBuffer)-1] = ']'
+	if len(mb.messageBuffer) == 0 {
+		return mb.byteBuffer
 	}
-	return mb.byteBuffer
+	// here we replace the last separator with the suffix
+	payload := mb.byteBuffer[:len(mb.byteBuffer)-len(mb.formatter.Separator)]
+	return append(payload, mb.formatter.Suffix...)
 }
 
 // GetMessages returns the buffered messages.
@@ -65,15 +130,66 @@ func (mb *MessageBuffer) GetMessages() []*message.Message {
 	return mb.messageBuffer
 }
 
+// hasSpace returns true if the content can be added to the buffer.
+func (mb *MessageBuffer) hasSpace(content []byte) bool {
+	return len(mb.messageBuffer) < cap(mb.messageBuffer) && mb.hasSpaceInByteBuffer(content)
+}
+
+// hasSpaceFor returns true if the message can be added to the buffer without evicting any message.
+func (mb *MessageBuffer) hasSpaceFor(m *message.Message) bool {
+	return mb.hasSpace(mb.formatter.escape(m.Content))
+}
+
+// Fits returns true if the message could be added to the buffer once emptied.
+func (mb *MessageBuffer) Fits(m *message.Message) bool {
+	return mb.fitsInEmptyBuffer(mb.formatter.escape(m.Content))
+}
+
+// fitsInEmptyBuffer returns true if the content could be added to the buffer once emptied.
+func (mb *MessageBuffer) fitsInEmptyBuffer(content []byte) bool {
+	return cap(mb.messageBuffer) > 0 && len(mb.formatter.Prefix)+len(content)+mb.trailerSize() < mb.maxRequestSize
+}
+
+// dropOldest evicts the oldest message from the buffer.
+func (mb *MessageBuffer) dropOldest() {
+	dropped := mb.messageBuffer[0]
+	size := mb.messageSizes[0]
+
+	last := len(mb.messageBuffer) - 1
+	copy(mb.messageBuffer, mb.messageBuffer[1:])
+	mb.messageBuffer[last] = nil
+	mb.messageBuffer = mb.messageBuffer[:last]
+	copy(mb.messageSizes, mb.messageSizes[1:])
+	mb.messageSizes = mb.messageSizes[:last]
+
+	// shift the remaining content right after the prefix
+	prefixSize := len(mb.formatter.Prefix)
+	n := copy(mb.byteBuffer[prefixSize:], mb.byteBuffer[prefixSize+size:])
+	mb.byteBuffer = mb.byteBuffer[:prefixSize+n]
+
+	if mb.onDrop != nil {
+		mb.onDrop(dropped)
+	}
+}
+
 // hasSpaceInByteBuffer returns if there is still some room in the buffer
 // for the content.
 func (mb *MessageBuffer) hasSpaceInByteBuffer(content []byte) bool {
-	return len(mb.byteBuffer)+len(content)+1 < cap(mb.byteBuffer)
+	return len(mb.byteBuffer)+len(content)+mb.trailerSize() < mb.maxRequestSize
+}
+
+// trailerSize returns the number of bytes written after each message,
+// either the separator or the suffix for the last one.
+func (mb *MessageBuffer) trailerSize() int {
+	if len(mb.formatter.Suffix) > len(mb.formatter.Separator) {
+		return len(mb.formatter.Suffix)
+	}
+	return len(mb.formatter.Separator)
 }
 
 // appendByteBuffer appends the content to the buffer.
 func (mb *MessageBuffer) appendByteBuffer(content []byte) {
 	// increase the slice length, TODO can optimized this by not using append
 	mb.byteBuffer = append(mb.byteBuffer, content...)
-	mb.byteBuffer = append(mb.byteBuffer, ',')
+	mb.byteBuffer = append(mb.byteBuffer, mb.formatter.Separator...)
 }
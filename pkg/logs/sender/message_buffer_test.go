@@ -9,11 +9,12 @@ import (
 	"testing"
 
 	"github.com/DataDog/datadog-agent/pkg/logs/config"
+	"github.com/DataDog/datadog-agent/pkg/logs/message"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestMessageBufferRequestSize(t *testing.T) {
-	mb := NewMessageBuffer(2, 1000)
+	mb := NewMessageBuffer(2, 1000, DropNewest, nil)
 	source := config.NewLogSource("", &config.LogsConfig{})
 	//Add a first message lower than request size, should append and not trigger a send yet
 	success := mb.TryAddMessage(newMessage(make([]byte, 500), source, ""))
@@ -28,7 +29,7 @@ func TestMessageBufferRequestSize(t *testing.T) {
 }
 
 func TestMessageBufferBatchCount(t *testing.T) {
-	mb := NewMessageBuffer(2, 1000)
+	mb := NewMessageBuffer(2, 1000, DropNewest, nil)
 	source := config.NewLogSource("", &config.LogsConfig{})
 	//Add a first message lower than request size, should append
 	success := mb.TryAddMessage(newMessage(make([]byte, 10), source, ""))
@@ -46,7 +47,7 @@ func TestMessageBufferBatchCount(t *testing.T) {
 }
 
 func TestMessageBufferPayload(t *testing.T) {
-	mb := NewMessageBuffer(2, 1000)
+	mb := NewMessageBuffer(2, 1000, DropNewest, nil)
 	source := config.NewLogSource("", &config.LogsConfig{})
 	buffer := string(mb.GetPayload())
 	assert.Equal(t, "[", buffer)
@@ -63,8 +64,18 @@ func TestMessageBufferPayload(t *testing.T) {
 	assert.Equal(t, "[messagebuffer,messagebuffer]", buffer)
 }
 
+func TestMessageBufferContentSize(t *testing.T) {
+	mb := NewMessageBuffer(2, 1000, DropNewest, nil)
+	source := config.NewLogSource("", &config.LogsConfig{})
+	assert.Equal(t, len(mb.GetPayload()), mb.ContentSize())
+	mb.TryAddMessage(newMessage([]byte("messagebuffer"), source, ""))
+	assert.Equal(t, len(mb.GetPayload()), mb.ContentSize())
+	mb.TryAddMessage(newMessage([]byte("messagebuffer"), source, ""))
+	assert.Equal(t, len(mb.GetPayload()), mb.ContentSize())
+}
+
 func TestMessageBufferIsFullEmpty(t *testing.T) {
-	mb := NewMessageBuffer(2, 1000)
+	mb := NewMessageBuffer(2, 1000, DropNewest, nil)
 	assert.True(t, mb.IsEmpty())
 	assert.False(t, mb.IsFull())
 	source := config.NewLogSource("", &config.LogsConfig{})
@@ -77,7 +88,7 @@ func TestMessageBufferIsFullEmpty(t *testing.T) {
 }
 
 func TestMessageBufferGetMessages(t *testing.T) {
-	mb := NewMessageBuffer(2, 1000)
+	mb := NewMessageBuffer(2, 1000, DropNewest, nil)
 	source := config.NewLogSource("", &config.LogsConfig{})
 	msgs := mb.GetMessages()
 	assert.Equal(t, 0, len(msgs))
@@ -88,3 +99,77 @@ func TestMessageBufferGetMessages(t *testing.T) {
 	assert.Equal(t, m1, msgs[0])
 
 }
+
+func TestMessageBufferDropNewest(t *testing.T) {
+	var dropped []*message.Message
+	mb := NewMessageBuffer(2, 1000, DropNewest, func(m *message.Message) {
+		dropped = append(dropped, m)
+	})
+	source := config.NewLogSource("", &config.LogsConfig{})
+	assert.True(t, mb.TryAddMessage(newMessage([]byte("a"), source, "")))
+	assert.True(t, mb.TryAddMessage(newMessage([]byte("b"), source, "")))
+	// the buffer is full, the new message is rejected
+	assert.False(t, mb.TryAddMessage(newMessage([]byte("c"), source, "")))
+	assert.Equal(t, "[a,b]", string(mb.GetPayload()))
+	assert.Len(t, dropped, 0)
+}
+
+func TestMessageBufferDropOldestBatchCount(t *testing.T) {
+	var dropped []*message.Message
+	mb := NewMessageBuffer(2, 1000, DropOldest, func(m *message.Message) {
+		dropped = append(dropped, m)
+	})
+	source := config.NewLogSource("", &config.LogsConfig{})
+	m1 := newMessage([]byte("a"), source, "")
+	m2 := newMessage([]byte("b"), source, "")
+	m3 := newMessage([]byte("c"), source, "")
+	assert.True(t, mb.TryAddMessage(m1))
+	assert.True(t, mb.TryAddMessage(m2))
+	// the buffer is full, the oldest message is evicted
+	assert.True(t, mb.TryAddMessage(m3))
+	assert.Equal(t, "[b,c]", string(mb.GetPayload()))
+	assert.Equal(t, []*message.Message{m2, m3}, mb.GetMessages())
+	assert.Equal(t, []*message.Message{m1}, dropped)
+
+	mb.Clear()
+	assert.True(t, mb.TryAddMessage(m1))
+	assert.Equal(t, "[a]", string(mb.GetPayload()))
+}
+
+func TestMessageBufferDropOldestRequestSize(t *testing.T) {
+	var dropped []*message.Message
+	mb := NewMessageBuffer(10, 10, DropOldest, func(m *message.Message) {
+		dropped = append(dropped, m)
+	})
+	source := config.NewLogSource("", &config.LogsConfig{})
+	m1 := newMessage([]byte("aa"), source, "")
+	m2 := newMessage([]byte("bb"), source, "")
+	m3 := newMessage([]byte("cccccc"), source, "")
+	assert.True(t, mb.TryAddMessage(m1))
+	assert.True(t, mb.TryAddMessage(m2))
+	// "[bb,cccccc]" would still exceed the request size, both messages are evicted
+	assert.True(t, mb.TryAddMessage(m3))
+	assert.Equal(t, "[cccccc]", string(mb.GetPayload()))
+	assert.Equal(t, []*message.Message{m1, m2}, dropped)
+
+	// a message that can not fit in an empty buffer does not evict anything
+	assert.False(t, mb.TryAddMessage(newMessage([]byte("dddddddd"), source, "")))
+	assert.Equal(t, "[cccccc]", string(mb.GetPayload()))
+	assert.Len(t, dropped, 2)
+}
+
+func TestMessageBufferReset(t *testing.T) {
+	mb := NewMessageBuffer(2, 1000, DropNewest, nil)
+	source := config.NewLogSource("", &config.LogsConfig{})
+	assert.True(t, mb.TryAddMessage(newMessage([]byte("a"), source, "")))
+	assert.True(t, mb.TryAddMessage(newMessage([]byte("b"), source, "")))
+
+	mb.Reset()
+	assert.True(t, mb.IsEmpty())
+	assert.Equal(t, 2, cap(mb.GetMessages()))
+	assert.Equal(t, []*message.Message{nil, nil}, mb.GetMessages()[:2])
+	assert.Equal(t, len("["), mb.ContentSize())
+
+	assert.True(t, mb.TryAddMessage(newMessage([]byte("c"), source, "")))
+	assert.Equal(t, "[c]", string(mb.GetPayload()))
+}
@@ -0,0 +1,74 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package sender
+
+import (
+	"sync"
+
+	"github.com/DataDog/datadog-agent/pkg/logs/message"
+)
+
+// messageRing is a bounded FIFO of messages which drops the oldest message when full.
+type messageRing struct {
+	mu       sync.Mutex
+	notEmpty *sync.Cond
+	messages []*message.Message
+	head     int
+	size     int
+	closed   bool
+}
+
+// newMessageRing returns a new messageRing holding at most size messages.
+func newMessageRing(size int) *messageRing {
+	r := &messageRing{
+		messages: make([]*message.Message, size),
+	}
+	r.notEmpty = sync.NewCond(&r.mu)
+	return r
+}
+
+// push adds a message without blocking, it returns true if the oldest message was dropped to make room for it.
+func (r *messageRing) push(m *message.Message) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	dropped := false
+	if r.size == len(r.messages) {
+		r.messages[r.head] = nil
+		r.head = (r.head + 1) % len(r.messages)
+		r.size--
+		dropped = true
+	}
+	r.messages[(r.head+r.size)%len(r.messages)] = m
+	r.size++
+	r.notEmpty.Signal()
+	return dropped
+}
+
+// pop removes and returns the oldest message, it blocks until a message is available
+// and returns false once the ring is closed and empty.
+func (r *messageRing) pop() (*message.Message, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for r.size == 0 {
+		if r.closed {
+			return nil, false
+		}
+		r.notEmpty.Wait()
+	}
+	m := r.messages[r.head]
+	r.messages[r.head] = nil
+	r.head = (r.head + 1) % len(r.messages)
+	r.size--
+	return m, true
+}
+
+// close lets pop return false once the remaining messages have been popped.
+func (r *messageRing) close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	r.notEmpty.Broadcast()
+}
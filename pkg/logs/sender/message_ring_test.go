@@ -0,0 +1,61 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package sender
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DataDog/datadog-agent/pkg/logs/config"
+	"github.com/DataDog/datadog-agent/pkg/logs/message"
+)
+
+func TestMessageRing(t *testing.T) {
+	source := config.NewLogSource("", &config.LogsConfig{})
+	m1 := newMessage([]byte("1"), source, "")
+	m2 := newMessage([]byte("2"), source, "")
+	m3 := newMessage([]byte("3"), source, "")
+	m4 := newMessage([]byte("4"), source, "")
+
+	r := newMessageRing(2)
+	assert.False(t, r.push(m1))
+	assert.False(t, r.push(m2))
+	// the ring is full, the oldest message is dropped
+	assert.True(t, r.push(m3))
+
+	m, ok := r.pop()
+	assert.True(t, ok)
+	assert.Equal(t, m2, m)
+
+	assert.False(t, r.push(m4))
+	r.close()
+
+	// the remaining messages are popped after the ring is closed
+	m, ok = r.pop()
+	assert.True(t, ok)
+	assert.Equal(t, m3, m)
+	m, ok = r.pop()
+	assert.True(t, ok)
+	assert.Equal(t, m4, m)
+	_, ok = r.pop()
+	assert.False(t, ok)
+}
+
+func TestMessageRingPopBlocksUntilPush(t *testing.T) {
+	source := config.NewLogSource("", &config.LogsConfig{})
+	r := newMessageRing(1)
+
+	popped := make(chan *message.Message)
+	go func() {
+		m, _ := r.pop()
+		popped <- m
+	}()
+
+	expected := newMessage([]byte("1"), source, "")
+	r.push(expected)
+	assert.Equal(t, expected, <-popped)
+}
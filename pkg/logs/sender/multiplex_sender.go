@@ -0,0 +1,177 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package sender
+
+import (
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+
+	"github.com/DataDog/datadog-agent/pkg/logs/client"
+	"github.com/DataDog/datadog-agent/pkg/logs/message"
+)
+
+const defaultIdleTimeout = 1 * time.Minute
+
+// KeyFunc returns the key grouping a message with the other messages sent in the same payloads.
+type KeyFunc func(m *message.Message) string
+
+// SourceKey groups the messages by log source.
+func SourceKey(m *message.Message) string {
+	if m.Origin == nil || m.Origin.LogSource == nil {
+		return ""
+	}
+	return m.Origin.LogSource.Name
+}
+
+// MultiplexSender batches the messages in separate payloads per key,
+// each key has its own BatchSender flushing independently.
+type MultiplexSender struct {
+	inputChan    chan *message.Message
+	outputChan   chan *message.Message
+	destinations *client.Destinations
+	config       BatchConfig
+	key          KeyFunc
+	idleTimeout  time.Duration
+	senders      map[string]*keyedSender
+	heartbeat    *BatchSender
+	stopping     sync.WaitGroup
+	clock        clock
+	done         chan struct{}
+}
+
+// keyedSender is the BatchSender of a key.
+type keyedSender struct {
+	inputChan chan *message.Message
+	sender    *BatchSender
+	lastUsed  time.Time
+}
+
+// NewMultiplexSender returns a new MultiplexSender, a nil key groups the messages by log source.
+// The BatchSender of a key is stopped, and its buffer sent, when it did not receive any message
+// for idleTimeout, zero falls back to the default.
+// The rate limiter and the sampler of the config are shared by the keys, so that they apply to all
+// the messages, they must be safe for concurrent use. A single heartbeat is sent for all the keys
+// every batch timeout, and the write-ahead log is not supported.
+func NewMultiplexSender(inputChan, outputChan chan *message.Message, destinations *client.Destinations, config BatchConfig, key KeyFunc, idleTimeout time.Duration) *MultiplexSender {
+	if key == nil {
+		key = SourceKey
+	}
+	if idleTimeout <= 0 {
+		idleTimeout = defaultIdleTimeout
+	}
+	if config.WALPath != "" {
+		// the senders of all the keys would recover and truncate the same log
+		log.Warnf("The write-ahead log is not supported when batching per key, the messages are only buffered in memory")
+		config.WALPath = ""
+	}
+	var heartbeat *BatchSender
+	if config.Heartbeat != nil {
+		// the sender of the heartbeats never receives any message
+		heartbeat = NewBatchSender(make(chan *message.Message), outputChan, destinations, config)
+		config.Heartbeat = nil
+	}
+	return &MultiplexSender{
+		inputChan:    inputChan,
+		outputChan:   outputChan,
+		destinations: destinations,
+		config:       config,
+		key:          key,
+		idleTimeout:  idleTimeout,
+		senders:      make(map[string]*keyedSender),
+		heartbeat:    heartbeat,
+		clock:        realClock{},
+		done:         make(chan struct{}),
+	}
+}
+
+// Start starts the MultiplexSender
+func (s *MultiplexSender) Start() {
+	if s.heartbeat != nil {
+		s.heartbeat.clock = s.clock
+		s.heartbeat.Start()
+	}
+	go s.run()
+}
+
+// Stop stops the MultiplexSender,
+// this call blocks until inputChan and the buffers of all keys are flushed
+func (s *MultiplexSender) Stop() {
+	close(s.inputChan)
+	<-s.done
+}
+
+// run dispatches the messages to the sender of their key and stops the idle ones.
+func (s *MultiplexSender) run() {
+	reapTimer := s.clock.NewTimer(s.idleTimeout)
+	defer func() {
+		reapTimer.Stop()
+		for key, sender := range s.senders {
+			s.stop(sender.sender)
+			delete(s.senders, key)
+		}
+		if s.heartbeat != nil {
+			s.stop(s.heartbeat)
+		}
+		s.stopping.Wait()
+		s.done <- struct{}{}
+	}()
+
+	for {
+		select {
+		case payload, isOpen := <-s.inputChan:
+			if !isOpen {
+				// inputChan has been closed, no more payload are expected
+				return
+			}
+			sender := s.sender(s.key(payload))
+			sender.lastUsed = s.clock.Now()
+			sender.inputChan <- payload
+		case <-reapTimer.C():
+			s.reap()
+			reapTimer.Reset(s.idleTimeout)
+		}
+	}
+}
+
+// sender returns the sender of the key, starting it if needed.
+func (s *MultiplexSender) sender(key string) *keyedSender {
+	sender, exists := s.senders[key]
+	if !exists {
+		inputChan := make(chan *message.Message)
+		sender = &keyedSender{
+			inputChan: inputChan,
+			sender:    NewBatchSender(inputChan, s.outputChan, s.destinations, s.config),
+		}
+		sender.sender.clock = s.clock
+		sender.sender.Start()
+		s.senders[key] = sender
+	}
+	return sender
+}
+
+// reap stops the senders that did not receive any message for the idle timeout
+// to bound the memory used by the keys that are not seen anymore.
+func (s *MultiplexSender) reap() {
+	now := s.clock.Now()
+	for key, sender := range s.senders {
+		if now.Sub(sender.lastUsed) >= s.idleTimeout {
+			s.stop(sender.sender)
+			delete(s.senders, key)
+		}
+	}
+}
+
+// stop stops the sender in the background, so that sending its buffer
+// does not hold the messages of the other keys back.
+func (s *MultiplexSender) stop(sender *BatchSender) {
+	s.stopping.Add(1)
+	go func() {
+		defer s.stopping.Done()
+		sender.Stop()
+	}()
+}
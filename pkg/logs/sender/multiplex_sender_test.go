@@ -0,0 +1,173 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package sender
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DataDog/datadog-agent/pkg/logs/client"
+	"github.com/DataDog/datadog-agent/pkg/logs/config"
+	"github.com/DataDog/datadog-agent/pkg/logs/message"
+)
+
+func TestMultiplexSenderBatchesPerSource(t *testing.T) {
+	input := make(chan *message.Message, 4)
+	output := make(chan *message.Message, 4)
+	destination := newMockDestination(nil)
+
+	sender := NewMultiplexSender(input, output, client.NewDestinations(destination, nil), BatchConfig{MaxBatchSize: 2}, nil, 0)
+	sender.Start()
+
+	sourceA := config.NewLogSource("a", &config.LogsConfig{})
+	sourceB := config.NewLogSource("b", &config.LogsConfig{})
+	input <- newMessage([]byte("a1"), sourceA, "")
+	input <- newMessage([]byte("b1"), sourceB, "")
+	input <- newMessage([]byte("a2"), sourceA, "")
+	input <- newMessage([]byte("b2"), sourceB, "")
+
+	payloads := []string{string(<-destination.payloads), string(<-destination.payloads)}
+	assert.ElementsMatch(t, []string{"[a1,a2]", "[b1,b2]"}, payloads)
+
+	sender.Stop()
+	assert.Len(t, output, 4)
+}
+
+func TestMultiplexSenderWithKey(t *testing.T) {
+	input := make(chan *message.Message, 3)
+	output := make(chan *message.Message, 3)
+	destination := newMockDestination(nil)
+
+	key := func(m *message.Message) string {
+		return string(m.Content[:1])
+	}
+	sender := NewMultiplexSender(input, output, client.NewDestinations(destination, nil), BatchConfig{MaxBatchSize: 2, BatchTimeout: time.Hour}, key, 0)
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	input <- newMessage([]byte("x1"), source, "")
+	input <- newMessage([]byte("y1"), source, "")
+	input <- newMessage([]byte("x2"), source, "")
+
+	assert.Equal(t, "[x1,x2]", string(<-destination.payloads))
+
+	// the other buffers are sent on stop
+	sender.Stop()
+	assert.Equal(t, "[y1]", string(<-destination.payloads))
+	assert.Len(t, output, 3)
+}
+
+func TestMultiplexSenderReapsIdleSenders(t *testing.T) {
+	input := make(chan *message.Message, 1)
+	output := make(chan *message.Message, 1)
+	destination := newMockDestination(nil)
+
+	sender := NewMultiplexSender(input, output, client.NewDestinations(destination, nil), BatchConfig{BatchTimeout: time.Hour}, nil, time.Minute)
+	clock := newFakeClock()
+	sender.clock = clock
+	sender.Start()
+
+	source := config.NewLogSource("a", &config.LogsConfig{})
+	input <- newMessage([]byte("a"), source, "")
+	// the timers of the MultiplexSender and of the sender of the key
+	for clock.timerCount() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	waitForRead(input)
+
+	// the buffer is only sent when the idle sender is stopped
+	for len(destination.payloads) == 0 {
+		clock.Advance(time.Minute)
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, "[a]", string(<-destination.payloads))
+	<-output
+
+	sender.Stop()
+	assert.Len(t, destination.payloads, 0)
+}
+
+// blockingDestination blocks the sends of a payload until it is released.
+type blockingDestination struct {
+	*mockDestination
+	payload string
+	blocked chan struct{}
+	release chan struct{}
+}
+
+func (d *blockingDestination) Send(payload []byte) error {
+	if string(payload) == d.payload {
+		close(d.blocked)
+		<-d.release
+	}
+	return d.mockDestination.Send(payload)
+}
+
+func TestMultiplexSenderStopsIdleSendersInTheBackground(t *testing.T) {
+	input := make(chan *message.Message, 1)
+	output := make(chan *message.Message, 2)
+	destination := &blockingDestination{
+		mockDestination: newMockDestination(nil),
+		payload:         "[a]",
+		blocked:         make(chan struct{}),
+		release:         make(chan struct{}),
+	}
+
+	sender := NewMultiplexSender(input, output, client.NewDestinations(destination, nil), BatchConfig{MaxBatchSize: 1, BatchTimeout: time.Hour}, nil, time.Minute)
+	clock := newFakeClock()
+	sender.clock = clock
+	sender.Start()
+
+	input <- newMessage([]byte("a"), config.NewLogSource("a", &config.LogsConfig{}), "")
+	<-destination.blocked
+
+	// the sender of a is reaped while its send hangs
+	clock.Advance(time.Minute)
+	time.Sleep(10 * time.Millisecond)
+
+	input <- newMessage([]byte("b"), config.NewLogSource("b", &config.LogsConfig{}), "")
+	assert.Equal(t, "[b]", string(<-destination.payloads))
+
+	close(destination.release)
+	sender.Stop()
+	assert.Equal(t, "[a]", string(<-destination.payloads))
+	assert.Len(t, output, 2)
+}
+
+func TestMultiplexSenderSendsASingleHeartbeat(t *testing.T) {
+	input := make(chan *message.Message, 2)
+	output := make(chan *message.Message, 2)
+	destination := newMockDestination(nil)
+
+	heartbeat := func() []byte { return []byte("heartbeat") }
+	sender := NewMultiplexSender(input, output, client.NewDestinations(destination, nil), BatchConfig{MaxBatchSize: 1, BatchTimeout: time.Minute, Heartbeat: heartbeat}, nil, time.Hour)
+	clock := newFakeClock()
+	sender.clock = clock
+	sender.Start()
+
+	input <- newMessage([]byte("a"), config.NewLogSource("a", &config.LogsConfig{}), "")
+	input <- newMessage([]byte("b"), config.NewLogSource("b", &config.LogsConfig{}), "")
+	payloads := []string{string(<-destination.payloads), string(<-destination.payloads)}
+	assert.ElementsMatch(t, []string{"[a]", "[b]"}, payloads)
+
+	// the timers of the MultiplexSender, of the heartbeats and of the senders of the keys
+	for clock.timerCount() < 4 {
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(time.Minute)
+	assert.Equal(t, "heartbeat", string(<-destination.payloads))
+	time.Sleep(10 * time.Millisecond)
+	assert.Len(t, destination.payloads, 0)
+
+	sender.Stop()
+}
+
+func TestMultiplexSenderDisablesTheWAL(t *testing.T) {
+	sender := NewMultiplexSender(nil, nil, nil, BatchConfig{WALPath: "logs.wal"}, nil, 0)
+	assert.Equal(t, "", sender.config.WALPath)
+}
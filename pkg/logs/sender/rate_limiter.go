@@ -0,0 +1,91 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package sender
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter limits the number of payloads sent per second.
+type RateLimiter interface {
+	// Allow returns true and consumes a token if a payload can be sent now.
+	Allow() bool
+	// Wait blocks until a payload can be sent and consumes a token.
+	Wait()
+}
+
+// TokenBucket is a RateLimiter that allows bursts of up to burst payloads
+// and refills at rate payloads per second, it is safe for concurrent use.
+type TokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+	sleep  func(time.Duration)
+}
+
+// NewTokenBucket returns a new TokenBucket, starting full.
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	return newTokenBucket(rate, burst, time.Now, time.Sleep)
+}
+
+// newTokenBucket returns a new TokenBucket relying on the given clock.
+func newTokenBucket(rate float64, burst int, now func() time.Time, sleep func(time.Duration)) *TokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &TokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   now(),
+		now:    now,
+		sleep:  sleep,
+	}
+}
+
+// Allow returns true and consumes a token if one is available.
+func (tb *TokenBucket) Allow() bool {
+	allowed, _ := tb.take()
+	return allowed
+}
+
+// Wait blocks until a token is available and consumes it.
+func (tb *TokenBucket) Wait() {
+	for {
+		allowed, missing := tb.take()
+		if allowed {
+			return
+		}
+		// sleep without holding the lock so that the other callers are not blocked
+		tb.sleep(time.Duration(missing / tb.rate * float64(time.Second)))
+	}
+}
+
+// take consumes a token if one is available, otherwise it returns the fraction of token missing.
+func (tb *TokenBucket) take() (bool, float64) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.refill()
+	if tb.tokens < 1 {
+		return false, 1 - tb.tokens
+	}
+	tb.tokens--
+	return true, 0
+}
+
+// refill adds the tokens accumulated since the last refill.
+func (tb *TokenBucket) refill() {
+	now := tb.now()
+	tb.tokens += now.Sub(tb.last).Seconds() * tb.rate
+	if tb.tokens > tb.burst {
+		tb.tokens = tb.burst
+	}
+	tb.last = now
+}
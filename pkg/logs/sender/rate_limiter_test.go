@@ -0,0 +1,68 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package sender
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	var slept time.Duration
+	clock := func() time.Time { return now }
+	sleep := func(d time.Duration) {
+		slept += d
+		now = now.Add(d)
+	}
+
+	tb := newTokenBucket(2, 2, clock, sleep)
+	assert.True(t, tb.Allow())
+	assert.True(t, tb.Allow())
+	assert.False(t, tb.Allow())
+
+	now = now.Add(500 * time.Millisecond)
+	assert.True(t, tb.Allow())
+	assert.False(t, tb.Allow())
+
+	// the bucket never holds more than burst tokens
+	now = now.Add(time.Hour)
+	assert.True(t, tb.Allow())
+	assert.True(t, tb.Allow())
+	assert.False(t, tb.Allow())
+
+	tb.Wait()
+	assert.Equal(t, 500*time.Millisecond, slept)
+	assert.False(t, tb.Allow())
+}
+
+func TestTokenBucketConcurrentUse(t *testing.T) {
+	tb := NewTokenBucket(0.001, 100)
+	allowed := make(chan bool, 200)
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				allowed <- tb.Allow()
+			}
+		}()
+	}
+	wg.Wait()
+	close(allowed)
+
+	count := 0
+	for ok := range allowed {
+		if ok {
+			count++
+		}
+	}
+	assert.Equal(t, 100, count)
+}
@@ -0,0 +1,47 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package sender
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// Sampler decides which messages are kept.
+type Sampler interface {
+	// Sample returns true if the next message should be kept.
+	Sample() bool
+}
+
+// RandomSampler is a Sampler keeping a random fraction of the messages,
+// it is safe for concurrent use.
+type RandomSampler struct {
+	mu     sync.Mutex
+	rate   float64
+	random *rand.Rand
+}
+
+// NewRandomSampler returns a new RandomSampler keeping rate of the messages,
+// 1 keeps all of them and 0 drops all of them. The random numbers are generated from seed.
+func NewRandomSampler(rate float64, seed int64) *RandomSampler {
+	return &RandomSampler{
+		rate:   rate,
+		random: rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Sample returns true if the next message should be kept.
+func (s *RandomSampler) Sample() bool {
+	if s.rate >= 1 {
+		return true
+	}
+	if s.rate <= 0 {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.random.Float64() < s.rate
+}
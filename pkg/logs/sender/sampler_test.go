@@ -0,0 +1,59 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package sender
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func sampleCount(s Sampler, n int) int {
+	kept := 0
+	for i := 0; i < n; i++ {
+		if s.Sample() {
+			kept++
+		}
+	}
+	return kept
+}
+
+func TestRandomSamplerKeepsAll(t *testing.T) {
+	assert.Equal(t, 1000, sampleCount(NewRandomSampler(1, 42), 1000))
+	assert.Equal(t, 1000, sampleCount(NewRandomSampler(1.5, 42), 1000))
+}
+
+func TestRandomSamplerDropsAll(t *testing.T) {
+	assert.Equal(t, 0, sampleCount(NewRandomSampler(0, 42), 1000))
+	assert.Equal(t, 0, sampleCount(NewRandomSampler(-1, 42), 1000))
+}
+
+func TestRandomSamplerRatio(t *testing.T) {
+	kept := sampleCount(NewRandomSampler(0.3, 42), 100000)
+	assert.InDelta(t, 30000, kept, 1000)
+}
+
+func TestRandomSamplerIsDeterministic(t *testing.T) {
+	s1 := NewRandomSampler(0.5, 42)
+	s2 := NewRandomSampler(0.5, 42)
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, s1.Sample(), s2.Sample())
+	}
+}
+
+func TestRandomSamplerConcurrentUse(t *testing.T) {
+	s := NewRandomSampler(0.5, 42)
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sampleCount(s, 1000)
+		}()
+	}
+	wg.Wait()
+}
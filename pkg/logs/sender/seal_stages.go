@@ -0,0 +1,82 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package sender
+
+// sealStage turns the payload of a batch into the one sent, the stages of a sender are applied in order
+// and every stage works on the payload returned by the previous one.
+type sealStage interface {
+	seal(pending *batch) error
+}
+
+// newSealStages returns the stages sealing the payloads: compression, encryption then signing,
+// the stages which are not configured are left out.
+func newSealStages(compressor Compressor, encryptor *Encryptor, signingKey []byte, clock clock) []sealStage {
+	var stages []sealStage
+	if compressor != nil {
+		stages = append(stages, &compressionStage{compressor: compressor, clock: clock})
+	}
+	if encryptor != nil {
+		stages = append(stages, &encryptionStage{encryptor: encryptor})
+	}
+	if len(signingKey) > 0 {
+		stages = append(stages, &signingStage{key: signingKey})
+	}
+	return stages
+}
+
+// seal returns a batch holding the payload sealed by the stages.
+func seal(stages []sealStage, payload []byte) (batch, error) {
+	sealed := batch{payload: payload}
+	for _, stage := range stages {
+		if err := stage.seal(&sealed); err != nil {
+			return batch{}, err
+		}
+	}
+	return sealed, nil
+}
+
+// compressionStage compresses the payloads and records the time spent compressing them.
+type compressionStage struct {
+	compressor Compressor
+	clock      clock
+}
+
+// seal compresses the payload, it is kept as is when compression does not make it smaller.
+func (s *compressionStage) seal(pending *batch) error {
+	// the size limits are enforced on the uncompressed content
+	// to make sure the payload does not exceed the intake limits once inflated.
+	start := s.clock.Now()
+	pending.payload, pending.contentEncoding = compress(s.compressor, pending.payload)
+	pending.compressDuration = s.clock.Now().Sub(start)
+	return nil
+}
+
+// encryptionStage encrypts the payloads.
+type encryptionStage struct {
+	encryptor *Encryptor
+}
+
+// seal encrypts the payload, the batch must be dropped when it fails so that the payload is never sent in clear.
+func (s *encryptionStage) seal(pending *batch) error {
+	encrypted, err := s.encryptor.Encrypt(pending.payload)
+	if err != nil {
+		return err
+	}
+	pending.payload = encrypted
+	pending.encryption = s.encryptor.Encryption()
+	return nil
+}
+
+// signingStage signs the payloads with HMAC-SHA256.
+type signingStage struct {
+	key []byte
+}
+
+// seal signs the payload as it is sent.
+func (s *signingStage) seal(pending *batch) error {
+	pending.signature = sign(s.key, pending.payload)
+	return nil
+}
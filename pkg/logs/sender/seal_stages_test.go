@@ -0,0 +1,66 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package sender
+
+import (
+	"bytes"
+	"compress/gzip"
+	"testing"
+
+	"github.com/DataDog/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSealStagesCompressThePayloads(t *testing.T) {
+	payload := bytes.Repeat([]byte("a"), 500)
+
+	sealed, err := seal(newSealStages(NewGzipCompressor(gzip.DefaultCompression), nil, nil, newFakeClock()), payload)
+	require.NoError(t, err)
+	assert.Equal(t, "gzip", sealed.contentEncoding)
+	assert.Equal(t, payload, gunzip(t, sealed.payload))
+
+	// the content encoding is the one of the compressor
+	sealed, err = seal(newSealStages(NewZstdCompressor(zstd.DefaultCompression), nil, nil, newFakeClock()), payload)
+	require.NoError(t, err)
+	assert.Equal(t, "zstd", sealed.contentEncoding)
+}
+
+func TestSealStagesAreAppliedInOrder(t *testing.T) {
+	encryptor, err := NewEncryptor(encryptionKey, nil)
+	require.NoError(t, err)
+	key := []byte("secret")
+	payload := bytes.Repeat([]byte("a"), 500)
+
+	sealed, err := seal(newSealStages(NewGzipCompressor(gzip.DefaultCompression), encryptor, key, newFakeClock()), payload)
+	require.NoError(t, err)
+
+	// the payload is compressed, then encrypted, then signed as it is sent
+	assert.Equal(t, "gzip", sealed.contentEncoding)
+	assert.Equal(t, encryptor.Encryption(), sealed.encryption)
+	assert.Equal(t, sign(key, sealed.payload), sealed.signature)
+	compressed, err := encryptor.Decrypt(sealed.payload)
+	require.NoError(t, err)
+	assert.Equal(t, payload, gunzip(t, compressed))
+}
+
+func TestSealStagesLeaveOutTheStagesNotConfigured(t *testing.T) {
+	stages := newSealStages(nil, nil, nil, newFakeClock())
+	assert.Len(t, stages, 0)
+
+	sealed, err := seal(stages, []byte("a"))
+	require.NoError(t, err)
+	assert.Equal(t, batch{payload: []byte("a")}, sealed)
+}
+
+func TestSealStagesStopOnError(t *testing.T) {
+	// the nonces can not be read
+	encryptor, err := NewEncryptor(encryptionKey, bytes.NewReader(nil))
+	require.NoError(t, err)
+
+	_, err = seal(newSealStages(nil, encryptor, []byte("secret"), newFakeClock()), []byte("a"))
+	assert.Error(t, err)
+}
@@ -5,8 +5,96 @@
 
 package sender
 
+import (
+	"errors"
+	"fmt"
+
+	"github.com/DataDog/datadog-agent/pkg/logs/client"
+	"github.com/DataDog/datadog-agent/pkg/logs/message"
+)
+
 // Sender sends logs to different destinations.
 type Sender interface {
 	Start()
 	Stop()
 }
+
+// Mode selects the Sender built by NewSender.
+type Mode string
+
+const (
+	// ModeBatch sends the messages in batches with a BatchSender.
+	ModeBatch Mode = "batch"
+	// ModeStream sends the messages one by one with a StreamSender.
+	ModeStream Mode = "stream"
+	// ModeFile writes the batches to rotating files with a FileSender.
+	ModeFile Mode = "file"
+)
+
+// Config holds the settings of the Sender built by NewSender,
+// only the settings of the mode selected are used.
+type Config struct {
+	// Mode selects the Sender, empty means ModeBatch.
+	Mode Mode
+	// Batch holds the settings of the batches, used by ModeBatch and ModeFile.
+	Batch BatchConfig
+	// Stream holds the settings of ModeStream.
+	Stream StreamConfig
+	// Dir is the directory the files are written to, required by ModeFile and only allowed with it.
+	Dir string
+	// MaxFileSize and MaxFiles bound the files written by ModeFile, zero means no limit.
+	MaxFileSize int64
+	MaxFiles    int
+}
+
+// NewSender returns the Sender selected by the mode of the config, an error is returned when the config
+// is invalid: ModeFile writes to Dir and takes no destinations, ModeStream sends to the destinations and
+// ModeBatch sends to the destinations or to the Batch.Transport when it is set.
+func NewSender(inputChan, outputChan chan *message.Message, destinations *client.Destinations, config Config) (Sender, error) {
+	mode := config.Mode
+	if mode == "" {
+		mode = ModeBatch
+	}
+	if err := config.validate(mode, destinations); err != nil {
+		return nil, err
+	}
+	switch mode {
+	case ModeStream:
+		return NewStreamSenderWithConfig(inputChan, outputChan, destinations, config.Stream), nil
+	case ModeFile:
+		fileSender, err := NewFileSender(inputChan, outputChan, config.Dir, config.MaxFileSize, config.MaxFiles, config.Batch)
+		if err != nil {
+			// do not return a nil *FileSender as a non-nil Sender
+			return nil, err
+		}
+		return fileSender, nil
+	default:
+		return NewBatchSender(inputChan, outputChan, destinations, config.Batch), nil
+	}
+}
+
+// validate returns an error when the settings do not fit the mode.
+func (c Config) validate(mode Mode, destinations *client.Destinations) error {
+	switch mode {
+	case ModeBatch, ModeStream:
+		if destinations == nil && (mode == ModeStream || c.Batch.Transport == nil) {
+			return fmt.Errorf("%s sender requires destinations", mode)
+		}
+		if c.Dir != "" {
+			return fmt.Errorf("%s sender does not write to a directory", mode)
+		}
+	case ModeFile:
+		if c.Dir == "" {
+			return errors.New("file sender requires a directory")
+		}
+		if destinations != nil {
+			return errors.New("file sender does not send to destinations")
+		}
+		if c.MaxFileSize < 0 || c.MaxFiles < 0 {
+			return fmt.Errorf("invalid file sender limits: max file size %d, max files %d", c.MaxFileSize, c.MaxFiles)
+		}
+	default:
+		return fmt.Errorf("unknown sender mode %q", mode)
+	}
+	return nil
+}
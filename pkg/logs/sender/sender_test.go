@@ -0,0 +1,87 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package sender
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/DataDog/datadog-agent/pkg/logs/client"
+	"github.com/DataDog/datadog-agent/pkg/logs/message"
+)
+
+func TestNewSenderBuildsTheSenderOfTheMode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logs-spool")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	destinations := client.NewDestinations(newMockDestination(nil), nil)
+	formatter := NewNDJSONFormatter()
+
+	s, err := NewSender(nil, nil, destinations, Config{Batch: BatchConfig{MaxBatchSize: 3, Formatter: formatter}})
+	require.NoError(t, err)
+	if assert.IsType(t, &BatchSender{}, s) {
+		assert.Equal(t, 3, cap(s.(*BatchSender).messageBuffer.GetMessages()))
+		assert.Equal(t, formatter, s.(*BatchSender).messageBuffer.formatter)
+	}
+
+	s, err = NewSender(nil, nil, destinations, Config{Mode: ModeStream, Stream: StreamConfig{Formatter: formatter}})
+	require.NoError(t, err)
+	if assert.IsType(t, &StreamSender{}, s) {
+		assert.Equal(t, formatter, s.(*StreamSender).formatter)
+	}
+
+	input := make(chan *message.Message)
+	s, err = NewSender(input, nil, nil, Config{Mode: ModeFile, Dir: filepath.Join(dir, "spool"), Batch: BatchConfig{Formatter: formatter}})
+	require.NoError(t, err)
+	if assert.IsType(t, &FileSender{}, s) {
+		assert.Equal(t, formatter, s.(*FileSender).messageBuffer.formatter)
+	}
+	s.Start()
+	s.Stop()
+
+	// a transport replaces the destinations of the batches
+	transport := client.SendFunc(func(payload []byte) error { return nil })
+	s, err = NewSender(nil, nil, nil, Config{Mode: ModeBatch, Batch: BatchConfig{Transport: transport}})
+	require.NoError(t, err)
+	if assert.IsType(t, &BatchSender{}, s) {
+		assert.NotNil(t, s.(*BatchSender).delivery.transport)
+	}
+}
+
+func TestNewSenderRejectsInvalidConfigs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logs-spool")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	// a file can not be used as the spool directory
+	notADir := filepath.Join(dir, "file")
+	require.NoError(t, ioutil.WriteFile(notADir, nil, 0644))
+
+	destinations := client.NewDestinations(newMockDestination(nil), nil)
+	for name, c := range map[string]struct {
+		destinations *client.Destinations
+		config       Config
+	}{
+		"unknown mode":               {destinations, Config{Mode: "carrier-pigeon"}},
+		"batch without destination":  {nil, Config{}},
+		"stream without destination": {nil, Config{Mode: ModeStream}},
+		"stream with a transport":    {nil, Config{Mode: ModeStream, Batch: BatchConfig{Transport: client.SendFunc(nil)}}},
+		"batch with a directory":     {destinations, Config{Dir: dir}},
+		"file without a directory":   {nil, Config{Mode: ModeFile}},
+		"file with destinations":     {destinations, Config{Mode: ModeFile, Dir: dir}},
+		"file with negative limits":  {nil, Config{Mode: ModeFile, Dir: dir, MaxFiles: -1}},
+		"file in a file":             {nil, Config{Mode: ModeFile, Dir: notADir}},
+	} {
+		s, err := NewSender(nil, nil, c.destinations, c.config)
+		assert.Error(t, err, name)
+		assert.Nil(t, s, name)
+	}
+}
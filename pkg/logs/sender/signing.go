@@ -0,0 +1,30 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package sender
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash/crc32"
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// sign returns the HMAC-SHA256 of the payload computed with key.
+func sign(key, payload []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// checksum returns the CRC-32C of the payload in big endian, it detects corrupted payloads
+// but unlike the signature it does not authenticate them.
+func checksum(payload []byte) []byte {
+	sum := make([]byte, crc32.Size)
+	binary.BigEndian.PutUint32(sum, crc32.Checksum(payload, castagnoli))
+	return sum
+}
@@ -7,27 +7,74 @@ package sender
 
 import (
 	"context"
+	"time"
 
 	"github.com/DataDog/datadog-agent/pkg/logs/client"
 	"github.com/DataDog/datadog-agent/pkg/logs/message"
 	"github.com/DataDog/datadog-agent/pkg/logs/metrics"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
 )
 
+// StreamConfig holds the settings of a StreamSender.
+type StreamConfig struct {
+	// Formatter frames every message into its own payload, nil means the content is sent as is.
+	Formatter *Formatter
+	// ErrorHandler is called with every message that could not be sent, nil means the error is logged.
+	// It is not called when the destination context is cancelled.
+	ErrorHandler ErrorHandler
+	// BackoffBase is the delay to wait before the first retry, zero means the messages are retried right away.
+	BackoffBase time.Duration
+	// BackoffFactor is the multiplier applied to the delay after each retry, zero means the default of the BatchSender.
+	BackoffFactor float64
+}
+
+// withDefaults returns the config with the invalid or missing backoff settings replaced by the defaults.
+func (c StreamConfig) withDefaults() StreamConfig {
+	if c.BackoffBase < 0 {
+		log.Warnf("Invalid backoff base %v, retrying right away", c.BackoffBase)
+		c.BackoffBase = 0
+	}
+	if c.BackoffFactor < 1 {
+		if c.BackoffFactor != 0 {
+			log.Warnf("Invalid backoff factor %v, using default %v", c.BackoffFactor, defaultBackoffFactor)
+		}
+		c.BackoffFactor = defaultBackoffFactor
+	}
+	return c
+}
+
 // StreamSender is responsible for sending logs to different destinations.
 type StreamSender struct {
 	inputChan    chan *message.Message
 	outputChan   chan *message.Message
 	destinations *client.Destinations
+	formatter    *Formatter
+	errorHandler ErrorHandler
+	backoff      backoffPolicy
+	clock        clock
 	done         chan struct{}
 }
 
 // NewStreamSender returns an new sender.
 func NewStreamSender(inputChan, outputChan chan *message.Message, destinations *client.Destinations) *StreamSender {
+	return NewStreamSenderWithConfig(inputChan, outputChan, destinations, StreamConfig{})
+}
+
+// NewStreamSenderWithConfig returns an new sender sending the messages one by one as configured.
+func NewStreamSenderWithConfig(inputChan, outputChan chan *message.Message, destinations *client.Destinations, config StreamConfig) *StreamSender {
+	config = config.withDefaults()
 	return &StreamSender{
 		inputChan:    inputChan,
 		outputChan:   outputChan,
 		destinations: destinations,
-		done:         make(chan struct{}),
+		formatter:    config.Formatter,
+		errorHandler: config.ErrorHandler,
+		backoff: backoffPolicy{
+			base:   config.BackoffBase,
+			factor: config.BackoffFactor,
+		},
+		clock: realClock{},
+		done:  make(chan struct{}),
 	}
 }
 
@@ -55,37 +102,47 @@ func (s *StreamSender) run() {
 
 // send keeps trying to send the message to the main destination until it succeeds
 // and try to send the message to the additional destinations only once.
+// The messages dropped are neither sent to the additional destinations nor counted as sent.
 func (s *StreamSender) send(payload *message.Message) {
-	for {
-		// this call is blocking until payload is sent (or the connection destination context cancelled)
-		err := s.destinations.Main.Send(payload.Content)
-		if err != nil {
-			if err == context.Canceled {
-				metrics.DestinationErrors.Add(1)
-				// the context was cancelled, agent is stopping non-gracefully.
-				// drop the message
-				break
-			}
-			switch err.(type) {
-			case *client.FramingError:
-				metrics.DestinationErrors.Add(1)
-				// the message can not be framed properly,
-				// drop the message
-				break
-			default:
-				metrics.DestinationErrors.Add(1)
-				// retry as the error can be related to network issues
-				continue
-			}
-		}
+	content := payload.Content
+	if s.formatter != nil {
+		content = s.formatter.Frame(content)
+	}
+	if s.sendToMain(content) {
 		for _, destination := range s.destinations.Additionals {
 			// send to a queue then send asynchronously for additional endpoints,
 			// it will drop messages if the queue is full
-			destination.SendAsync(payload.Content)
+			destination.SendAsync(content)
 		}
-
 		metrics.LogsSent.Add(1)
-		break
 	}
 	s.outputChan <- payload
 }
+
+// sendToMain sends the content to the main destination, retrying with an exponential backoff
+// on retryable errors, it returns false when the message was dropped.
+func (s *StreamSender) sendToMain(content []byte) bool {
+	for attempt := 1; ; attempt++ {
+		// this call is blocking until payload is sent (or the connection destination context cancelled)
+		err := s.destinations.Main.Send(content)
+		if err == nil {
+			return true
+		}
+		metrics.DestinationErrors.Add(1)
+		if err == context.Canceled {
+			// the context was cancelled, agent is stopping non-gracefully.
+			// drop the message
+			return false
+		}
+		if _, ok := err.(*client.FramingError); ok {
+			// the message can not be framed properly,
+			// drop the message
+			s.errorHandler.report(err, "Could not frame message", SendFailure{PayloadSize: len(content), MessageCount: 1, Attempts: attempt})
+			return false
+		}
+		// retry after a delay as the error can be related to network issues
+		if delay := s.backoff.delay(attempt); delay > 0 {
+			<-s.clock.After(delay)
+		}
+	}
+}
@@ -5,7 +5,9 @@
 package sender
 
 import (
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -14,6 +16,7 @@ import (
 	"github.com/DataDog/datadog-agent/pkg/logs/client/tcp"
 	"github.com/DataDog/datadog-agent/pkg/logs/config"
 	"github.com/DataDog/datadog-agent/pkg/logs/message"
+	"github.com/DataDog/datadog-agent/pkg/logs/metrics"
 )
 
 func newMessage(content []byte, source *config.LogSource, status string) *message.Message {
@@ -86,3 +89,102 @@ func TestSenderNotBlockedByAdditional(t *testing.T) {
 	sender.Stop()
 	destinationsCtx.Stop()
 }
+
+func TestStreamSenderSendsEveryMessageOnce(t *testing.T) {
+	input := make(chan *message.Message, 2)
+	output := make(chan *message.Message, 2)
+	destination := newMockDestination(nil)
+
+	sender := NewStreamSenderWithConfig(input, output, client.NewDestinations(destination, nil), StreamConfig{Formatter: NewJSONArrayFormatter()})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	a := newMessage([]byte("a"), source, "")
+	b := newMessage([]byte("b"), source, "")
+	input <- a
+	input <- b
+
+	assert.Equal(t, "[a]", string(<-destination.payloads))
+	assert.Equal(t, "[b]", string(<-destination.payloads))
+	assert.Equal(t, a, <-output)
+	assert.Equal(t, b, <-output)
+
+	sender.Stop()
+	assert.Equal(t, 2, destination.getAttempts())
+	assert.Equal(t, "a", string(a.Content))
+}
+
+// asyncDestination records the payloads sent asynchronously.
+type asyncDestination struct {
+	*mockDestination
+	async chan []byte
+}
+
+func (d *asyncDestination) SendAsync(payload []byte) {
+	d.async <- payload
+}
+
+func TestStreamSenderReportsFramingErrors(t *testing.T) {
+	input := make(chan *message.Message, 1)
+	output := make(chan *message.Message, 1)
+	errs := make(chan sendError, 1)
+	framingErr := client.NewFramingError(errors.New("framing error"))
+	destination := newMockDestination(nil, framingErr)
+	additional := &asyncDestination{mockDestination: newMockDestination(nil), async: make(chan []byte, 1)}
+	sent := metrics.LogsSent.Value()
+
+	sender := NewStreamSenderWithConfig(input, output, client.NewDestinations(destination, []client.Destination{additional}), StreamConfig{
+		ErrorHandler: func(err error, failure SendFailure) {
+			errs <- sendError{err, failure}
+		},
+	})
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	input <- newMessage([]byte("abc"), source, "")
+	assert.Equal(t, sendError{framingErr, SendFailure{PayloadSize: 3, MessageCount: 1, Attempts: 1}}, <-errs)
+	<-output
+
+	sender.Stop()
+	assert.Equal(t, 1, destination.getAttempts())
+	// the message dropped is neither sent to the additional destinations nor counted as sent
+	assert.Len(t, additional.async, 0)
+	assert.Equal(t, sent, metrics.LogsSent.Value())
+}
+
+func TestStreamSenderRetriesWithBackoff(t *testing.T) {
+	input := make(chan *message.Message, 1)
+	output := make(chan *message.Message, 1)
+	retryableErr := client.NewRetryableError(errors.New("server error"))
+	destination := newMockDestination(nil, retryableErr, retryableErr)
+	additional := &asyncDestination{mockDestination: newMockDestination(nil), async: make(chan []byte, 1)}
+	sent := metrics.LogsSent.Value()
+
+	sender := NewStreamSenderWithConfig(input, output, client.NewDestinations(destination, []client.Destination{additional}), StreamConfig{
+		BackoffBase: time.Minute,
+	})
+	clock := newFakeClock()
+	sender.clock = clock
+	sender.Start()
+
+	source := config.NewLogSource("", &config.LogsConfig{})
+	input <- newMessage([]byte("a"), source, "")
+
+	// the retries wait for the backoff delays
+	for clock.timerCount() < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, 1, destination.getAttempts())
+	clock.Advance(time.Minute)
+	for clock.timerCount() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, 2, destination.getAttempts())
+	clock.Advance(2 * time.Minute)
+
+	assert.Equal(t, "a", string(<-destination.payloads))
+	assert.Equal(t, "a", string(<-additional.async))
+	<-output
+	sender.Stop()
+	assert.Equal(t, sent+1, metrics.LogsSent.Value())
+}
@@ -0,0 +1,56 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package sender
+
+import (
+	"encoding/json"
+	"unicode/utf8"
+
+	"github.com/DataDog/datadog-agent/pkg/logs/decoder"
+)
+
+// truncateJSONMessage shortens the message field of a JSON object built by the processor so that
+// the object is at most max bytes long, the message is cut on a character boundary and flagged with
+// decoder.TRUNCATED. It returns false when the content is not a JSON object with a message string,
+// or when the object does not fit even with an empty message.
+func truncateJSONMessage(content []byte, max int) ([]byte, bool) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(content, &fields); err != nil {
+		return nil, false
+	}
+	var text string
+	if err := json.Unmarshal(fields["message"], &text); err != nil {
+		return nil, false
+	}
+
+	// escaping may make the encoded message longer than the text,
+	// cut the excess of every attempt until the object fits
+	keep := len(text)
+	for {
+		for keep > 0 && keep < len(text) && !utf8.RuneStart(text[keep]) {
+			keep--
+		}
+		message, err := json.Marshal(text[:keep] + string(decoder.TRUNCATED))
+		if err != nil {
+			return nil, false
+		}
+		fields["message"] = message
+		truncated, err := json.Marshal(fields)
+		if err != nil {
+			return nil, false
+		}
+		if len(truncated) <= max {
+			return truncated, true
+		}
+		if keep == 0 {
+			return nil, false
+		}
+		keep -= len(truncated) - max
+		if keep < 0 {
+			keep = 0
+		}
+	}
+}
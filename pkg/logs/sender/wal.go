@@ -0,0 +1,102 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+package sender
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/DataDog/datadog-agent/pkg/logs/client/file"
+	"github.com/DataDog/datadog-agent/pkg/logs/message"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+// errInvalidWALEntry is returned when a record of the write-ahead log is not a message.
+var errInvalidWALEntry = errors.New("invalid write-ahead log entry")
+
+// openWAL opens the write-ahead log at path and returns the messages it still holds,
+// no write-ahead log is used when it can not be opened.
+func openWAL(path string, maxSize int64) (*file.WAL, []*message.Message) {
+	wal, err := file.OpenWAL(path, maxSize)
+	if err != nil {
+		log.Warnf("Could not open write-ahead log %s, messages will not be recovered after a restart: %v", path, err)
+		return nil, nil
+	}
+	records, err := wal.Records()
+	if err != nil {
+		log.Warnf("Could not read the whole write-ahead log %s, recovering %d messages: %v", path, len(records), err)
+	}
+	var messages []*message.Message
+	for _, record := range records {
+		m, err := decodeWALEntry(record)
+		if err != nil {
+			log.Warnf("Skipping message of write-ahead log %s: %v", path, err)
+			continue
+		}
+		messages = append(messages, m)
+	}
+	if len(messages) > 0 {
+		log.Infof("Recovered %d messages from write-ahead log %s", len(messages), path)
+	}
+	return wal, messages
+}
+
+// encodeWALEntry returns the record of the message in the write-ahead log:
+// the identifier and the offset of its origin, its status then its content,
+// the strings being prefixed by their length as big endian uint16.
+// Only the fields needed downstream of the sender are kept.
+func encodeWALEntry(m *message.Message) []byte {
+	var identifier, offset string
+	if m.Origin != nil {
+		identifier, offset = m.Origin.Identifier, m.Origin.Offset
+	}
+	status := m.GetStatus()
+	entry := make([]byte, 0, 3*2+len(identifier)+len(offset)+len(status)+len(m.Content))
+	entry = appendWALString(entry, identifier)
+	entry = appendWALString(entry, offset)
+	entry = appendWALString(entry, status)
+	return append(entry, m.Content...)
+}
+
+// decodeWALEntry returns the message of a record of the write-ahead log,
+// its origin has no log source.
+func decodeWALEntry(entry []byte) (*message.Message, error) {
+	var identifier, offset, status string
+	var ok bool
+	if identifier, entry, ok = readWALString(entry); !ok {
+		return nil, errInvalidWALEntry
+	}
+	if offset, entry, ok = readWALString(entry); !ok {
+		return nil, errInvalidWALEntry
+	}
+	if status, entry, ok = readWALString(entry); !ok {
+		return nil, errInvalidWALEntry
+	}
+	origin := &message.Origin{
+		Identifier: identifier,
+		Offset:     offset,
+	}
+	return message.NewMessage(append([]byte(nil), entry...), origin, status), nil
+}
+
+func appendWALString(entry []byte, s string) []byte {
+	var length [2]byte
+	binary.BigEndian.PutUint16(length[:], uint16(len(s)))
+	entry = append(entry, length[:]...)
+	return append(entry, s...)
+}
+
+func readWALString(entry []byte) (string, []byte, bool) {
+	if len(entry) < 2 {
+		return "", nil, false
+	}
+	length := int(binary.BigEndian.Uint16(entry))
+	entry = entry[2:]
+	if len(entry) < length {
+		return "", nil, false
+	}
+	return string(entry[:length]), entry[length:], true
+}
@@ -0,0 +1,38 @@
+package checks
+
+import (
+	"github.com/DataDog/datadog-agent/pkg/ebpf"
+)
+
+// protobufConnectionEncoder encodes the connections formatted like the ConnectionsCheck
+type protobufConnectionEncoder struct {
+	buf []byte
+}
+
+// NewProtobufConnectionEncoder returns an ebpf.ConnectionEncoder building the protobuf encoding of a
+// model.CollectorConnections holding only the connections, formatted like the ConnectionsCheck.
+// The connections whose addresses are not strings are skipped.
+func NewProtobufConnectionEncoder() ebpf.ConnectionEncoder {
+	return &protobufConnectionEncoder{}
+}
+
+// Add appends the connection to the document
+func (e *protobufConnectionEncoder) Add(conn ebpf.ConnectionStats) error {
+	// default creation time to ensure network connections from short-lived processes are not dropped
+	createTime := Process.createTimesforPIDs([]uint32{conn.Pid})[conn.Pid]
+	cx, ok := formatConnection(conn, createTime, ConnectionFormatOptions{})
+	if !ok {
+		return nil
+	}
+
+	var err error
+	e.buf, err = appendConnectionProtobuf(e.buf, cx)
+	return err
+}
+
+// Finish returns the document and starts a new one
+func (e *protobufConnectionEncoder) Finish() ([]byte, error) {
+	data := e.buf
+	e.buf = nil
+	return data, nil
+}
@@ -0,0 +1,53 @@
+package checks
+
+import (
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/ebpf"
+	"github.com/DataDog/datadog-agent/pkg/process/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProtobufConnectionEncoder(t *testing.T) {
+	conns := []ebpf.ConnectionStats{
+		{Pid: 1, Source: "10.0.0.1", Dest: "10.0.0.2", SPort: 4242, DPort: 443, MonotonicSentBytes: 10},
+		{Pid: 2, Source: "10.0.0.1", Dest: "10.0.0.3", SPort: 4244, DPort: 53, Type: ebpf.UDP},
+		{Pid: 3, Source: "10.0.0.1", Dest: "10.0.0.4", SPort: 4245, DPort: 80, Direction: ebpf.OUTGOING},
+	}
+	enc := NewProtobufConnectionEncoder()
+
+	for _, c := range conns[:2] {
+		require.NoError(t, enc.Add(c))
+	}
+	data, err := enc.Finish()
+	require.NoError(t, err)
+	var decoded model.CollectorConnections
+	require.NoError(t, decoded.Unmarshal(data))
+	assert.Equal(t, FormatConnectionsFunc(conns[:2], nil), decoded.Connections)
+
+	// the next connections start a new document
+	require.NoError(t, enc.Add(conns[2]))
+	data, err = enc.Finish()
+	require.NoError(t, err)
+	decoded = model.CollectorConnections{}
+	require.NoError(t, decoded.Unmarshal(data))
+	assert.Equal(t, FormatConnectionsFunc(conns[2:], nil), decoded.Connections)
+
+	data, err = enc.Finish()
+	require.NoError(t, err)
+	assert.Empty(t, data)
+}
+
+func TestProtobufConnectionEncoderSkipsInvalidAddresses(t *testing.T) {
+	enc := NewProtobufConnectionEncoder()
+	require.NoError(t, enc.Add(ebpf.ConnectionStats{Pid: 1, Source: 42, Dest: "10.0.0.2"}))
+	require.NoError(t, enc.Add(ebpf.ConnectionStats{Pid: 2, Source: "10.0.0.1", Dest: "10.0.0.2"}))
+
+	data, err := enc.Finish()
+	require.NoError(t, err)
+	var decoded model.CollectorConnections
+	require.NoError(t, decoded.Unmarshal(data))
+	require.Len(t, decoded.Connections, 1)
+	assert.Equal(t, int32(2), decoded.Connections[0].Pid)
+}
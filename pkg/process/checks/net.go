@@ -1,8 +1,10 @@
 package checks
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	stdnet "net"
 	"os"
 	"time"
 
@@ -11,6 +13,7 @@ import (
 	"github.com/DataDog/datadog-agent/pkg/process/config"
 	"github.com/DataDog/datadog-agent/pkg/process/model"
 	"github.com/DataDog/datadog-agent/pkg/process/net"
+	"github.com/DataDog/datadog-agent/pkg/process/util"
 	"github.com/DataDog/datadog-agent/pkg/util/log"
 )
 
@@ -20,6 +23,10 @@ var (
 
 	// ErrTracerStillNotInitialized signals that the tracer is _still_ not ready, so we shouldn't log additional errors
 	ErrTracerStillNotInitialized = errors.New("remote tracer is still not initialized")
+
+	// ErrEmptyConnectionsPayload is returned by DecodeConnections when the payload is empty,
+	// which happens when the system probe returns no data
+	ErrEmptyConnectionsPayload = errors.New("empty connections payload")
 )
 
 // ConnectionsCheck collects statistics about live TCP and UDP connections.
@@ -122,6 +129,36 @@ func (c *ConnectionsCheck) getConnections() ([]ebpf.ConnectionStats, error) {
 // Connections are split up into a chunks of at most 100 connections per message to
 // limit the message size on intake.
 func (c *ConnectionsCheck) formatConnections(conns []ebpf.ConnectionStats) []*model.Connection {
+	return FormatConnectionsFunc(conns, nil)
+}
+
+// ConnectionFormatOptions tunes how the connections are formatted, the zero value formats them
+// like the ConnectionsCheck
+type ConnectionFormatOptions struct {
+	// DropIdentityIPTranslation drops the IP translation of the connections whose conntrack entry does not
+	// translate anything, its reply addresses and ports being the connection ones reversed
+	DropIdentityIPTranslation bool
+	// AddrTruncator truncates the addresses, nil truncates them to ebpf.DefaultMaxAddrLength
+	// like ebpf.TruncateAddr
+	AddrTruncator *ebpf.AddrTruncator
+}
+
+// truncateAddr truncates the address with the truncator of the options
+func (o ConnectionFormatOptions) truncateAddr(addr string) string {
+	if o.AddrTruncator == nil {
+		return ebpf.TruncateAddr(addr)
+	}
+	return o.AddrTruncator.Truncate(addr)
+}
+
+// FormatConnectionsFunc formats the connections like the ConnectionsCheck in a single pass, calling fn
+// with every formatted connection. fn can modify the connection, and drop it by returning false.
+func FormatConnectionsFunc(conns []ebpf.ConnectionStats, fn func(*model.Connection) bool) []*model.Connection {
+	return FormatConnectionsWithOptions(conns, ConnectionFormatOptions{}, fn)
+}
+
+// FormatConnectionsWithOptions formats the connections like FormatConnectionsFunc with the options
+func FormatConnectionsWithOptions(conns []ebpf.ConnectionStats, opts ConnectionFormatOptions, fn func(*model.Connection) bool) []*model.Connection {
 	// Process create-times required to construct unique process hash keys on the backend
 	createTimeForPID := Process.createTimesforPIDs(connectionStatsPIDs(conns))
 
@@ -132,38 +169,75 @@ func (c *ConnectionsCheck) formatConnections(conns []ebpf.ConnectionStats) []*mo
 			createTimeForPID[conn.Pid] = 0
 		}
 
-		source, dest, ok := formatIPs(conn.Source, conn.Dest)
+		cx, ok := formatConnection(conn, createTimeForPID[conn.Pid], opts)
 		if !ok {
 			continue
 		}
-
-		cxs = append(cxs, &model.Connection{
-			Pid:           int32(conn.Pid),
-			PidCreateTime: createTimeForPID[conn.Pid],
-			NetNS:         conn.NetNS,
-			Family:        formatFamily(conn.Family),
-			Type:          formatType(conn.Type),
-			Laddr: &model.Addr{
-				Ip:   source,
-				Port: int32(conn.SPort),
-			},
-			Raddr: &model.Addr{
-				Ip:   dest,
-				Port: int32(conn.DPort),
-			},
-			TotalBytesSent:     conn.MonotonicSentBytes,
-			TotalBytesReceived: conn.MonotonicRecvBytes,
-			TotalRetransmits:   conn.MonotonicRetransmits,
-			LastBytesSent:      conn.LastSentBytes,
-			LastBytesReceived:  conn.LastRecvBytes,
-			LastRetransmits:    conn.LastRetransmits,
-			Direction:          formatDirection(conn.Direction),
-			IpTranslation:      formatIPTranslation(conn.IPTranslation),
-		})
+		if fn != nil && !fn(cx) {
+			continue
+		}
+		cxs = append(cxs, cx)
 	}
 	return cxs
 }
 
+// formatConnection formats the connection like the ConnectionsCheck, it returns false when its addresses
+// are not strings
+func formatConnection(conn ebpf.ConnectionStats, createTime int64, opts ConnectionFormatOptions) (*model.Connection, bool) {
+	cx := &model.Connection{}
+	if !formatConnectionTo(cx, conn, createTime, opts) {
+		return nil, false
+	}
+	return cx, true
+}
+
+// formatConnectionTo formats the connection into cx like formatConnection, reusing the addresses and the
+// IP translation cx already holds. Every field of cx is overwritten so that nothing is left from the connection
+// it held before. cx is left unchanged when false is returned.
+func formatConnectionTo(cx *model.Connection, conn ebpf.ConnectionStats, createTime int64, opts ConnectionFormatOptions) bool {
+	source, dest, ok := formatIPs(conn.Source, conn.Dest)
+	if !ok {
+		return false
+	}
+	source, dest = opts.truncateAddr(source), opts.truncateAddr(dest)
+
+	ipTranslation := conn.IPTranslation
+	if opts.DropIdentityIPTranslation && isIdentityIPTranslation(ipTranslation, source, dest, conn.SPort, conn.DPort) {
+		ipTranslation = nil
+	}
+
+	*cx = model.Connection{
+		Pid:                int32(conn.Pid),
+		PidCreateTime:      createTime,
+		NetNS:              conn.NetNS,
+		Family:             formatFamily(conn.Family),
+		Type:               formatType(conn.Type),
+		Laddr:              formatAddr(cx.Laddr, source, conn.SPort),
+		Raddr:              formatAddr(cx.Raddr, dest, conn.DPort),
+		TotalBytesSent:     conn.MonotonicSentBytes,
+		TotalBytesReceived: conn.MonotonicRecvBytes,
+		TotalRetransmits:   conn.MonotonicRetransmits,
+		LastBytesSent:      conn.LastSentBytes,
+		LastBytesReceived:  conn.LastRecvBytes,
+		LastRetransmits:    conn.LastRetransmits,
+		Direction:          formatDirection(conn.Direction),
+		IpTranslation:      formatIPTranslation(cx.IpTranslation, ipTranslation),
+	}
+	return true
+}
+
+// formatAddr formats the address into dst, which is allocated when nil
+func formatAddr(dst *model.Addr, ip string, port uint16) *model.Addr {
+	if dst == nil {
+		dst = &model.Addr{}
+	}
+	*dst = model.Addr{
+		Ip:   ip,
+		Port: int32(port),
+	}
+	return dst
+}
+
 // These are written as strings via the easyjson marshaller in ebpf.Address
 func formatIPs(sourceIP, destIP interface{}) (string, string, bool) {
 	source, ok := sourceIP.(string)
@@ -215,17 +289,190 @@ func formatDirection(d ebpf.ConnectionDirection) model.ConnectionDirection {
 	}
 }
 
-func formatIPTranslation(ct *netlink.IPTranslation) *model.IPTranslation {
+// formatIPTranslation formats the IP translation into dst, which is allocated when nil
+func formatIPTranslation(dst *model.IPTranslation, ct *netlink.IPTranslation) *model.IPTranslation {
 	if ct == nil {
 		return nil
 	}
+	if dst == nil {
+		dst = &model.IPTranslation{}
+	}
 
-	return &model.IPTranslation{
+	*dst = model.IPTranslation{
 		ReplSrcIP:   ct.ReplSrcIP,
 		ReplDstIP:   ct.ReplDstIP,
 		ReplSrcPort: int32(ct.ReplSrcPort),
 		ReplDstPort: int32(ct.ReplDstPort),
 	}
+	return dst
+}
+
+// ConnectionsDecodeError is returned by DecodeConnections when the payload can not be decoded, like a truncated
+// or garbage payload or a connection with an invalid address. Err is the error of the protobuf unmarshaling
+// or of the parsing of the connection.
+type ConnectionsDecodeError struct {
+	Err error
+}
+
+func (e *ConnectionsDecodeError) Error() string {
+	return fmt.Sprintf("failed to decode connections protobuf: %s", e.Err)
+}
+
+// isIdentityIPTranslation returns true when the conntrack entry does not translate the connection:
+// the reply comes from its destination to its source
+func isIdentityIPTranslation(ct *netlink.IPTranslation, source, dest string, sport, dport uint16) bool {
+	return ct != nil &&
+		ct.ReplSrcIP == dest && ct.ReplSrcPort == dport &&
+		ct.ReplDstIP == source && ct.ReplDstPort == sport
+}
+
+// DecodeConnections decodes a CollectorConnections payload back into the connections
+// as the system probe reports them, reversing FormatConnectionsFunc.
+// The addresses are strings like in the system probe responses,
+// and LastUpdateEpoch is left unset since it is not part of the payload.
+// ErrEmptyConnectionsPayload is returned for an empty payload and a *ConnectionsDecodeError
+// for a payload which can not be decoded.
+func DecodeConnections(blob []byte) (*ebpf.Connections, error) {
+	if len(blob) == 0 {
+		return nil, ErrEmptyConnectionsPayload
+	}
+	var payload model.CollectorConnections
+	if err := payload.Unmarshal(blob); err != nil {
+		return nil, &ConnectionsDecodeError{Err: err}
+	}
+
+	conns := make([]ebpf.ConnectionStats, 0, len(payload.Connections))
+	for _, cx := range payload.Connections {
+		conn, err := parseConnection(cx)
+		if err != nil {
+			return nil, &ConnectionsDecodeError{Err: err}
+		}
+		conns = append(conns, conn)
+	}
+	return &ebpf.Connections{Conns: conns}, nil
+}
+
+// DetectAndUnmarshal decodes connections encoded either as JSON like in the system probe responses,
+// or as a CollectorConnections payload like DecodeConnections. A payload starting with '{', once
+// the leading whitespace is skipped, is decoded as JSON and falls back to protobuf when it is not valid JSON,
+// any other payload is decoded as protobuf. An empty payload holds no connections.
+func DetectAndUnmarshal(blob []byte) (*ebpf.Connections, error) {
+	trimmed := bytes.TrimLeft(blob, " \t\r\n")
+	if len(trimmed) == 0 {
+		return &ebpf.Connections{}, nil
+	}
+	if trimmed[0] != '{' {
+		return DecodeConnections(blob)
+	}
+
+	conns := &ebpf.Connections{}
+	jsonErr := conns.UnmarshalJSON(trimmed)
+	if jsonErr == nil {
+		return conns, nil
+	}
+	decoded, protobufErr := DecodeConnections(blob)
+	if protobufErr != nil {
+		return nil, fmt.Errorf("could not decode connections as JSON: %s, nor as protobuf: %s", jsonErr, protobufErr)
+	}
+	return decoded, nil
+}
+
+func parseConnection(cx *model.Connection) (ebpf.ConnectionStats, error) {
+	source, err := parseAddr(cx.Laddr)
+	if err != nil {
+		return ebpf.ConnectionStats{}, fmt.Errorf("invalid local address for pid %d: %s", cx.Pid, err)
+	}
+	dest, err := parseAddr(cx.Raddr)
+	if err != nil {
+		return ebpf.ConnectionStats{}, fmt.Errorf("invalid remote address for pid %d: %s", cx.Pid, err)
+	}
+	family, err := parseFamily(cx.Family)
+	if err != nil {
+		return ebpf.ConnectionStats{}, err
+	}
+	connType, err := parseType(cx.Type)
+	if err != nil {
+		return ebpf.ConnectionStats{}, err
+	}
+
+	return ebpf.ConnectionStats{
+		Source:               source,
+		Dest:                 dest,
+		MonotonicSentBytes:   cx.TotalBytesSent,
+		LastSentBytes:        cx.LastBytesSent,
+		MonotonicRecvBytes:   cx.TotalBytesReceived,
+		LastRecvBytes:        cx.LastBytesReceived,
+		MonotonicRetransmits: cx.TotalRetransmits,
+		LastRetransmits:      cx.LastRetransmits,
+		Pid:                  uint32(cx.Pid),
+		NetNS:                cx.NetNS,
+		SPort:                uint16(cx.Laddr.Port),
+		DPort:                uint16(cx.Raddr.Port),
+		Type:                 connType,
+		Family:               family,
+		Direction:            parseDirection(cx.Direction),
+		IPTranslation:        parseIPTranslation(cx.IpTranslation),
+	}, nil
+}
+
+// parseAddr returns the IP of the address in its canonical form.
+func parseAddr(addr *model.Addr) (string, error) {
+	if addr == nil {
+		return "", errors.New("missing address")
+	}
+	parsed := stdnet.ParseIP(addr.Ip)
+	if parsed == nil {
+		return "", fmt.Errorf("could not parse IP %q", addr.Ip)
+	}
+	return util.AddressFromNetIP(parsed).String(), nil
+}
+
+func parseFamily(f model.ConnectionFamily) (ebpf.ConnectionFamily, error) {
+	switch f {
+	case model.ConnectionFamily_v4:
+		return ebpf.AFINET, nil
+	case model.ConnectionFamily_v6:
+		return ebpf.AFINET6, nil
+	default:
+		return 0, fmt.Errorf("unknown connection family %d", f)
+	}
+}
+
+func parseType(t model.ConnectionType) (ebpf.ConnectionType, error) {
+	switch t {
+	case model.ConnectionType_tcp:
+		return ebpf.TCP, nil
+	case model.ConnectionType_udp:
+		return ebpf.UDP, nil
+	default:
+		return 0, fmt.Errorf("unknown connection type %d", t)
+	}
+}
+
+func parseDirection(d model.ConnectionDirection) ebpf.ConnectionDirection {
+	switch d {
+	case model.ConnectionDirection_incoming:
+		return ebpf.INCOMING
+	case model.ConnectionDirection_outgoing:
+		return ebpf.OUTGOING
+	case model.ConnectionDirection_local:
+		return ebpf.LOCAL
+	default:
+		return 0
+	}
+}
+
+func parseIPTranslation(ct *model.IPTranslation) *netlink.IPTranslation {
+	if ct == nil {
+		return nil
+	}
+
+	return &netlink.IPTranslation{
+		ReplSrcIP:   ct.ReplSrcIP,
+		ReplDstIP:   ct.ReplDstIP,
+		ReplSrcPort: uint16(ct.ReplSrcPort),
+		ReplDstPort: uint16(ct.ReplDstPort),
+	}
 }
 
 func batchConnections(cfg *config.AgentConfig, groupID int32, cxs []*model.Connection) []model.MessageBody {
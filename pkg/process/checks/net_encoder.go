@@ -0,0 +1,20 @@
+package checks
+
+import (
+	"github.com/DataDog/datadog-agent/pkg/ebpf"
+	"github.com/DataDog/datadog-agent/pkg/process/model"
+)
+
+func init() {
+	ebpf.RegisterEncoder(ebpf.ContentTypeProtobuf, ebpf.EncoderFunc(marshalConnectionsProtobuf))
+}
+
+// marshalConnectionsProtobuf encodes the connections as a model.CollectorConnections holding only the
+// connections, formatted like the ConnectionsCheck
+func marshalConnectionsProtobuf(conns *ebpf.Connections) ([]byte, error) {
+	var payload model.CollectorConnections
+	if conns != nil {
+		payload.Connections = FormatConnectionsFunc(conns.Conns, nil)
+	}
+	return payload.Marshal()
+}
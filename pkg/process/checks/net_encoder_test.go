@@ -0,0 +1,25 @@
+package checks
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/DataDog/datadog-agent/pkg/ebpf"
+	"github.com/DataDog/datadog-agent/pkg/process/model"
+)
+
+func TestEncodeProtobuf(t *testing.T) {
+	conns := &ebpf.Connections{Conns: []ebpf.ConnectionStats{
+		{Pid: 42, Source: "10.0.0.1", Dest: "10.0.0.2", SPort: 51234, DPort: 443, MonotonicSentBytes: 12},
+	}}
+
+	data, contentType, err := ebpf.Encode("application/x-protobuf", conns)
+	require.NoError(t, err)
+	assert.Equal(t, ebpf.ContentTypeProtobuf, contentType)
+
+	var payload model.CollectorConnections
+	require.NoError(t, payload.Unmarshal(data))
+	assert.Equal(t, FormatConnectionsFunc(conns.Conns, nil), payload.Connections)
+}
@@ -0,0 +1,26 @@
+package checks
+
+import (
+	"bytes"
+
+	"github.com/gogo/protobuf/jsonpb"
+
+	"github.com/DataDog/datadog-agent/pkg/ebpf"
+	"github.com/DataDog/datadog-agent/pkg/process/model"
+)
+
+// MarshalJSONWithOptions returns the JSON encoding of a CollectorConnections holding only the connections,
+// formatted like the ConnectionsCheck, marshaled by opts. EmitDefaults keeps the zero counters in the output
+// and Indent makes it readable. ebpf.Connections.MarshalJSON, the encoding the system-probe serves, is left unchanged.
+func MarshalJSONWithOptions(conns *ebpf.Connections, opts jsonpb.Marshaler) ([]byte, error) {
+	var payload model.CollectorConnections
+	if conns != nil {
+		payload.Connections = FormatConnectionsFunc(conns.Conns, nil)
+	}
+
+	var buf bytes.Buffer
+	if err := opts.Marshal(&buf, &payload); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,39 @@
+package checks
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/gogo/protobuf/jsonpb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/DataDog/datadog-agent/pkg/ebpf"
+	"github.com/DataDog/datadog-agent/pkg/process/model"
+)
+
+func TestMarshalJSONWithOptions(t *testing.T) {
+	conns := &ebpf.Connections{Conns: []ebpf.ConnectionStats{
+		{Pid: 1, Source: "10.0.0.1", Dest: "10.0.0.2", SPort: 4242, DPort: 443, MonotonicSentBytes: 10},
+	}}
+
+	data, err := MarshalJSONWithOptions(conns, jsonpb.Marshaler{})
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"totalBytesSent":"10"`)
+	assert.NotContains(t, string(data), `"totalBytesReceived"`)
+
+	var decoded model.CollectorConnections
+	require.NoError(t, jsonpb.Unmarshal(bytes.NewReader(data), &decoded))
+	assert.Equal(t, FormatConnectionsFunc(conns.Conns, nil), decoded.Connections)
+
+	// the zero counters are kept
+	data, err = MarshalJSONWithOptions(conns, jsonpb.Marshaler{EmitDefaults: true})
+	require.NoError(t, err)
+	for _, field := range []string{`"totalBytesReceived":"0"`, `"totalRetransmits":0`, `"lastBytesSent":"0"`, `"lastRetransmits":0`} {
+		assert.Contains(t, string(data), field)
+	}
+
+	data, err = MarshalJSONWithOptions(conns, jsonpb.Marshaler{Indent: "  "})
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "\n      \"pid\": 1,\n")
+}
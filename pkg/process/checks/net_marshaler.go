@@ -0,0 +1,98 @@
+package checks
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+
+	"github.com/DataDog/datadog-agent/pkg/ebpf"
+	"github.com/DataDog/datadog-agent/pkg/process/model"
+)
+
+// ConnectionsMarshaler marshals connections formatted like the ConnectionsCheck, reusing the formatted
+// connections and the payload of the previous call so that marshaling the connections on every collection
+// does not allocate them again. The payload returned is only valid until the next call.
+// A ConnectionsMarshaler is not safe for concurrent use.
+type ConnectionsMarshaler struct {
+	// Deterministic sorts the connections with lessConnection before marshaling them, so that the same
+	// connections give the same payload whatever their order. The encoding of a connection is always
+	// deterministic as it holds no map.
+	Deterministic bool
+
+	// cxs are the connections formatted so far, they are never dropped so that they can be reused
+	cxs     []*model.Connection
+	payload model.CollectorConnections
+	buf     []byte
+}
+
+// MarshalProtobuf returns the protobuf encoding of a CollectorConnections holding only the connections
+func (m *ConnectionsMarshaler) MarshalProtobuf(conns *ebpf.Connections) ([]byte, error) {
+	var stats []ebpf.ConnectionStats
+	if conns != nil {
+		stats = conns.Conns
+	}
+
+	// Process create-times required to construct unique process hash keys on the backend
+	createTimeForPID := Process.createTimesforPIDs(connectionStatsPIDs(stats))
+
+	n := 0
+	for _, conn := range stats {
+		if n == len(m.cxs) {
+			m.cxs = append(m.cxs, &model.Connection{})
+		}
+		// default creation time to ensure network connections from short-lived processes are not dropped
+		if formatConnectionTo(m.cxs[n], conn, createTimeForPID[conn.Pid], ConnectionFormatOptions{}) {
+			n++
+		}
+	}
+	m.payload.Connections = m.cxs[:n]
+	if m.Deterministic {
+		sort.Slice(m.payload.Connections, func(i, j int) bool {
+			return lessConnection(m.payload.Connections[i], m.payload.Connections[j])
+		})
+	}
+
+	size := m.payload.Size()
+	if cap(m.buf) < size {
+		m.buf = make([]byte, size)
+	}
+	written, err := m.payload.MarshalTo(m.buf[:size])
+	if err != nil {
+		return nil, err
+	}
+	return m.buf[:written], nil
+}
+
+// lessConnection orders the connections by pid, local address, remote address then type. The connections
+// equal on all of these are ordered by their encoding.
+func lessConnection(a, b *model.Connection) bool {
+	if a.Pid != b.Pid {
+		return a.Pid < b.Pid
+	}
+	if cmp := compareAddr(a.Laddr, b.Laddr); cmp != 0 {
+		return cmp < 0
+	}
+	if cmp := compareAddr(a.Raddr, b.Raddr); cmp != 0 {
+		return cmp < 0
+	}
+	if a.Type != b.Type {
+		return a.Type < b.Type
+	}
+	encodedA, _ := a.Marshal()
+	encodedB, _ := b.Marshal()
+	return bytes.Compare(encodedA, encodedB) < 0
+}
+
+// compareAddr compares the addresses by IP then port, a missing address being the zero one
+func compareAddr(a, b *model.Addr) int {
+	if a == nil {
+		a = &model.Addr{}
+	}
+	if b == nil {
+		b = &model.Addr{}
+	}
+	if cmp := strings.Compare(a.Ip, b.Ip); cmp != 0 {
+		return cmp
+	}
+	return int(a.Port) - int(b.Port)
+}
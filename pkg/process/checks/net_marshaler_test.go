@@ -0,0 +1,123 @@
+package checks
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/DataDog/datadog-agent/pkg/ebpf"
+	"github.com/DataDog/datadog-agent/pkg/ebpf/netlink"
+	"github.com/DataDog/datadog-agent/pkg/process/model"
+)
+
+func TestConnectionsMarshaler(t *testing.T) {
+	var m ConnectionsMarshaler
+	for _, conns := range [][]ebpf.ConnectionStats{
+		{
+			{
+				Pid:                1,
+				Source:             "10.0.0.1",
+				Dest:               "10.0.0.2",
+				SPort:              4242,
+				DPort:              443,
+				MonotonicSentBytes: 10,
+				Direction:          ebpf.OUTGOING,
+				IPTranslation: &netlink.IPTranslation{
+					ReplSrcIP:   "10.0.0.2",
+					ReplDstIP:   "172.17.0.2",
+					ReplSrcPort: 443,
+					ReplDstPort: 4242,
+				},
+			},
+			{Pid: 2, Source: "fe80::1", Dest: "2001:db8::2", SPort: 53, DPort: 5353, Type: ebpf.UDP, Family: ebpf.AFINET6},
+		},
+		// the connections formatted before must not leak into the new ones
+		{
+			{Pid: 3, Source: "10.0.0.3", Dest: "10.0.0.4", SPort: 80, DPort: 8080},
+		},
+		{
+			{Pid: 4, Source: 42, Dest: "10.0.0.4"},
+			{Pid: 5, Source: "10.0.0.5", Dest: "10.0.0.6", SPort: 22, DPort: 2222, Direction: ebpf.INCOMING},
+			{Pid: 6, Source: "10.0.0.7", Dest: "10.0.0.8"},
+		},
+		nil,
+	} {
+		data, err := m.MarshalProtobuf(&ebpf.Connections{Conns: conns})
+		require.NoError(t, err)
+
+		expected, err := (&model.CollectorConnections{Connections: FormatConnectionsFunc(conns, nil)}).Marshal()
+		require.NoError(t, err)
+		assert.Equal(t, expected, data)
+	}
+}
+
+func BenchmarkMarshalProtobuf(b *testing.B) {
+	conns := &ebpf.Connections{}
+	for i := 0; i < 1000; i++ {
+		conns.Conns = append(conns.Conns, ebpf.ConnectionStats{
+			Pid:                uint32(i),
+			Source:             "10.0.0.1",
+			Dest:               fmt.Sprintf("10.0.%d.%d", i/256, i%256),
+			SPort:              uint16(30000 + i),
+			DPort:              443,
+			MonotonicSentBytes: uint64(i),
+			Direction:          ebpf.OUTGOING,
+		})
+	}
+
+	b.Run("formatted", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			payload := model.CollectorConnections{Connections: FormatConnectionsFunc(conns.Conns, nil)}
+			payload.Marshal()
+		}
+	})
+	b.Run("marshaler", func(b *testing.B) {
+		var m ConnectionsMarshaler
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			m.MarshalProtobuf(conns)
+		}
+	})
+}
+
+func TestConnectionsMarshalerDeterministic(t *testing.T) {
+	conns := []ebpf.ConnectionStats{
+		{Pid: 2, Source: "10.0.0.1", Dest: "10.0.0.2", SPort: 4242, DPort: 443},
+		{Pid: 1, Source: "10.0.0.3", Dest: "10.0.0.2", SPort: 4242, DPort: 443},
+		{Pid: 1, Source: "10.0.0.1", Dest: "10.0.0.2", SPort: 4243, DPort: 443},
+		{Pid: 1, Source: "10.0.0.1", Dest: "10.0.0.2", SPort: 4242, DPort: 443, Type: ebpf.UDP},
+		// equal but for their counters
+		{Pid: 1, Source: "10.0.0.1", Dest: "10.0.0.2", SPort: 4242, DPort: 443, MonotonicSentBytes: 20},
+		{Pid: 1, Source: "10.0.0.1", Dest: "10.0.0.2", SPort: 4242, DPort: 443, MonotonicSentBytes: 10},
+	}
+	reversed := make([]ebpf.ConnectionStats, len(conns))
+	for i, c := range conns {
+		reversed[len(conns)-1-i] = c
+	}
+
+	m := ConnectionsMarshaler{Deterministic: true}
+	data, err := m.MarshalProtobuf(&ebpf.Connections{Conns: conns})
+	require.NoError(t, err)
+	// the payload is only valid until the next call
+	first := append([]byte(nil), data...)
+	second, err := m.MarshalProtobuf(&ebpf.Connections{Conns: reversed})
+	require.NoError(t, err)
+	assert.Equal(t, first, second)
+
+	var decoded model.CollectorConnections
+	require.NoError(t, decoded.Unmarshal(first))
+	pids := make([]int32, 0, len(decoded.Connections))
+	sent := make([]uint64, 0, len(decoded.Connections))
+	for _, cx := range decoded.Connections {
+		pids = append(pids, cx.Pid)
+		sent = append(sent, cx.TotalBytesSent)
+	}
+	assert.Equal(t, []int32{1, 1, 1, 1, 1, 2}, pids)
+	assert.Equal(t, []uint64{10, 20, 0, 0, 0, 0}, sent)
+	assert.Equal(t, model.ConnectionType_udp, decoded.Connections[2].Type)
+	assert.Equal(t, int32(4243), decoded.Connections[3].Laddr.Port)
+	assert.Equal(t, "10.0.0.3", decoded.Connections[4].Laddr.Ip)
+}
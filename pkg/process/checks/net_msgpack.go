@@ -0,0 +1,237 @@
+package checks
+
+import (
+	"fmt"
+
+	"github.com/tinylib/msgp/msgp"
+
+	"github.com/DataDog/datadog-agent/pkg/ebpf"
+	"github.com/DataDog/datadog-agent/pkg/process/model"
+)
+
+// MarshalMsgpack encodes the connections formatted like the ConnectionsCheck as MessagePack. The document is
+// a map holding the connections under "connections", the fields of the connections being keyed by their JSON
+// names so that the mapping is the one of the CollectorConnections payload.
+func MarshalMsgpack(conns *ebpf.Connections) ([]byte, error) {
+	var cxs []*model.Connection
+	if conns != nil {
+		cxs = FormatConnectionsFunc(conns.Conns, nil)
+	}
+
+	b := msgp.AppendMapHeader(nil, 1)
+	b = msgp.AppendString(b, "connections")
+	b = msgp.AppendArrayHeader(b, uint32(len(cxs)))
+	for _, cx := range cxs {
+		b = appendConnectionMsgpack(b, cx)
+	}
+	return b, nil
+}
+
+// UnmarshalMsgpack decodes connections encoded by MarshalMsgpack into a CollectorConnections holding only
+// the connections. The unknown fields are skipped.
+func UnmarshalMsgpack(blob []byte) (*model.CollectorConnections, error) {
+	payload := &model.CollectorConnections{}
+	fields, b, err := msgp.ReadMapHeaderBytes(blob)
+	for ; fields > 0 && err == nil; fields-- {
+		var key []byte
+		if key, b, err = msgp.ReadMapKeyZC(b); err != nil {
+			break
+		}
+		switch string(key) {
+		case "connections":
+			payload.Connections, b, err = readConnectionsMsgpack(b)
+		default:
+			b, err = msgp.Skip(b)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not decode connections as msgpack: %s", err)
+	}
+	return payload, nil
+}
+
+func appendConnectionMsgpack(b []byte, cx *model.Connection) []byte {
+	b = msgp.AppendMapHeader(b, 15)
+	b = msgp.AppendString(b, "pid")
+	b = msgp.AppendInt32(b, cx.Pid)
+	b = msgp.AppendString(b, "pidCreateTime")
+	b = msgp.AppendInt64(b, cx.PidCreateTime)
+	b = msgp.AppendString(b, "netNS")
+	b = msgp.AppendUint32(b, cx.NetNS)
+	b = msgp.AppendString(b, "family")
+	b = msgp.AppendInt32(b, int32(cx.Family))
+	b = msgp.AppendString(b, "type")
+	b = msgp.AppendInt32(b, int32(cx.Type))
+	b = msgp.AppendString(b, "laddr")
+	b = appendAddrMsgpack(b, cx.Laddr)
+	b = msgp.AppendString(b, "raddr")
+	b = appendAddrMsgpack(b, cx.Raddr)
+	b = msgp.AppendString(b, "totalBytesSent")
+	b = msgp.AppendUint64(b, cx.TotalBytesSent)
+	b = msgp.AppendString(b, "totalBytesReceived")
+	b = msgp.AppendUint64(b, cx.TotalBytesReceived)
+	b = msgp.AppendString(b, "totalRetransmits")
+	b = msgp.AppendUint32(b, cx.TotalRetransmits)
+	b = msgp.AppendString(b, "lastBytesSent")
+	b = msgp.AppendUint64(b, cx.LastBytesSent)
+	b = msgp.AppendString(b, "lastBytesReceived")
+	b = msgp.AppendUint64(b, cx.LastBytesReceived)
+	b = msgp.AppendString(b, "lastRetransmits")
+	b = msgp.AppendUint32(b, cx.LastRetransmits)
+	b = msgp.AppendString(b, "direction")
+	b = msgp.AppendInt32(b, int32(cx.Direction))
+	b = msgp.AppendString(b, "ipTranslation")
+	return appendIPTranslationMsgpack(b, cx.IpTranslation)
+}
+
+func appendAddrMsgpack(b []byte, addr *model.Addr) []byte {
+	if addr == nil {
+		return msgp.AppendNil(b)
+	}
+	b = msgp.AppendMapHeader(b, 4)
+	b = msgp.AppendString(b, "ip")
+	b = msgp.AppendString(b, addr.Ip)
+	b = msgp.AppendString(b, "port")
+	b = msgp.AppendInt32(b, addr.Port)
+	b = msgp.AppendString(b, "containerId")
+	b = msgp.AppendString(b, addr.ContainerId)
+	b = msgp.AppendString(b, "hostId")
+	return msgp.AppendInt32(b, addr.HostId)
+}
+
+func appendIPTranslationMsgpack(b []byte, ct *model.IPTranslation) []byte {
+	if ct == nil {
+		return msgp.AppendNil(b)
+	}
+	b = msgp.AppendMapHeader(b, 4)
+	b = msgp.AppendString(b, "replSrcIP")
+	b = msgp.AppendString(b, ct.ReplSrcIP)
+	b = msgp.AppendString(b, "replDstIP")
+	b = msgp.AppendString(b, ct.ReplDstIP)
+	b = msgp.AppendString(b, "replSrcPort")
+	b = msgp.AppendInt32(b, ct.ReplSrcPort)
+	b = msgp.AppendString(b, "replDstPort")
+	return msgp.AppendInt32(b, ct.ReplDstPort)
+}
+
+func readConnectionsMsgpack(b []byte) ([]*model.Connection, []byte, error) {
+	size, b, err := msgp.ReadArrayHeaderBytes(b)
+	if err != nil {
+		return nil, b, err
+	}
+	cxs := make([]*model.Connection, 0, size)
+	for ; size > 0; size-- {
+		var cx *model.Connection
+		if cx, b, err = readConnectionMsgpack(b); err != nil {
+			return nil, b, err
+		}
+		cxs = append(cxs, cx)
+	}
+	return cxs, b, nil
+}
+
+func readConnectionMsgpack(b []byte) (*model.Connection, []byte, error) {
+	cx := &model.Connection{}
+	fields, b, err := msgp.ReadMapHeaderBytes(b)
+	for ; fields > 0 && err == nil; fields-- {
+		var key []byte
+		if key, b, err = msgp.ReadMapKeyZC(b); err != nil {
+			break
+		}
+		var enum int32
+		switch string(key) {
+		case "pid":
+			cx.Pid, b, err = msgp.ReadInt32Bytes(b)
+		case "pidCreateTime":
+			cx.PidCreateTime, b, err = msgp.ReadInt64Bytes(b)
+		case "netNS":
+			cx.NetNS, b, err = msgp.ReadUint32Bytes(b)
+		case "family":
+			enum, b, err = msgp.ReadInt32Bytes(b)
+			cx.Family = model.ConnectionFamily(enum)
+		case "type":
+			enum, b, err = msgp.ReadInt32Bytes(b)
+			cx.Type = model.ConnectionType(enum)
+		case "laddr":
+			cx.Laddr, b, err = readAddrMsgpack(b)
+		case "raddr":
+			cx.Raddr, b, err = readAddrMsgpack(b)
+		case "totalBytesSent":
+			cx.TotalBytesSent, b, err = msgp.ReadUint64Bytes(b)
+		case "totalBytesReceived":
+			cx.TotalBytesReceived, b, err = msgp.ReadUint64Bytes(b)
+		case "totalRetransmits":
+			cx.TotalRetransmits, b, err = msgp.ReadUint32Bytes(b)
+		case "lastBytesSent":
+			cx.LastBytesSent, b, err = msgp.ReadUint64Bytes(b)
+		case "lastBytesReceived":
+			cx.LastBytesReceived, b, err = msgp.ReadUint64Bytes(b)
+		case "lastRetransmits":
+			cx.LastRetransmits, b, err = msgp.ReadUint32Bytes(b)
+		case "direction":
+			enum, b, err = msgp.ReadInt32Bytes(b)
+			cx.Direction = model.ConnectionDirection(enum)
+		case "ipTranslation":
+			cx.IpTranslation, b, err = readIPTranslationMsgpack(b)
+		default:
+			b, err = msgp.Skip(b)
+		}
+	}
+	return cx, b, err
+}
+
+func readAddrMsgpack(b []byte) (*model.Addr, []byte, error) {
+	if msgp.IsNil(b) {
+		b, err := msgp.ReadNilBytes(b)
+		return nil, b, err
+	}
+	addr := &model.Addr{}
+	fields, b, err := msgp.ReadMapHeaderBytes(b)
+	for ; fields > 0 && err == nil; fields-- {
+		var key []byte
+		if key, b, err = msgp.ReadMapKeyZC(b); err != nil {
+			break
+		}
+		switch string(key) {
+		case "ip":
+			addr.Ip, b, err = msgp.ReadStringBytes(b)
+		case "port":
+			addr.Port, b, err = msgp.ReadInt32Bytes(b)
+		case "containerId":
+			addr.ContainerId, b, err = msgp.ReadStringBytes(b)
+		case "hostId":
+			addr.HostId, b, err = msgp.ReadInt32Bytes(b)
+		default:
+			b, err = msgp.Skip(b)
+		}
+	}
+	return addr, b, err
+}
+
+func readIPTranslationMsgpack(b []byte) (*model.IPTranslation, []byte, error) {
+	if msgp.IsNil(b) {
+		b, err := msgp.ReadNilBytes(b)
+		return nil, b, err
+	}
+	ct := &model.IPTranslation{}
+	fields, b, err := msgp.ReadMapHeaderBytes(b)
+	for ; fields > 0 && err == nil; fields-- {
+		var key []byte
+		if key, b, err = msgp.ReadMapKeyZC(b); err != nil {
+			break
+		}
+		switch string(key) {
+		case "replSrcIP":
+			ct.ReplSrcIP, b, err = msgp.ReadStringBytes(b)
+		case "replDstIP":
+			ct.ReplDstIP, b, err = msgp.ReadStringBytes(b)
+		case "replSrcPort":
+			ct.ReplSrcPort, b, err = msgp.ReadInt32Bytes(b)
+		case "replDstPort":
+			ct.ReplDstPort, b, err = msgp.ReadInt32Bytes(b)
+		default:
+			b, err = msgp.Skip(b)
+		}
+	}
+	return ct, b, err
+}
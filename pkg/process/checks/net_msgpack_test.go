@@ -0,0 +1,92 @@
+package checks
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tinylib/msgp/msgp"
+
+	"github.com/DataDog/datadog-agent/pkg/ebpf"
+	"github.com/DataDog/datadog-agent/pkg/ebpf/netlink"
+)
+
+func TestMsgpackRoundTrip(t *testing.T) {
+	conns := &ebpf.Connections{Conns: []ebpf.ConnectionStats{
+		{
+			Pid:                  1,
+			NetNS:                4026531992,
+			Source:               "10.0.0.1",
+			Dest:                 "10.0.0.2",
+			SPort:                4242,
+			DPort:                443,
+			Family:               ebpf.AFINET,
+			MonotonicSentBytes:   10,
+			LastSentBytes:        2,
+			MonotonicRecvBytes:   20,
+			LastRecvBytes:        4,
+			MonotonicRetransmits: 3,
+			LastRetransmits:      1,
+			Direction:            ebpf.OUTGOING,
+			IPTranslation: &netlink.IPTranslation{
+				ReplSrcIP:   "10.0.0.2",
+				ReplDstIP:   "172.17.0.2",
+				ReplSrcPort: 443,
+				ReplDstPort: 4242,
+			},
+		},
+		{
+			Pid:       2,
+			Source:    "fe80::1",
+			Dest:      "2001:db8::2",
+			SPort:     53,
+			DPort:     5353,
+			Type:      ebpf.UDP,
+			Family:    ebpf.AFINET6,
+			Direction: ebpf.INCOMING,
+		},
+	}}
+
+	data, err := MarshalMsgpack(conns)
+	require.NoError(t, err)
+
+	payload, err := UnmarshalMsgpack(data)
+	require.NoError(t, err)
+	assert.Equal(t, FormatConnectionsFunc(conns.Conns, nil), payload.Connections)
+	assert.NotNil(t, payload.Connections[0].IpTranslation)
+	assert.Nil(t, payload.Connections[1].IpTranslation)
+}
+
+func TestUnmarshalMsgpackSkipsUnknownFields(t *testing.T) {
+	b := msgp.AppendMapHeader(nil, 2)
+	b = msgp.AppendString(b, "version")
+	b = msgp.AppendInt(b, 2)
+	b = msgp.AppendString(b, "connections")
+	b = msgp.AppendArrayHeader(b, 1)
+	b = msgp.AppendMapHeader(b, 2)
+	b = msgp.AppendString(b, "pid")
+	b = msgp.AppendInt32(b, 42)
+	b = msgp.AppendString(b, "rtt")
+	b = msgp.AppendUint32(b, 1200)
+
+	payload, err := UnmarshalMsgpack(b)
+	require.NoError(t, err)
+	require.Len(t, payload.Connections, 1)
+	assert.Equal(t, int32(42), payload.Connections[0].Pid)
+}
+
+func TestUnmarshalMsgpackInvalidPayloads(t *testing.T) {
+	data, err := MarshalMsgpack(&ebpf.Connections{Conns: []ebpf.ConnectionStats{
+		{Pid: 1, Source: "10.0.0.1", Dest: "10.0.0.2", SPort: 4242, DPort: 443},
+	}})
+	require.NoError(t, err)
+
+	for name, blob := range map[string][]byte{
+		"empty":     nil,
+		"truncated": data[:len(data)-3],
+		"not a map": msgp.AppendString(nil, "connections"),
+	} {
+		_, err := UnmarshalMsgpack(blob)
+		assert.Error(t, err, name)
+	}
+}
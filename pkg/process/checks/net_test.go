@@ -2,8 +2,11 @@ package checks
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 
+	"github.com/DataDog/datadog-agent/pkg/ebpf"
+	"github.com/DataDog/datadog-agent/pkg/ebpf/netlink"
 	"github.com/DataDog/datadog-agent/pkg/process/config"
 	"github.com/DataDog/datadog-agent/pkg/process/model"
 	"github.com/stretchr/testify/assert"
@@ -85,3 +88,318 @@ func TestNetworkConnectionBatching(t *testing.T) {
 		assert.Equal(t, tc.expectedTotal, total, "total test %d", i)
 	}
 }
+
+func TestFormatDirection(t *testing.T) {
+	for direction, expected := range map[ebpf.ConnectionDirection]model.ConnectionDirection{
+		ebpf.INCOMING: model.ConnectionDirection_incoming,
+		ebpf.OUTGOING: model.ConnectionDirection_outgoing,
+		ebpf.LOCAL:    model.ConnectionDirection_local,
+		0:             model.ConnectionDirection_unspecified,
+		42:            model.ConnectionDirection_unspecified,
+	} {
+		assert.Equal(t, expected, formatDirection(direction), "direction %d", direction)
+	}
+}
+
+func TestFormatConnectionsFunc(t *testing.T) {
+	conns := []ebpf.ConnectionStats{
+		{Pid: 1, Source: "10.0.0.1", Dest: "10.0.0.2", SPort: 4242, DPort: 443, MonotonicSentBytes: 10},
+		{Pid: 2, Source: "10.0.0.1", Dest: "127.0.0.1", SPort: 4243, DPort: 8080},
+		{Pid: 3, Source: "10.0.0.1", Dest: "10.0.0.3", SPort: 4244, DPort: 53, Type: ebpf.UDP},
+	}
+
+	cxs := FormatConnectionsFunc(conns, func(c *model.Connection) bool {
+		if c.Raddr.Ip == "127.0.0.1" {
+			return false
+		}
+		c.Raddr.ContainerId = fmt.Sprintf("container-%d", c.Pid)
+		return true
+	})
+
+	assert.Len(t, cxs, 2)
+	for _, c := range cxs {
+		assert.NotNil(t, c)
+	}
+	assert.Equal(t, int32(1), cxs[0].Pid)
+	assert.Equal(t, "container-1", cxs[0].Raddr.ContainerId)
+	assert.Equal(t, uint64(10), cxs[0].TotalBytesSent)
+	assert.Equal(t, int32(3), cxs[1].Pid)
+	assert.Equal(t, "container-3", cxs[1].Raddr.ContainerId)
+	assert.Equal(t, model.ConnectionType_udp, cxs[1].Type)
+}
+
+func TestFormatConnectionsDropsIdentityIPTranslation(t *testing.T) {
+	conns := []ebpf.ConnectionStats{
+		{
+			Pid:    1,
+			Source: "10.0.0.1",
+			Dest:   "10.0.0.2",
+			SPort:  4242,
+			DPort:  443,
+			// the reply comes back from the destination, nothing is translated
+			IPTranslation: &netlink.IPTranslation{
+				ReplSrcIP:   "10.0.0.2",
+				ReplDstIP:   "10.0.0.1",
+				ReplSrcPort: 443,
+				ReplDstPort: 4242,
+			},
+		},
+		{
+			Pid:    2,
+			Source: "10.0.0.1",
+			Dest:   "10.0.0.2",
+			SPort:  4242,
+			DPort:  443,
+			// the source is masqueraded
+			IPTranslation: &netlink.IPTranslation{
+				ReplSrcIP:   "10.0.0.2",
+				ReplDstIP:   "172.17.0.2",
+				ReplSrcPort: 443,
+				ReplDstPort: 4242,
+			},
+		},
+	}
+
+	cxs := FormatConnectionsWithOptions(conns, ConnectionFormatOptions{DropIdentityIPTranslation: true}, nil)
+	assert.Len(t, cxs, 2)
+	assert.Nil(t, cxs[0].IpTranslation)
+	assert.Equal(t, &model.IPTranslation{ReplSrcIP: "10.0.0.2", ReplDstIP: "172.17.0.2", ReplSrcPort: 443, ReplDstPort: 4242}, cxs[1].IpTranslation)
+
+	// the translations are kept by default
+	cxs = FormatConnectionsFunc(conns, nil)
+	assert.Equal(t, &model.IPTranslation{ReplSrcIP: "10.0.0.2", ReplDstIP: "10.0.0.1", ReplSrcPort: 443, ReplDstPort: 4242}, cxs[0].IpTranslation)
+	assert.NotNil(t, cxs[1].IpTranslation)
+}
+
+// formatConnectionCases are the connections formatted by the benchmark and the allocation test
+var formatConnectionCases = []struct {
+	name string
+	conn ebpf.ConnectionStats
+}{
+	{
+		name: "ipv4",
+		conn: ebpf.ConnectionStats{Pid: 1, Source: "10.0.0.1", Dest: "10.0.0.2", SPort: 4242, DPort: 443, Family: ebpf.AFINET},
+	},
+	{
+		name: "ipv6",
+		conn: ebpf.ConnectionStats{Pid: 1, Source: "fd00::1", Dest: "2001:db8::2", SPort: 4242, DPort: 443, Family: ebpf.AFINET6},
+	},
+	{
+		name: "nat",
+		conn: ebpf.ConnectionStats{
+			Pid:    1,
+			Source: "10.0.0.1",
+			Dest:   "10.0.0.2",
+			SPort:  4242,
+			DPort:  443,
+			Family: ebpf.AFINET,
+			IPTranslation: &netlink.IPTranslation{
+				ReplSrcIP:   "10.0.0.2",
+				ReplDstIP:   "172.17.0.2",
+				ReplSrcPort: 443,
+				ReplDstPort: 4242,
+			},
+		},
+	},
+}
+
+func BenchmarkFormatConnection(b *testing.B) {
+	for _, c := range formatConnectionCases {
+		b.Run(c.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				formatConnection(c.conn, 0, ConnectionFormatOptions{})
+			}
+		})
+	}
+}
+
+// TestFormatConnectionAllocations fails when formatting a connection allocates more than its budget.
+// The addresses are already strings once decoded, so the only allocations expected are the ones of the
+// model: the connection and its two addresses, plus the IP translation when there is one. Anything more,
+// like formatting an address again, is a regression: change the budget only along with the model.
+func TestFormatConnectionAllocations(t *testing.T) {
+	budgets := map[string]float64{
+		"ipv4": 3,
+		"ipv6": 3,
+		"nat":  4,
+	}
+	for _, c := range formatConnectionCases {
+		allocs := testing.AllocsPerRun(100, func() {
+			formatConnection(c.conn, 0, ConnectionFormatOptions{})
+		})
+		assert.True(t, allocs <= budgets[c.name], "%s: %v allocations per connection, the budget is %v", c.name, allocs, budgets[c.name])
+	}
+
+	// a translation dropped as an identity is not allocated
+	identity := formatConnectionCases[0].conn
+	identity.IPTranslation = &netlink.IPTranslation{ReplSrcIP: "10.0.0.2", ReplDstIP: "10.0.0.1", ReplSrcPort: 443, ReplDstPort: 4242}
+	allocs := testing.AllocsPerRun(100, func() {
+		formatConnection(identity, 0, ConnectionFormatOptions{DropIdentityIPTranslation: true})
+	})
+	assert.True(t, allocs <= 3, "identity translation: %v allocations per connection, the budget is 3", allocs)
+}
+
+func TestFormatConnectionWithoutAddresses(t *testing.T) {
+	conn := formatConnectionCases[0].conn
+	conn.Source = nil
+	_, ok := formatConnection(conn, 0, ConnectionFormatOptions{})
+	assert.False(t, ok)
+
+	conn = formatConnectionCases[0].conn
+	conn.Dest = nil
+	_, ok = formatConnection(conn, 0, ConnectionFormatOptions{})
+	assert.False(t, ok)
+}
+
+func TestFormatConnectionTruncatesLongAddresses(t *testing.T) {
+	long := strings.Repeat("a", ebpf.DefaultMaxAddrLength+10)
+	conn := formatConnectionCases[0].conn
+	conn.Source = long
+
+	cx, ok := formatConnection(conn, 0, ConnectionFormatOptions{})
+	assert.True(t, ok)
+	assert.Equal(t, long[:ebpf.DefaultMaxAddrLength], cx.Laddr.Ip)
+
+	truncator := ebpf.NewAddrTruncator(10)
+	cx, ok = formatConnection(conn, 0, ConnectionFormatOptions{AddrTruncator: truncator})
+	assert.True(t, ok)
+	assert.Equal(t, long[:10], cx.Laddr.Ip)
+	assert.Equal(t, conn.Dest, cx.Raddr.Ip)
+	assert.Equal(t, int64(1), truncator.Truncated())
+}
+
+func TestDecodeConnections(t *testing.T) {
+	conns := []ebpf.ConnectionStats{
+		{
+			Pid:                  1,
+			NetNS:                4026531992,
+			Source:               "10.0.0.1",
+			Dest:                 "10.0.0.2",
+			SPort:                4242,
+			DPort:                443,
+			MonotonicSentBytes:   10,
+			LastSentBytes:        2,
+			MonotonicRecvBytes:   20,
+			LastRecvBytes:        4,
+			MonotonicRetransmits: 3,
+			LastRetransmits:      1,
+			Direction:            ebpf.OUTGOING,
+			IPTranslation: &netlink.IPTranslation{
+				ReplSrcIP:   "10.0.0.2",
+				ReplDstIP:   "172.17.0.2",
+				ReplSrcPort: 443,
+				ReplDstPort: 4242,
+			},
+		},
+		{
+			Pid:       2,
+			Source:    "fe80::1",
+			Dest:      "2001:db8::2",
+			SPort:     53,
+			DPort:     5353,
+			Type:      ebpf.UDP,
+			Family:    ebpf.AFINET6,
+			Direction: ebpf.INCOMING,
+		},
+		{
+			Pid:    3,
+			Source: "127.0.0.1",
+			Dest:   "127.0.0.1",
+		},
+	}
+
+	blob, err := (&model.CollectorConnections{Connections: FormatConnectionsFunc(conns, nil)}).Marshal()
+	assert.NoError(t, err)
+
+	decoded, err := DecodeConnections(blob)
+	assert.NoError(t, err)
+	assert.Equal(t, conns, decoded.Conns)
+}
+
+func TestDecodeConnectionsErrors(t *testing.T) {
+	for name, cx := range map[string]*model.Connection{
+		"missing address": {Raddr: &model.Addr{Ip: "10.0.0.1"}},
+		"invalid address": {Laddr: &model.Addr{Ip: "10.0.0"}, Raddr: &model.Addr{Ip: "10.0.0.1"}},
+		"unknown family":  {Laddr: &model.Addr{Ip: "10.0.0.1"}, Raddr: &model.Addr{Ip: "10.0.0.1"}, Family: 42},
+		"unknown type":    {Laddr: &model.Addr{Ip: "10.0.0.1"}, Raddr: &model.Addr{Ip: "10.0.0.1"}, Type: 42},
+	} {
+		blob, err := (&model.CollectorConnections{Connections: []*model.Connection{cx}}).Marshal()
+		assert.NoError(t, err)
+		_, err = DecodeConnections(blob)
+		assert.IsType(t, &ConnectionsDecodeError{}, err, name)
+	}
+
+	_, err := DecodeConnections([]byte{0xff})
+	assert.IsType(t, &ConnectionsDecodeError{}, err)
+}
+
+func TestDecodeConnectionsInvalidPayloads(t *testing.T) {
+	_, err := DecodeConnections(nil)
+	assert.Equal(t, ErrEmptyConnectionsPayload, err)
+	_, err = DecodeConnections([]byte{})
+	assert.Equal(t, ErrEmptyConnectionsPayload, err)
+
+	blob, err := (&model.CollectorConnections{Connections: []*model.Connection{
+		{Pid: 42, Laddr: &model.Addr{Ip: "10.0.0.1", Port: 1234}, Raddr: &model.Addr{Ip: "10.0.0.2", Port: 443}},
+	}}).Marshal()
+	assert.NoError(t, err)
+	for name, payload := range map[string][]byte{
+		"truncated": blob[:len(blob)-1],
+		"garbage":   {0xff, 0xff, 0xff, 0xff},
+	} {
+		_, err = DecodeConnections(payload)
+		if assert.IsType(t, &ConnectionsDecodeError{}, err, name) {
+			assert.Error(t, err.(*ConnectionsDecodeError).Err, name)
+			assert.Contains(t, err.Error(), "failed to decode connections protobuf", name)
+		}
+	}
+}
+
+func TestParseAddrAcceptsUnspecifiedAddresses(t *testing.T) {
+	for ip, expected := range map[string]string{
+		"::":              "::",
+		"0:0:0:0:0:0:0:0": "::",
+		"0.0.0.0":         "0.0.0.0",
+		"10.0.0.1":        "10.0.0.1",
+	} {
+		parsed, err := parseAddr(&model.Addr{Ip: ip})
+		assert.NoError(t, err, ip)
+		assert.Equal(t, expected, parsed, ip)
+	}
+
+	_, err := parseAddr(&model.Addr{Ip: "::g"})
+	assert.Error(t, err)
+}
+
+func TestDetectAndUnmarshal(t *testing.T) {
+	conns := []ebpf.ConnectionStats{
+		{Pid: 1, Source: "10.0.0.1", Dest: "10.0.0.2", SPort: 4242, DPort: 443, MonotonicSentBytes: 10, Direction: ebpf.OUTGOING},
+		{Pid: 2, Source: "fe80::1", Dest: "2001:db8::2", SPort: 53, DPort: 5353, Type: ebpf.UDP, Family: ebpf.AFINET6},
+	}
+
+	protobuf, err := (&model.CollectorConnections{Connections: FormatConnectionsFunc(conns, nil)}).Marshal()
+	assert.NoError(t, err)
+	decoded, err := DetectAndUnmarshal(protobuf)
+	assert.NoError(t, err)
+	assert.Equal(t, conns, decoded.Conns)
+
+	json, err := (&ebpf.Connections{Conns: conns}).MarshalJSON()
+	assert.NoError(t, err)
+	for _, blob := range [][]byte{json, append([]byte(" \n\t"), json...)} {
+		decoded, err = DetectAndUnmarshal(blob)
+		assert.NoError(t, err)
+		assert.Equal(t, conns, decoded.Conns)
+	}
+
+	for _, blob := range [][]byte{nil, []byte(" \n")} {
+		decoded, err = DetectAndUnmarshal(blob)
+		assert.NoError(t, err)
+		assert.Empty(t, decoded.Conns)
+	}
+
+	for _, blob := range [][]byte{[]byte("{not json"), {0xff}} {
+		_, err = DetectAndUnmarshal(blob)
+		assert.Error(t, err)
+	}
+}
@@ -0,0 +1,61 @@
+package checks
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/DataDog/datadog-agent/pkg/ebpf"
+	"github.com/DataDog/datadog-agent/pkg/process/model"
+)
+
+// collectorConnectionsTag is the protobuf key of the connections of a CollectorConnections,
+// a length-delimited field number 3
+const collectorConnectionsTag = 3<<3 | 2
+
+// MarshalProtobufTo writes the protobuf encoding of a CollectorConnections holding only the connections,
+// formatted like the ConnectionsCheck, to w. The connections are formatted and written one after the other,
+// each as a length-delimited Connection, so that neither the formatted connections nor the payload are held
+// in memory as a whole.
+func MarshalProtobufTo(w io.Writer, conns *ebpf.Connections) error {
+	if conns == nil {
+		return nil
+	}
+
+	// Process create-times required to construct unique process hash keys on the backend
+	createTimeForPID := Process.createTimesforPIDs(connectionStatsPIDs(conns.Conns))
+
+	var buf []byte
+	for _, conn := range conns.Conns {
+		// default creation time to ensure network connections from short-lived processes are not dropped
+		cx, ok := formatConnection(conn, createTimeForPID[conn.Pid], ConnectionFormatOptions{})
+		if !ok {
+			continue
+		}
+
+		var err error
+		if buf, err = appendConnectionProtobuf(buf[:0], cx); err != nil {
+			return err
+		}
+		if _, err = w.Write(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendConnectionProtobuf appends the connection as an element of the connections of a CollectorConnections,
+// the tag of the field, the length of the connection then the connection. b is returned unchanged on error.
+func appendConnectionProtobuf(b []byte, cx *model.Connection) ([]byte, error) {
+	size := cx.Size()
+	var header [1 + binary.MaxVarintLen64]byte
+	header[0] = collectorConnectionsTag
+	n := 1 + binary.PutUvarint(header[1:], uint64(size))
+
+	start := len(b)
+	b = append(b, header[:n]...)
+	b = append(b, make([]byte, size)...)
+	if _, err := cx.MarshalTo(b[start+n:]); err != nil {
+		return b[:start], err
+	}
+	return b, nil
+}
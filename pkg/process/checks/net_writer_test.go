@@ -0,0 +1,44 @@
+package checks
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/DataDog/datadog-agent/pkg/ebpf"
+	"github.com/DataDog/datadog-agent/pkg/process/model"
+)
+
+func TestMarshalProtobufTo(t *testing.T) {
+	conns := &ebpf.Connections{Conns: []ebpf.ConnectionStats{
+		{Pid: 1, Source: "10.0.0.1", Dest: "10.0.0.2", SPort: 4242, DPort: 443, MonotonicSentBytes: 10},
+		{Pid: 2, Source: 42, Dest: "10.0.0.3"},
+		{Pid: 3, Source: "fe80::1", Dest: "2001:db8::2", SPort: 53, DPort: 5353, Type: ebpf.UDP, Family: ebpf.AFINET6},
+	}}
+
+	var buf bytes.Buffer
+	require.NoError(t, MarshalProtobufTo(&buf, conns))
+
+	// the connections whose addresses are not strings are skipped like when formatting them
+	expected, err := (&model.CollectorConnections{Connections: FormatConnectionsFunc(conns.Conns, nil)}).Marshal()
+	require.NoError(t, err)
+	assert.Equal(t, expected, buf.Bytes())
+
+	buf.Reset()
+	require.NoError(t, MarshalProtobufTo(&buf, nil))
+	assert.Empty(t, buf.Bytes())
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestMarshalProtobufToWriteError(t *testing.T) {
+	conns := &ebpf.Connections{Conns: []ebpf.ConnectionStats{{Pid: 1, Source: "10.0.0.1", Dest: "10.0.0.2"}}}
+	assert.EqualError(t, MarshalProtobufTo(failingWriter{}, conns), "connection reset")
+}
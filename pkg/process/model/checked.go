@@ -0,0 +1,50 @@
+package model
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+
+	"github.com/gogo/protobuf/proto"
+)
+
+// checkedHeaderLength is the length of the header prepended to checked payloads:
+// the length of the payload then its CRC32C, both big endian uint32.
+const checkedHeaderLength = 4 + 4
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrChecksumMismatch is returned when a checked payload does not match its checksum.
+var ErrChecksumMismatch = errors.New("payload checksum mismatch")
+
+// MarshalProtobufChecked marshals the message with protobuf and prepends the length
+// and the CRC32C of the payload so that corrupted payloads can be detected.
+func MarshalProtobufChecked(m proto.Message) ([]byte, error) {
+	p, err := proto.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, checkedHeaderLength+len(p))
+	binary.BigEndian.PutUint32(data[0:4], uint32(len(p)))
+	binary.BigEndian.PutUint32(data[4:8], crc32.Checksum(p, castagnoli))
+	copy(data[checkedHeaderLength:], p)
+	return data, nil
+}
+
+// UnmarshalProtobufChecked verifies the length and the checksum of a payload
+// marshaled by MarshalProtobufChecked before unmarshaling it into the message,
+// ErrChecksumMismatch is returned when the payload was corrupted.
+func UnmarshalProtobufChecked(data []byte, m proto.Message) error {
+	if len(data) < checkedHeaderLength {
+		return fmt.Errorf("invalid checked payload length: %d", len(data))
+	}
+	p := data[checkedHeaderLength:]
+	if length := binary.BigEndian.Uint32(data[0:4]); int(length) != len(p) {
+		return fmt.Errorf("invalid checked payload length: expected %d, got %d", length, len(p))
+	}
+	if binary.BigEndian.Uint32(data[4:8]) != crc32.Checksum(p, castagnoli) {
+		return ErrChecksumMismatch
+	}
+	return proto.Unmarshal(p, m)
+}
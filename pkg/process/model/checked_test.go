@@ -0,0 +1,52 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newCheckedConnections() *CollectorConnections {
+	return &CollectorConnections{
+		HostName: "test",
+		Connections: []*Connection{
+			{
+				Pid:            42,
+				Laddr:          &Addr{Ip: "10.0.0.1", Port: 4242},
+				Raddr:          &Addr{Ip: "10.0.0.2", Port: 443},
+				TotalBytesSent: 100,
+			},
+		},
+	}
+}
+
+func TestProtobufChecked(t *testing.T) {
+	conns := newCheckedConnections()
+
+	data, err := MarshalProtobufChecked(conns)
+	require.NoError(t, err)
+
+	decoded := &CollectorConnections{}
+	require.NoError(t, UnmarshalProtobufChecked(data, decoded))
+	assert.Equal(t, conns, decoded)
+}
+
+func TestProtobufCheckedDetectsCorruption(t *testing.T) {
+	data, err := MarshalProtobufChecked(newCheckedConnections())
+	require.NoError(t, err)
+
+	for i := checkedHeaderLength; i < len(data); i++ {
+		corrupted := append([]byte(nil), data...)
+		corrupted[i] ^= 0x01
+		assert.Equal(t, ErrChecksumMismatch, UnmarshalProtobufChecked(corrupted, &CollectorConnections{}), "byte %d", i)
+	}
+}
+
+func TestProtobufCheckedInvalidLength(t *testing.T) {
+	data, err := MarshalProtobufChecked(newCheckedConnections())
+	require.NoError(t, err)
+
+	assert.Error(t, UnmarshalProtobufChecked(data[:4], &CollectorConnections{}))
+	assert.Error(t, UnmarshalProtobufChecked(data[:len(data)-1], &CollectorConnections{}))
+}
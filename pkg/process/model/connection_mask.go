@@ -0,0 +1,47 @@
+package model
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/gogo/protobuf/proto"
+)
+
+var connectionType = reflect.TypeOf(Connection{})
+
+// ConnectionFieldMask selects the fields of the connections to populate,
+// the other fields are zeroed so that they are omitted once marshaled.
+type ConnectionFieldMask struct {
+	keep []bool
+}
+
+// NewConnectionFieldMask returns a mask keeping the given fields, which are
+// the names of the fields of Connection in the proto, e.g. "laddr" or "totalBytesSent".
+func NewConnectionFieldMask(fields ...string) (*ConnectionFieldMask, error) {
+	props := proto.GetProperties(connectionType)
+	indexes := make(map[string]int, len(props.Prop))
+	for i, prop := range props.Prop {
+		indexes[prop.OrigName] = i
+	}
+
+	mask := &ConnectionFieldMask{keep: make([]bool, connectionType.NumField())}
+	for _, field := range fields {
+		i, ok := indexes[field]
+		if !ok {
+			return nil, fmt.Errorf("unknown connection field %q", field)
+		}
+		mask.keep[i] = true
+	}
+	return mask, nil
+}
+
+// Apply zeroes the fields of the connection not selected by the mask.
+func (m *ConnectionFieldMask) Apply(c *Connection) {
+	v := reflect.ValueOf(c).Elem()
+	for i, keep := range m.keep {
+		if !keep {
+			f := v.Field(i)
+			f.Set(reflect.Zero(f.Type()))
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/gogo/protobuf/jsonpb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newMaskedConnection(t *testing.T, fields ...string) string {
+	c := &Connection{
+		Pid:                42,
+		Laddr:              &Addr{Ip: "10.0.0.1", Port: 4242},
+		Raddr:              &Addr{Ip: "10.0.0.2", Port: 443},
+		Family:             ConnectionFamily_v6,
+		Type:               ConnectionType_udp,
+		TotalBytesSent:     100,
+		TotalBytesReceived: 200,
+		LastBytesSent:      10,
+		LastBytesReceived:  20,
+		Direction:          ConnectionDirection_outgoing,
+		NetNS:              7,
+	}
+
+	mask, err := NewConnectionFieldMask(fields...)
+	require.NoError(t, err)
+	mask.Apply(c)
+
+	marshaler := jsonpb.Marshaler{EmitDefaults: false}
+	out, err := marshaler.MarshalToString(c)
+	require.NoError(t, err)
+	return out
+}
+
+func TestConnectionFieldMaskTopology(t *testing.T) {
+	out := newMaskedConnection(t, "laddr", "raddr", "direction")
+	assert.JSONEq(t, `{"laddr":{"ip":"10.0.0.1","port":4242},"raddr":{"ip":"10.0.0.2","port":443},"direction":"outgoing"}`, out)
+}
+
+func TestConnectionFieldMaskCounters(t *testing.T) {
+	out := newMaskedConnection(t, "totalBytesSent", "totalBytesReceived")
+	assert.JSONEq(t, `{"totalBytesSent":"100","totalBytesReceived":"200"}`, out)
+	assert.NotContains(t, out, "pid")
+	assert.NotContains(t, out, "laddr")
+}
+
+func TestConnectionFieldMaskUnknownField(t *testing.T) {
+	_, err := NewConnectionFieldMask("pid", "bytesSent")
+	assert.EqualError(t, err, `unknown connection field "bytesSent"`)
+}
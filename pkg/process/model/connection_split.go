@@ -0,0 +1,37 @@
+package model
+
+// EstimateProtobufSize returns the size of the connections once marshaled with protobuf,
+// without marshaling them.
+func EstimateProtobufSize(conns *CollectorConnections) int {
+	return conns.Size()
+}
+
+// SplitBySize partitions the connections into payloads which marshal under maxBytes,
+// keeping their order. The other fields are shallow copied to every payload.
+// A connection which does not fit in maxBytes on its own gets a payload of its own, exceeding the limit.
+func SplitBySize(conns *CollectorConnections, maxBytes int) []*CollectorConnections {
+	envelope := *conns
+	envelope.Connections = nil
+	baseSize := envelope.Size()
+
+	var chunks []*CollectorConnections
+	chunk := envelope
+	size := baseSize
+	for _, c := range conns.Connections {
+		l := c.Size()
+		// the tag of the repeated field, the length of the connection then the connection
+		connSize := 1 + sovAgent(uint64(l)) + l
+		if len(chunk.Connections) > 0 && size+connSize > maxBytes {
+			full := chunk
+			chunks = append(chunks, &full)
+			chunk = envelope
+			size = baseSize
+		}
+		chunk.Connections = append(chunk.Connections, c)
+		size += connSize
+	}
+	if len(chunk.Connections) > 0 || len(chunks) == 0 {
+		chunks = append(chunks, &chunk)
+	}
+	return chunks
+}
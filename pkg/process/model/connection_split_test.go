@@ -0,0 +1,79 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newSplitConnections(n int) *CollectorConnections {
+	conns := &CollectorConnections{HostName: "test", GroupId: 1}
+	for i := 0; i < n; i++ {
+		conns.Connections = append(conns.Connections, &Connection{
+			Pid:            int32(i),
+			Laddr:          &Addr{Ip: fmt.Sprintf("10.0.0.%d", i%256), Port: int32(i)},
+			Raddr:          &Addr{Ip: "10.0.1.1", Port: 443},
+			TotalBytesSent: uint64(i * 1000),
+		})
+	}
+	return conns
+}
+
+func TestEstimateProtobufSize(t *testing.T) {
+	conns := newSplitConnections(10)
+	data, err := conns.Marshal()
+	require.NoError(t, err)
+	assert.Equal(t, len(data), EstimateProtobufSize(conns))
+}
+
+func TestSplitBySize(t *testing.T) {
+	conns := newSplitConnections(100)
+	maxBytes := 300
+
+	chunks := SplitBySize(conns, maxBytes)
+	assert.True(t, len(chunks) > 1)
+
+	var pids []int32
+	for _, chunk := range chunks {
+		data, err := chunk.Marshal()
+		require.NoError(t, err)
+		assert.True(t, len(data) <= maxBytes, "chunk of %d bytes", len(data))
+		assert.Equal(t, "test", chunk.HostName)
+		assert.Equal(t, int32(1), chunk.GroupId)
+		for _, c := range chunk.Connections {
+			pids = append(pids, c.Pid)
+		}
+	}
+	for i, pid := range pids {
+		assert.Equal(t, int32(i), pid)
+	}
+	assert.Len(t, pids, 100)
+	assert.Len(t, conns.Connections, 100)
+}
+
+func TestSplitBySizeFitsInOnePayload(t *testing.T) {
+	conns := newSplitConnections(3)
+	chunks := SplitBySize(conns, EstimateProtobufSize(conns))
+	require.Len(t, chunks, 1)
+	assert.Equal(t, conns, chunks[0])
+}
+
+func TestSplitBySizeOversizedConnection(t *testing.T) {
+	conns := newSplitConnections(3)
+	conns.Connections[1].Raddr.ContainerId = strings.Repeat("a", 500)
+
+	chunks := SplitBySize(conns, 300)
+	require.Len(t, chunks, 3)
+	assert.Equal(t, int32(1), chunks[1].Connections[0].Pid)
+	assert.True(t, EstimateProtobufSize(chunks[1]) > 300)
+}
+
+func TestSplitBySizeEmpty(t *testing.T) {
+	chunks := SplitBySize(&CollectorConnections{HostName: "test"}, 300)
+	require.Len(t, chunks, 1)
+	assert.Equal(t, "test", chunks[0].HostName)
+	assert.Empty(t, chunks[0].Connections)
+}
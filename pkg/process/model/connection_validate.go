@@ -0,0 +1,110 @@
+package model
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/gogo/protobuf/proto"
+)
+
+// InvalidConnection describes why the connection at Index is invalid
+type InvalidConnection struct {
+	Index   int
+	Reasons []string
+}
+
+// InvalidConnectionsError is returned by ValidateConnections with every invalid connection, ordered by index
+type InvalidConnectionsError struct {
+	Connections []InvalidConnection
+}
+
+func (e *InvalidConnectionsError) Error() string {
+	descriptions := make([]string, 0, len(e.Connections))
+	for _, c := range e.Connections {
+		descriptions = append(descriptions, fmt.Sprintf("connection %d: %s", c.Index, strings.Join(c.Reasons, ", ")))
+	}
+	return fmt.Sprintf("%d invalid connections: %s", len(e.Connections), strings.Join(descriptions, "; "))
+}
+
+// Indices returns the indices of the invalid connections
+func (e *InvalidConnectionsError) Indices() []int {
+	indices := make([]int, 0, len(e.Connections))
+	for _, c := range e.Connections {
+		indices = append(indices, c.Index)
+	}
+	return indices
+}
+
+// ValidateConnections checks that the addresses of every connection are IPs of its family with ports in range,
+// and that its family, type and direction are known. It returns an *InvalidConnectionsError listing every
+// invalid connection, nil when they are all valid
+func ValidateConnections(conns *CollectorConnections) error {
+	var invalid []InvalidConnection
+	for i, c := range conns.Connections {
+		if reasons := validateConnection(c); len(reasons) > 0 {
+			invalid = append(invalid, InvalidConnection{Index: i, Reasons: reasons})
+		}
+	}
+	if len(invalid) > 0 {
+		return &InvalidConnectionsError{Connections: invalid}
+	}
+	return nil
+}
+
+// UnmarshalConnectionsStrict unmarshals the connections then validates them with ValidateConnections
+func UnmarshalConnectionsStrict(data []byte, conns *CollectorConnections) error {
+	if err := proto.Unmarshal(data, conns); err != nil {
+		return err
+	}
+	return ValidateConnections(conns)
+}
+
+func validateConnection(c *Connection) []string {
+	if c == nil {
+		return []string{"missing connection"}
+	}
+	var reasons []string
+	if _, ok := ConnectionFamily_name[int32(c.Family)]; !ok {
+		reasons = append(reasons, fmt.Sprintf("unknown family %d", c.Family))
+	}
+	if _, ok := ConnectionType_name[int32(c.Type)]; !ok {
+		reasons = append(reasons, fmt.Sprintf("unknown type %d", c.Type))
+	}
+	if _, ok := ConnectionDirection_name[int32(c.Direction)]; !ok {
+		reasons = append(reasons, fmt.Sprintf("unknown direction %d", c.Direction))
+	}
+	reasons = append(reasons, validateAddr("laddr", c.Laddr, c.Family)...)
+	reasons = append(reasons, validateAddr("raddr", c.Raddr, c.Family)...)
+	return reasons
+}
+
+func validateAddr(name string, addr *Addr, family ConnectionFamily) []string {
+	if addr == nil {
+		return []string{fmt.Sprintf("missing %s", name)}
+	}
+	var reasons []string
+	if ip := net.ParseIP(addr.Ip); ip == nil {
+		reasons = append(reasons, fmt.Sprintf("invalid %s ip %q", name, addr.Ip))
+	} else if !matchesFamily(addr.Ip, family) {
+		reasons = append(reasons, fmt.Sprintf("%s ip %q is not %s", name, addr.Ip, family))
+	}
+	if addr.Port < 0 || addr.Port > 65535 {
+		reasons = append(reasons, fmt.Sprintf("invalid %s port %d", name, addr.Port))
+	}
+	return reasons
+}
+
+// matchesFamily returns false when the textual IP is not of the family, IPv4-mapped IPv6 addresses
+// being IPv6 addresses. The IPs always match an unknown family, which is reported on its own
+func matchesFamily(ip string, family ConnectionFamily) bool {
+	v6 := strings.Contains(ip, ":")
+	switch family {
+	case ConnectionFamily_v4:
+		return !v6
+	case ConnectionFamily_v6:
+		return v6
+	default:
+		return true
+	}
+}
@@ -0,0 +1,62 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newValidConnection() *Connection {
+	return &Connection{
+		Family: ConnectionFamily_v4,
+		Laddr:  &Addr{Ip: "10.0.0.1", Port: 4242},
+		Raddr:  &Addr{Ip: "10.0.0.2", Port: 443},
+	}
+}
+
+func TestValidateConnections(t *testing.T) {
+	conns := &CollectorConnections{}
+	for i := 0; i < 9; i++ {
+		conns.Connections = append(conns.Connections, newValidConnection())
+	}
+	conns.Connections[1].Laddr.Ip = ""
+	conns.Connections[3].Raddr.Ip = "10.0.0"
+	conns.Connections[4].Family = ConnectionFamily_v6
+	conns.Connections[4].Laddr.Ip = "::1"
+	conns.Connections[4].Raddr.Ip = "::ffff:10.0.0.2"
+	conns.Connections[5].Raddr.Port = 65536
+	conns.Connections[6].Type = ConnectionType(42)
+	conns.Connections[7].Raddr = nil
+	conns.Connections[8].Laddr.Ip = "fe80::1"
+
+	err := ValidateConnections(conns)
+	require.Error(t, err)
+	invalid, ok := err.(*InvalidConnectionsError)
+	require.True(t, ok)
+	assert.Equal(t, []int{1, 3, 5, 6, 7, 8}, invalid.Indices())
+	assert.Equal(t, []string{`invalid laddr ip ""`}, invalid.Connections[0].Reasons)
+	assert.Equal(t, []string{"invalid raddr port 65536"}, invalid.Connections[2].Reasons)
+	assert.Equal(t, []string{"unknown type 42"}, invalid.Connections[3].Reasons)
+	assert.Equal(t, []string{"missing raddr"}, invalid.Connections[4].Reasons)
+	assert.Equal(t, []string{`laddr ip "fe80::1" is not v4`}, invalid.Connections[5].Reasons)
+	assert.Contains(t, err.Error(), `6 invalid connections: connection 1: invalid laddr ip ""; connection 3: invalid raddr ip "10.0.0"`)
+
+	assert.NoError(t, ValidateConnections(&CollectorConnections{Connections: []*Connection{newValidConnection()}}))
+}
+
+func TestUnmarshalConnectionsStrict(t *testing.T) {
+	invalid := newValidConnection()
+	invalid.Raddr.Ip = "not an ip"
+	data, err := (&CollectorConnections{Connections: []*Connection{newValidConnection(), invalid}}).Marshal()
+	require.NoError(t, err)
+
+	var conns CollectorConnections
+	err = UnmarshalConnectionsStrict(data, &conns)
+	require.Error(t, err)
+	assert.Equal(t, []int{1}, err.(*InvalidConnectionsError).Indices())
+	assert.Len(t, conns.Connections, 2)
+
+	// the payload is not protobuf
+	assert.Error(t, UnmarshalConnectionsStrict([]byte{0xff}, &conns))
+}
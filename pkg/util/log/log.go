@@ -339,6 +339,30 @@ func buildLogEntry(v ...interface{}) string {
 	return fmt.Sprintf(fmtBuffer.String(), v...)
 }
 
+// buildLogEntryWithContext appends the context to the message as key:value pairs,
+// a key without value is logged with an empty value.
+func buildLogEntryWithContext(message string, context ...interface{}) string {
+	if len(context) == 0 {
+		return message
+	}
+	var fmtBuffer bytes.Buffer
+
+	fmtBuffer.WriteString(message)
+	fmtBuffer.WriteString(" (")
+	for i := 0; i < len(context); i += 2 {
+		if i > 0 {
+			fmtBuffer.WriteString(", ")
+		}
+		fmt.Fprintf(&fmtBuffer, "%v:", context[i])
+		if i+1 < len(context) {
+			fmt.Fprintf(&fmtBuffer, "%v", context[i+1])
+		}
+	}
+	fmtBuffer.WriteString(")")
+
+	return fmtBuffer.String()
+}
+
 func scrubMessage(message string) string {
 	msgScrubbed, err := CredentialsCleanerBytes([]byte(message))
 	if err == nil {
@@ -484,6 +508,18 @@ func Warnf(format string, params ...interface{}) error {
 	return formatErrorf(format, params...)
 }
 
+// Warnc logs the message at the warn level along with its context, alternating keys and values,
+// and returns an error containing the formated log message
+func Warnc(message string, context ...interface{}) error {
+	s := buildLogEntryWithContext(message, context...)
+	if logger != nil && logger.inner != nil && logger.shouldLog(seelog.WarnLvl) {
+		return logger.warn(s)
+	} else if bufferLogsBeforeInit && (logger == nil || logger.inner == nil) {
+		addLogToBuffer(func() { Warnc(message, context...) })
+	}
+	return formatError(s)
+}
+
 // Errorf logs with format at the error level and returns an error containing the formated log message
 func Errorf(format string, params ...interface{}) error {
 	if logger != nil && logger.inner != nil && logger.shouldLog(seelog.ErrorLvl) {
@@ -174,6 +174,41 @@ func TestWarnfNotNil(t *testing.T) {
 	assert.NotNil(t, Warn("test"))
 }
 
+func TestWarncNotNil(t *testing.T) {
+	var b bytes.Buffer
+	w := bufio.NewWriter(&b)
+
+	assert.NotNil(t, Warnc("test", "key", "value"))
+
+	l, _ := seelog.LoggerFromWriterWithMinLevelAndFormat(w, seelog.CriticalLvl, "[%LEVEL] %FuncShort: %Msg")
+	SetupDatadogLogger(l, "critical")
+
+	assert.NotNil(t, Warnc("test", "key", "value"))
+
+	changeLogLevel("info")
+
+	assert.NotNil(t, Warnc("test", "key", "value"))
+}
+
+func TestWarncLogsTheContext(t *testing.T) {
+	var b bytes.Buffer
+	w := bufio.NewWriter(&b)
+
+	l, err := seelog.LoggerFromWriterWithMinLevelAndFormat(w, seelog.DebugLvl, "[%LEVEL] %Msg")
+	assert.Nil(t, err)
+	SetupDatadogLogger(l, "debug")
+
+	Warnc("foo", "size", 10, "reason", "timeout")
+	Warnc("bar")
+	Warnc("baz", "key")
+	w.Flush()
+
+	assert.Contains(t, b.String(), "foo (size:10, reason:timeout)")
+	assert.Contains(t, b.String(), "bar")
+	assert.NotContains(t, b.String(), "bar (")
+	assert.Contains(t, b.String(), "baz (key:)")
+}
+
 func TestErrorNotNil(t *testing.T) {
 	var b bytes.Buffer
 	w := bufio.NewWriter(&b)